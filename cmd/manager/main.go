@@ -20,6 +20,7 @@ import (
 	"flag"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	configv1 "github.com/openshift/api/config/v1"
@@ -28,6 +29,8 @@ import (
 	"k8s.io/klog/v2"
 	"k8s.io/klog/v2/klogr"
 	"sigs.k8s.io/cluster-api-provider-openstack/pkg/apis"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/bootstraptoken"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/cluster"
 	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/machineset"
 	"sigs.k8s.io/cluster-api-provider-openstack/pkg/controller"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -83,6 +86,12 @@ func main() {
 		"Address for hosting metrics",
 	)
 
+	gpuPCIAliases := flag.String(
+		"gpu-pci-aliases",
+		"",
+		"Comma separated list of the PCI passthrough aliases of flavors whose devices are GPUs. The GPUs of flavors are published on MachineSets for scaling from zero.",
+	)
+
 	klog.InitFlags(nil)
 	flag.Parse()
 
@@ -137,13 +146,30 @@ func main() {
 	ctrl.SetLogger(klogr.New())
 	setupLog := ctrl.Log.WithName("setup")
 	if err = (&machineset.Reconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("MachineSet"),
+		Client:        mgr.GetClient(),
+		Log:           ctrl.Log.WithName("controllers").WithName("MachineSet"),
+		GPUPCIAliases: splitList(*gpuPCIAliases),
 	}).SetupWithManager(mgr, rTcontroller.Options{}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "MachineSet")
 		os.Exit(1)
 	}
 
+	if err = (&bootstraptoken.Reconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("BootstrapToken"),
+	}).SetupWithManager(mgr, rTcontroller.Options{}); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "BootstrapToken")
+		os.Exit(1)
+	}
+
+	if err = (&cluster.Reconciler{
+		Log:       ctrl.Log.WithName("controllers").WithName("Cluster"),
+		Namespace: opts.Namespace,
+	}).SetupWithManager(mgr, rTcontroller.Options{}); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Cluster")
+		os.Exit(1)
+	}
+
 	if err := mgr.AddReadyzCheck("ping", healthz.Ping); err != nil {
 		klog.Fatal(err)
 	}
@@ -157,3 +183,14 @@ func main() {
 	// Start the Cmd
 	log.Fatal(mgr.Start(signals.SetupSignalHandler()))
 }
+
+// splitList returns the non empty items of a comma separated list.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
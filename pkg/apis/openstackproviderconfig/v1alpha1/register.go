@@ -51,6 +51,35 @@ func MachineSpecFromProviderSpec(providerSpec machinev1.ProviderSpec) (*Openstac
 	return &config, nil
 }
 
+// MachineStatusFromProviderStatus unmarshals a raw extension into an OpenStack machine provider status type
+func MachineStatusFromProviderStatus(providerStatus *runtime.RawExtension) (*OpenstackMachineProviderStatus, error) {
+	status := &OpenstackMachineProviderStatus{}
+	if providerStatus != nil && len(providerStatus.Raw) > 0 {
+		if err := yaml.Unmarshal(providerStatus.Raw, status); err != nil {
+			return nil, err
+		}
+	}
+	status.APIVersion = SchemeGroupVersion.String()
+	status.Kind = "OpenstackMachineProviderStatus"
+	return status, nil
+}
+
+// EncodeMachineStatus marshals an OpenStack machine provider status into a raw extension
+func EncodeMachineStatus(status *OpenstackMachineProviderStatus) (*runtime.RawExtension, error) {
+	if status == nil {
+		return &runtime.RawExtension{}, nil
+	}
+
+	rawBytes, err := json.Marshal(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return &runtime.RawExtension{
+		Raw: rawBytes,
+	}, nil
+}
+
 func EncodeClusterStatus(status *OpenstackClusterProviderStatus) (*runtime.RawExtension, error) {
 	if status == nil {
 		return &runtime.RawExtension{}, nil
@@ -45,6 +45,11 @@ type OpenstackProviderSpec struct {
 	// If the RootVolume is specified, this will be ignored and use rootVolume directly.
 	Image string `json:"image"`
 
+	// Selects the image to use for your server instance when neither Image
+	// nor the SourceUUID of an image RootVolume is set. When several images
+	// match, the most recently created one is used.
+	ImageFilter *ImageFilter `json:"imageFilter,omitempty"`
+
 	// The ssh key to inject in the instance
 	KeyName string `json:"keyName,omitempty"`
 
@@ -66,9 +71,39 @@ type OpenstackProviderSpec struct {
 	// The names of the security groups to assign to the instance
 	SecurityGroups []SecurityGroupParam `json:"securityGroups,omitempty"`
 
+	// UpdatePortSecurity updates the security groups, allowed address pairs
+	// and port security of the ports of an existing server when they differ
+	// from the provider spec. These changes may interrupt the traffic of the
+	// server, so by default only missing tags and allowed address pairs are
+	// added to the ports.
+	UpdatePortSecurity bool `json:"updatePortSecurity,omitempty"`
+
+	// DetachPorts detaches and deletes the ports attached to a running server
+	// once they are removed from the provider spec. Only the ports attached
+	// after the server was created, which are listed in the provider status,
+	// are detached.
+	DetachPorts bool `json:"detachPorts,omitempty"`
+
+	// Hostname is a template of the hostname of the machine, set as DNS name
+	// of its ports when Neutron has the dns-integration extension. It may
+	// refer to {{.MachineName}} and {{.ClusterName}}. Defaults to the name
+	// of the machine.
+	Hostname string `json:"hostname,omitempty"`
+
 	// The name of the secret containing the user data (startup script in most cases)
 	UserDataSecret *corev1.SecretReference `json:"userDataSecret,omitempty"`
 
+	// The role of the machine, master or worker, deciding how its user data
+	// is rendered. Defaults to the machine.openshift.io/cluster-api-machine-role
+	// label of the machine.
+	Role string `json:"role,omitempty"`
+
+	// The bootstrap token of worker machines
+	BootstrapToken *BootstrapTokenOptions `json:"bootstrapToken,omitempty"`
+
+	// Files added to the user data by the ignition postprocessor
+	IgnitionFiles []IgnitionFile `json:"ignitionFiles,omitempty"`
+
 	// Whether the server instance is created on a trunk port or not.
 	Trunk bool `json:"trunk,omitempty"`
 
@@ -79,9 +114,15 @@ type OpenstackProviderSpec struct {
 	// Metadata mapping. Allows you to create a map of key value pairs to add to the server instance.
 	ServerMetadata map[string]string `json:"serverMetadata,omitempty"`
 
-	// Config Drive support
+	// Config Drive support. Defaults to true when a port of the server has an
+	// address on a subnet without DHCP, which then gets its network
+	// configuration from the network_data.json of the config drive.
 	ConfigDrive *bool `json:"configDrive,omitempty"`
 
+	// Files injected in the server by Nova, in the config drive or the
+	// metadata service
+	InjectedFiles []InjectedFile `json:"injectedFiles,omitempty"`
+
 	// The volume metadata to boot from
 	RootVolume *RootVolume `json:"rootVolume,omitempty"`
 
@@ -96,6 +137,51 @@ type OpenstackProviderSpec struct {
 
 	// The subnet that a set of machines will get ingress/egress traffic from
 	PrimarySubnet string `json:"primarySubnet,omitempty"`
+
+	// Remediation configures how the actuator reacts when the server of an
+	// existing machine is found in ERROR or in a stopped state.
+	// If unset, the server state is only reported on the machine.
+	Remediation *InstanceRemediation `json:"remediation,omitempty"`
+
+	// DeleteFailedInstance deletes a server which went to ERROR while it was
+	// being created, so that the creation of the machine can be retried.
+	DeleteFailedInstance bool `json:"deleteFailedInstance,omitempty"`
+
+	// FallbackAvailabilityZones are tried in order when the server cannot be
+	// built in AvailabilityZone because no host with enough capacity was found.
+	// The failed server is deleted before trying the next availability zone.
+	FallbackAvailabilityZones []string `json:"fallbackAvailabilityZones,omitempty"`
+
+	// FallbackFlavors are tried in order, in every availability zone, when the
+	// server cannot be built with Flavor because no host with enough capacity
+	// was found.
+	FallbackFlavors []string `json:"fallbackFlavors,omitempty"`
+}
+
+// RemediationAction is the action taken against a server which is not ACTIVE.
+type RemediationAction string
+
+const (
+	// RemediationActionNone only reports the server state on the machine.
+	RemediationActionNone RemediationAction = "None"
+	// RemediationActionHardReboot hard reboots servers in ERROR or SHUTOFF state.
+	RemediationActionHardReboot RemediationAction = "HardReboot"
+	// RemediationActionStart starts, unpauses, resumes or unshelves the server
+	// depending on its state.
+	RemediationActionStart RemediationAction = "Start"
+)
+
+type InstanceRemediation struct {
+	// Action is the remediation to attempt. Defaults to None.
+	Action RemediationAction `json:"action,omitempty"`
+
+	// MaxAttempts is the number of remediation attempts made for a server
+	// before giving up. Defaults to 3.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+
+	// BackoffSeconds is the time to wait after the first attempt before trying
+	// again. It doubles after every attempt. Defaults to 60.
+	BackoffSeconds int `json:"backoffSeconds,omitempty"`
 }
 
 type SecurityGroupParam struct {
@@ -126,20 +212,45 @@ type SecurityGroupFilter struct {
 type NetworkParam struct {
 	// The UUID of the network. Required if you omit the port attribute.
 	UUID string `json:"uuid,omitempty"`
-	// A fixed IPv4 address for the NIC.
+	// A fixed IP address for the NIC. If the network has subnets, the port
+	// gets the address on the subnet containing it.
 	FixedIp string `json:"fixedIp,omitempty"`
+	// IPPool allocates the fixed IP address of the NIC from a pool, unique
+	// for each machine. It cannot be used with fixedIp.
+	IPPool *IPPoolReference `json:"ipPool,omitempty"`
 	// Filters for optional network query
 	Filter Filter `json:"filter,omitempty"`
 	// Subnet within a network to use
 	Subnets []SubnetParam `json:"subnets,omitempty"`
 	// NoAllowedAddressPairs disables creation of allowed address pairs for the network ports
 	NoAllowedAddressPairs bool `json:"noAllowedAddressPairs,omitempty"`
+	// AllowedAddressPairs are added to the ports created in this network,
+	// along with the cluster VIPs
+	AllowedAddressPairs []AddressPair `json:"allowedAddressPairs,omitempty"`
+	// ClusterVIPs are the VIPs of the cluster added as allowed address pairs
+	// to the ports created in this network. Defaults to all of them.
+	ClusterVIPs []ClusterVIP `json:"clusterVIPs,omitempty"`
 	// PortTags allows users to specify a list of tags to add to ports created in a given network
 	PortTags []string          `json:"portTags,omitempty"`
 	VNICType string            `json:"vnicType,omitempty"`
 	Profile  map[string]string `json:"profile,omitempty"`
 	// PortSecurity optionally enables or disables security on ports managed by OpenStack
 	PortSecurity *bool `json:"portSecurity,omitempty"`
+	// QoSPolicy is the QoS policy applied to ports created in this network
+	QoSPolicy *QoSPolicyParam `json:"qosPolicy,omitempty"`
+	// DNSDomain is the DNS domain of the ports created in this network.
+	// Requires the dns-domain-ports extension of Neutron.
+	DNSDomain string `json:"dnsDomain,omitempty"`
+}
+
+// IPPoolReference refers to a ConfigMap in the namespace of the machine
+// holding a pool of IP addresses. The "addresses" key lists the CIDRs and
+// ranges (first-last) of the pool, one per line, and the optional "exclude"
+// key lists the ones not to allocate. The allocations of the pool are kept in
+// its "allocations" key.
+type IPPoolReference struct {
+	// Name of the ConfigMap.
+	Name string `json:"name"`
 }
 
 type Filter struct {
@@ -173,8 +284,36 @@ type SubnetParam struct {
 
 	// PortSecurity optionally enables or disables security on ports managed by OpenStack
 	PortSecurity *bool `json:"portSecurity,omitempty"`
+
+	// QoSPolicy is the QoS policy applied to ports created on this subnet.
+	// If not provided, the QoS policy of the network is inherited.
+	QoSPolicy *QoSPolicyParam `json:"qosPolicy,omitempty"`
+
+	// AllowedAddressPairs are added to the ports created on this subnet, along
+	// with those of the network
+	AllowedAddressPairs []AddressPair `json:"allowedAddressPairs,omitempty"`
+
+	// ClusterVIPs are the VIPs of the cluster added as allowed address pairs
+	// to the ports created on this subnet. If not provided, the cluster VIPs
+	// of the network are inherited.
+	ClusterVIPs []ClusterVIP `json:"clusterVIPs,omitempty"`
 }
 
+// ClusterVIP is a kind of VIP of the cluster.
+type ClusterVIP string
+
+const (
+	// ClusterVIPAPI is the internal API VIP.
+	ClusterVIPAPI ClusterVIP = "API"
+	// ClusterVIPIngress is the ingress VIP.
+	ClusterVIPIngress ClusterVIP = "Ingress"
+	// ClusterVIPDNS is the node DNS VIP.
+	ClusterVIPDNS ClusterVIP = "DNS"
+	// ClusterVIPNone adds none of the cluster VIPs, and cannot be used with
+	// the other kinds.
+	ClusterVIPNone ClusterVIP = "None"
+)
+
 type SubnetFilter struct {
 	Name            string `json:"name,omitempty"`
 	Description     string `json:"description,omitempty"`
@@ -230,9 +369,74 @@ type PortOpts struct {
 
 	// Enables and disables trunk at port level. If not provided, openStackMachine.Spec.Trunk is inherited.
 	Trunk *bool `json:"trunk,omitempty"`
+
+	// The QoS policy applied to the port
+	QoSPolicy *QoSPolicyParam `json:"qosPolicy,omitempty"`
+
+	// Subports added to the trunk of the port. Trunk must be enabled on the port.
+	Subports []SubportOpts `json:"subports,omitempty"`
+
+	// The DNS name of the port. Defaults to the hostname of the machine.
+	DNSName string `json:"dnsName,omitempty"`
+	// The DNS domain of the port. Requires the dns-domain-ports extension of
+	// Neutron.
+	DNSDomain string `json:"dnsDomain,omitempty"`
+}
+
+// SubportOpts is a subport of the trunk of a port. Either PortID or NetworkID
+// must be set.
+type SubportOpts struct {
+	// The ID of an existing port added as subport
+	PortID string `json:"portID,omitempty"`
+
+	// The network on which the port of the subport is created
+	NetworkID string `json:"networkID,omitempty"`
+	// The suffix of the name of the port of the subport. Defaults to the
+	// network ID.
+	NameSuffix string     `json:"nameSuffix,omitempty"`
+	FixedIPs   []FixedIPs `json:"fixedIPs,omitempty"`
+	// Tags added to the port of the subport, along with the machine tags
+	Tags []string `json:"tags,omitempty"`
+
+	// The segmentation type of the subport, vlan or inherit. Defaults to vlan.
+	SegmentationType string `json:"segmentationType,omitempty"`
+	// The VLAN ID of the subport. If unset, the lowest VLAN ID unused on the
+	// trunk is assigned. Must be unset with the inherit segmentation type.
+	SegmentationID int `json:"segmentationID,omitempty"`
+}
+
+// QoSPolicyParam selects a Neutron QoS policy, which must match exactly one
+// policy.
+type QoSPolicyParam struct {
+	// QoS policy UID
+	UUID string `json:"uuid,omitempty"`
+	// QoS policy name
+	Name string `json:"name,omitempty"`
+	// Filters used to query the QoS policy in openstack
+	Filter QoSPolicyFilter `json:"filter,omitempty"`
+}
+
+type QoSPolicyFilter struct {
+	ID             string `json:"id,omitempty"`
+	TenantID       string `json:"tenantId,omitempty"`
+	ProjectID      string `json:"projectId,omitempty"`
+	Name           string `json:"name,omitempty"`
+	Description    string `json:"description,omitempty"`
+	RevisionNumber *int   `json:"revisionNumber,omitempty"`
+	IsDefault      *bool  `json:"isDefault,omitempty"`
+	Shared         *bool  `json:"shared,omitempty"`
+	Limit          int    `json:"limit,omitempty"`
+	Marker         string `json:"marker,omitempty"`
+	SortKey        string `json:"sortKey,omitempty"`
+	SortDir        string `json:"sortDir,omitempty"`
+	Tags           string `json:"tags,omitempty"`
+	TagsAny        string `json:"tagsAny,omitempty"`
+	NotTags        string `json:"notTags,omitempty"`
+	NotTagsAny     string `json:"notTagsAny,omitempty"`
 }
 
 type AddressPair struct {
+	// An IP address or CIDR
 	IPAddress  string `json:"ipAddress,omitempty"`
 	MACAddress string `json:"macAddress,omitempty"`
 }
@@ -242,6 +446,64 @@ type FixedIPs struct {
 	IPAddress string `json:"ipAddress,omitempty"`
 }
 
+// BootstrapTokenOptions configures the bootstrap token of a worker machine.
+type BootstrapTokenOptions struct {
+	// Usages of the token, authentication and signing by default.
+	Usages []string `json:"usages,omitempty"`
+
+	// Extra groups the token authenticates as, which must start with
+	// system:bootstrappers:. Defaults to system:bootstrappers:kubeadm:default-node-token.
+	Groups []string `json:"groups,omitempty"`
+
+	// How long the token is valid. Defaults to the --token_ttl flag of the
+	// machine controller.
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+}
+
+// IgnitionFile is a file written on the machine by Ignition, whose content
+// is taken from a secret.
+type IgnitionFile struct {
+	// Absolute path of the file.
+	Path string `json:"path"`
+
+	// Permissions of the file, 0644 by default.
+	Mode *int `json:"mode,omitempty"`
+
+	// The key of the secret holding the content of the file. The secret is
+	// in the namespace of the machine.
+	SecretKeyRef corev1.SecretKeySelector `json:"secretKeyRef"`
+}
+
+// InjectedFile is a small file injected in a server by Nova, whose content is
+// taken from a secret.
+type InjectedFile struct {
+	// Absolute path of the file.
+	Path string `json:"path"`
+
+	// The key of the secret holding the content of the file. The secret is
+	// in the namespace of the machine.
+	SecretKeyRef corev1.SecretKeySelector `json:"secretKeyRef"`
+}
+
+// ImageFilter selects an active image by its attributes. All the given
+// attributes must match.
+type ImageFilter struct {
+	// The name of the image.
+	Name string `json:"name,omitempty"`
+
+	// Tags which must all be set on the image.
+	Tags []string `json:"tags,omitempty"`
+
+	// The visibility of the image: public, private, shared or community.
+	Visibility string `json:"visibility,omitempty"`
+
+	// The ID of the project owning the image.
+	Owner string `json:"owner,omitempty"`
+
+	// Properties which must be set on the image with the given values.
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
 type RootVolume struct {
 	SourceType string `json:"sourceType,omitempty"`
 	SourceUUID string `json:"sourceUUID,omitempty"`
@@ -254,12 +516,64 @@ type RootVolume struct {
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
+// OpenstackMachineProviderStatus is the type that will be embedded in a Machine.Status.ProviderStatus field.
+// It contains OpenStack specific status information.
+// +k8s:openapi-gen=true
+type OpenstackMachineProviderStatus struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// CreationAttempts records the most recent attempts to build the server
+	// of the machine, including the ones made in fallback availability zones
+	// or with fallback flavors.
+	CreationAttempts []CreationAttempt `json:"creationAttempts,omitempty"`
+
+	// AttachedPorts are the ports attached to the server of the machine after
+	// it was created, when they were added to the provider spec.
+	AttachedPorts []AttachedPort `json:"attachedPorts,omitempty"`
+}
+
+// AttachedPort is a port attached to a running server.
+type AttachedPort struct {
+	// ID of the port.
+	ID string `json:"id"`
+	// Name of the port.
+	Name string `json:"name"`
+	// NetworkID is the ID of the network of the port.
+	NetworkID string `json:"networkID,omitempty"`
+}
+
+// CreationAttempt records a single attempt to build the server of a machine.
+type CreationAttempt struct {
+	// Time is when the attempt was made.
+	Time metav1.Time `json:"time"`
+	// AvailabilityZone the server was requested in.
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+	// Flavor the server was requested with.
+	Flavor string `json:"flavor"`
+	// InstanceID is the ID of the server, if one was created.
+	InstanceID string `json:"instanceID,omitempty"`
+	// Reason is empty if the server became ACTIVE, and describes why the
+	// attempt failed otherwise.
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable description of the failure.
+	Message string `json:"message,omitempty"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
 // OpenstackClusterProviderSpec is the providerSpec for OpenStack in the cluster object
 // +k8s:openapi-gen=true
 type OpenstackClusterProviderSpec struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
+	// The name of the secret containing the openstack credentials
+	CloudsSecret *corev1.SecretReference `json:"cloudsSecret,omitempty"`
+
+	// The name of the cloud to use from the clouds secret
+	CloudName string `json:"cloudName,omitempty"`
+
 	// NodeCIDR is the OpenStack Subnet to be created. Cluster actuator will create a
 	// network, a subnet with NodeCIDR, and a router connected to this subnet.
 	// If you leave this empty, no network will be created.
@@ -276,6 +590,10 @@ type OpenstackClusterProviderSpec struct {
 	// machines belonging to that group. In the future, we could make this more flexible.
 	ManagedSecurityGroups bool `json:"managedSecurityGroups"`
 
+	// ManagedSecurityGroupRules are added to the managed security groups,
+	// along with their default rules.
+	ManagedSecurityGroupRules *ManagedSecurityGroupRules `json:"managedSecurityGroupRules,omitempty"`
+
 	// Tags for all resources in cluster
 	Tags []string `json:"tags,omitempty"`
 
@@ -283,6 +601,15 @@ type OpenstackClusterProviderSpec struct {
 	DisableServerTags bool `json:"disableServerTags,omitempty"`
 }
 
+// ManagedSecurityGroupRules are the rules added to the managed security groups
+// by role. A rule with "self" as remote group ID refers to its own group.
+type ManagedSecurityGroupRules struct {
+	// Rules of the security group of the control plane machines
+	ControlPlane []SecurityGroupRule `json:"controlPlane,omitempty"`
+	// Rules of the security group of all the machines
+	All []SecurityGroupRule `json:"all,omitempty"`
+}
+
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
@@ -332,6 +659,7 @@ type Router struct {
 
 func init() {
 	SchemeBuilder.Register(&OpenstackProviderSpec{})
+	SchemeBuilder.Register(&OpenstackMachineProviderStatus{})
 	SchemeBuilder.Register(&OpenstackClusterProviderSpec{})
 	SchemeBuilder.Register(&OpenstackClusterProviderStatus{})
 }
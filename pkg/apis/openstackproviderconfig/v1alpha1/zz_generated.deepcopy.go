@@ -23,9 +23,74 @@ package v1alpha1
 
 import (
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CreationAttempt) DeepCopyInto(out *CreationAttempt) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CreationAttempt.
+func (in *CreationAttempt) DeepCopy() *CreationAttempt {
+	if in == nil {
+		return nil
+	}
+	out := new(CreationAttempt)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AttachedPort) DeepCopyInto(out *AttachedPort) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AttachedPort.
+func (in *AttachedPort) DeepCopy() *AttachedPort {
+	if in == nil {
+		return nil
+	}
+	out := new(AttachedPort)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BootstrapTokenOptions) DeepCopyInto(out *BootstrapTokenOptions) {
+	*out = *in
+	if in.Usages != nil {
+		in, out := &in.Usages, &out.Usages
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Groups != nil {
+		in, out := &in.Groups, &out.Groups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.TTL != nil {
+		in, out := &in.TTL, &out.TTL
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BootstrapTokenOptions.
+func (in *BootstrapTokenOptions) DeepCopy() *BootstrapTokenOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(BootstrapTokenOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Filter) DeepCopyInto(out *Filter) {
 	*out = *in
@@ -52,6 +117,105 @@ func (in *Filter) DeepCopy() *Filter {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPPoolReference) DeepCopyInto(out *IPPoolReference) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPPoolReference.
+func (in *IPPoolReference) DeepCopy() *IPPoolReference {
+	if in == nil {
+		return nil
+	}
+	out := new(IPPoolReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IgnitionFile) DeepCopyInto(out *IgnitionFile) {
+	*out = *in
+	if in.Mode != nil {
+		in, out := &in.Mode, &out.Mode
+		*out = new(int)
+		**out = **in
+	}
+	in.SecretKeyRef.DeepCopyInto(&out.SecretKeyRef)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IgnitionFile.
+func (in *IgnitionFile) DeepCopy() *IgnitionFile {
+	if in == nil {
+		return nil
+	}
+	out := new(IgnitionFile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageFilter) DeepCopyInto(out *ImageFilter) {
+	*out = *in
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Properties != nil {
+		in, out := &in.Properties, &out.Properties
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageFilter.
+func (in *ImageFilter) DeepCopy() *ImageFilter {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageFilter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InjectedFile) DeepCopyInto(out *InjectedFile) {
+	*out = *in
+	in.SecretKeyRef.DeepCopyInto(&out.SecretKeyRef)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InjectedFile.
+func (in *InjectedFile) DeepCopy() *InjectedFile {
+	if in == nil {
+		return nil
+	}
+	out := new(InjectedFile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InstanceRemediation) DeepCopyInto(out *InstanceRemediation) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InstanceRemediation.
+func (in *InstanceRemediation) DeepCopy() *InstanceRemediation {
+	if in == nil {
+		return nil
+	}
+	out := new(InstanceRemediation)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Network) DeepCopyInto(out *Network) {
 	*out = *in
@@ -78,9 +242,40 @@ func (in *Network) DeepCopy() *Network {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManagedSecurityGroupRules) DeepCopyInto(out *ManagedSecurityGroupRules) {
+	*out = *in
+	if in.ControlPlane != nil {
+		in, out := &in.ControlPlane, &out.ControlPlane
+		*out = make([]SecurityGroupRule, len(*in))
+		copy(*out, *in)
+	}
+	if in.All != nil {
+		in, out := &in.All, &out.All
+		*out = make([]SecurityGroupRule, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManagedSecurityGroupRules.
+func (in *ManagedSecurityGroupRules) DeepCopy() *ManagedSecurityGroupRules {
+	if in == nil {
+		return nil
+	}
+	out := new(ManagedSecurityGroupRules)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NetworkParam) DeepCopyInto(out *NetworkParam) {
 	*out = *in
+	if in.IPPool != nil {
+		in, out := &in.IPPool, &out.IPPool
+		*out = new(IPPoolReference)
+		**out = **in
+	}
 	in.Filter.DeepCopyInto(&out.Filter)
 	if in.Subnets != nil {
 		in, out := &in.Subnets, &out.Subnets
@@ -89,6 +284,21 @@ func (in *NetworkParam) DeepCopyInto(out *NetworkParam) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.AllowedAddressPairs != nil {
+		in, out := &in.AllowedAddressPairs, &out.AllowedAddressPairs
+		*out = make([]AddressPair, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClusterVIPs != nil {
+		in, out := &in.ClusterVIPs, &out.ClusterVIPs
+		*out = make([]ClusterVIP, len(*in))
+		copy(*out, *in)
+	}
+	if in.QoSPolicy != nil {
+		in, out := &in.QoSPolicy, &out.QoSPolicy
+		*out = new(QoSPolicyParam)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -107,11 +317,21 @@ func (in *OpenstackClusterProviderSpec) DeepCopyInto(out *OpenstackClusterProvid
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if in.CloudsSecret != nil {
+		in, out := &in.CloudsSecret, &out.CloudsSecret
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
 	if in.DNSNameservers != nil {
 		in, out := &in.DNSNameservers, &out.DNSNameservers
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.ManagedSecurityGroupRules != nil {
+		in, out := &in.ManagedSecurityGroupRules, &out.ManagedSecurityGroupRules
+		*out = new(ManagedSecurityGroupRules)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Tags != nil {
 		in, out := &in.Tags, &out.Tags
 		*out = make([]string, len(*in))
@@ -179,6 +399,43 @@ func (in *OpenstackClusterProviderStatus) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OpenstackMachineProviderStatus) DeepCopyInto(out *OpenstackMachineProviderStatus) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	if in.CreationAttempts != nil {
+		in, out := &in.CreationAttempts, &out.CreationAttempts
+		*out = make([]CreationAttempt, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AttachedPorts != nil {
+		in, out := &in.AttachedPorts, &out.AttachedPorts
+		*out = make([]AttachedPort, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OpenstackMachineProviderStatus.
+func (in *OpenstackMachineProviderStatus) DeepCopy() *OpenstackMachineProviderStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OpenstackMachineProviderStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OpenstackMachineProviderStatus) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OpenstackProviderSpec) DeepCopyInto(out *OpenstackProviderSpec) {
 	*out = *in
@@ -189,6 +446,11 @@ func (in *OpenstackProviderSpec) DeepCopyInto(out *OpenstackProviderSpec) {
 		*out = new(v1.SecretReference)
 		**out = **in
 	}
+	if in.ImageFilter != nil {
+		in, out := &in.ImageFilter, &out.ImageFilter
+		*out = new(ImageFilter)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Networks != nil {
 		in, out := &in.Networks, &out.Networks
 		*out = make([]NetworkParam, len(*in))
@@ -206,6 +468,18 @@ func (in *OpenstackProviderSpec) DeepCopyInto(out *OpenstackProviderSpec) {
 		*out = new(v1.SecretReference)
 		**out = **in
 	}
+	if in.BootstrapToken != nil {
+		in, out := &in.BootstrapToken, &out.BootstrapToken
+		*out = new(BootstrapTokenOptions)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IgnitionFiles != nil {
+		in, out := &in.IgnitionFiles, &out.IgnitionFiles
+		*out = make([]IgnitionFile, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.Tags != nil {
 		in, out := &in.Tags, &out.Tags
 		*out = make([]string, len(*in))
@@ -223,11 +497,33 @@ func (in *OpenstackProviderSpec) DeepCopyInto(out *OpenstackProviderSpec) {
 		*out = new(bool)
 		**out = **in
 	}
+	if in.InjectedFiles != nil {
+		in, out := &in.InjectedFiles, &out.InjectedFiles
+		*out = make([]InjectedFile, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.RootVolume != nil {
 		in, out := &in.RootVolume, &out.RootVolume
 		*out = new(RootVolume)
 		**out = **in
 	}
+	if in.Remediation != nil {
+		in, out := &in.Remediation, &out.Remediation
+		*out = new(InstanceRemediation)
+		**out = **in
+	}
+	if in.FallbackAvailabilityZones != nil {
+		in, out := &in.FallbackAvailabilityZones, &out.FallbackAvailabilityZones
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.FallbackFlavors != nil {
+		in, out := &in.FallbackFlavors, &out.FallbackFlavors
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -249,6 +545,54 @@ func (in *OpenstackProviderSpec) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QoSPolicyFilter) DeepCopyInto(out *QoSPolicyFilter) {
+	*out = *in
+	if in.RevisionNumber != nil {
+		in, out := &in.RevisionNumber, &out.RevisionNumber
+		*out = new(int)
+		**out = **in
+	}
+	if in.IsDefault != nil {
+		in, out := &in.IsDefault, &out.IsDefault
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Shared != nil {
+		in, out := &in.Shared, &out.Shared
+		*out = new(bool)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QoSPolicyFilter.
+func (in *QoSPolicyFilter) DeepCopy() *QoSPolicyFilter {
+	if in == nil {
+		return nil
+	}
+	out := new(QoSPolicyFilter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QoSPolicyParam) DeepCopyInto(out *QoSPolicyParam) {
+	*out = *in
+	in.Filter.DeepCopyInto(&out.Filter)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QoSPolicyParam.
+func (in *QoSPolicyParam) DeepCopy() *QoSPolicyParam {
+	if in == nil {
+		return nil
+	}
+	out := new(QoSPolicyParam)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RootVolume) DeepCopyInto(out *RootVolume) {
 	*out = *in
@@ -392,6 +736,21 @@ func (in *SubnetFilter) DeepCopy() *SubnetFilter {
 func (in *SubnetParam) DeepCopyInto(out *SubnetParam) {
 	*out = *in
 	in.Filter.DeepCopyInto(&out.Filter)
+	if in.QoSPolicy != nil {
+		in, out := &in.QoSPolicy, &out.QoSPolicy
+		*out = new(QoSPolicyParam)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AllowedAddressPairs != nil {
+		in, out := &in.AllowedAddressPairs, &out.AllowedAddressPairs
+		*out = make([]AddressPair, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClusterVIPs != nil {
+		in, out := &in.ClusterVIPs, &out.ClusterVIPs
+		*out = make([]ClusterVIP, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -404,3 +763,29 @@ func (in *SubnetParam) DeepCopy() *SubnetParam {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SubportOpts) DeepCopyInto(out *SubportOpts) {
+	*out = *in
+	if in.FixedIPs != nil {
+		in, out := &in.FixedIPs, &out.FixedIPs
+		*out = make([]FixedIPs, len(*in))
+		copy(*out, *in)
+	}
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SubportOpts.
+func (in *SubportOpts) DeepCopy() *SubportOpts {
+	if in == nil {
+		return nil
+	}
+	out := new(SubportOpts)
+	in.DeepCopyInto(out)
+	return out
+}
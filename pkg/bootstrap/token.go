@@ -16,7 +16,10 @@ limitations under the License.
 package bootstrap
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"strings"
 	"time"
 
 	"k8s.io/api/core/v1"
@@ -25,11 +28,65 @@ import (
 	tokenutil "k8s.io/cluster-bootstrap/token/util"
 )
 
+const (
+	// TokenMachineLabel and TokenMachineNamespaceLabel identify the Machine
+	// a bootstrap token was created for. Machine names can be longer than a
+	// label value, so TokenMachineLabel holds MachineLabelValue of the
+	// machine and its name is in the TokenMachineAnnotation.
+	TokenMachineLabel          = "machine.openshift.io/bootstrap-token-machine"
+	TokenMachineNamespaceLabel = "machine.openshift.io/bootstrap-token-machine-namespace"
+	TokenMachineAnnotation     = "machine.openshift.io/bootstrap-token-machine"
+
+	// MachineTokenAnnotation records the name of the bootstrap token secret
+	// on its Machine.
+	MachineTokenAnnotation = "openstack-bootstrap-token"
+)
+
+// MachineLabelValue returns the TokenMachineLabel value of the tokens of a
+// machine.
+func MachineLabelValue(namespace, name string) string {
+	sum := sha256.Sum256([]byte(namespace + "/" + name))
+	return hex.EncodeToString(sum[:16])
+}
+
+// TokenOptionsForMachine returns the token options identifying the machine
+// the token is created for.
+func TokenOptionsForMachine(namespace, name string) TokenOptions {
+	return TokenOptions{
+		Labels: map[string]string{
+			TokenMachineLabel:          MachineLabelValue(namespace, name),
+			TokenMachineNamespaceLabel: namespace,
+		},
+		Annotations: map[string]string{
+			TokenMachineAnnotation: name,
+		},
+	}
+}
+
+var (
+	// DefaultTokenUsages are the usages of a token when none are given.
+	DefaultTokenUsages = []string{"authentication", "signing"}
+
+	// DefaultTokenGroups are the extra groups of a token when none are given.
+	DefaultTokenGroups = []string{"system:bootstrappers:kubeadm:default-node-token"}
+)
+
+// TokenOptions are the properties of a bootstrap token secret.
+type TokenOptions struct {
+	Expiration time.Time
+	// Usages defaults to DefaultTokenUsages
+	Usages []string
+	// Groups defaults to DefaultTokenGroups
+	Groups      []string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
 // GeneratesTokenSecret returns a Secret conform to kubeadms bootstrap tokens
 // Inspired by https://github.com/kubernetes/kubernetes/blob/03a145de8ad282764828f43821433001974718e9/cmd/kubeadm/app/apis/kubeadm/bootstraptokenhelpers.go#L34
 // and the underlying type BootstrapToken.
 // We might change the implementation if a type BootstrapToken hits client-go.
-func GenerateTokenSecret(token string, expiration time.Time) (*v1.Secret, error) {
+func GenerateTokenSecret(token string, opts TokenOptions) (*v1.Secret, error) {
 	substrs := tokenutil.BootstrapTokenRegexp.FindStringSubmatch(token)
 	if len(substrs) != 3 {
 		return nil, fmt.Errorf("the bootstrap token %q was not in the form %q", token, tokenapi.BootstrapTokenPattern)
@@ -37,22 +94,43 @@ func GenerateTokenSecret(token string, expiration time.Time) (*v1.Secret, error)
 	tokenID := substrs[1]
 	tokenSecret := substrs[2]
 
-	expirationStr := expiration.Format(time.RFC3339)
+	usages := opts.Usages
+	if len(usages) == 0 {
+		usages = DefaultTokenUsages
+	}
+	if err := tokenutil.ValidateUsages(usages); err != nil {
+		return nil, err
+	}
+
+	groups := opts.Groups
+	if len(groups) == 0 {
+		groups = DefaultTokenGroups
+	}
+	for _, group := range groups {
+		if err := tokenutil.ValidateBootstrapGroupName(group); err != nil {
+			return nil, err
+		}
+	}
+
+	expirationStr := opts.Expiration.Format(time.RFC3339)
 
 	data := map[string][]byte{
-		tokenapi.BootstrapTokenIDKey:               []byte(tokenID),
-		tokenapi.BootstrapTokenSecretKey:           []byte(tokenSecret),
-		tokenapi.BootstrapTokenExpirationKey:       []byte(expirationStr),
-		tokenapi.BootstrapTokenUsageAuthentication: []byte("true"),
-		tokenapi.BootstrapTokenUsageSigningKey:     []byte("true"),
-		tokenapi.BootstrapTokenExtraGroupsKey:      []byte("system:bootstrappers:kubeadm:default-node-token"),
-		tokenapi.BootstrapTokenDescriptionKey:      []byte("bootstrap token generated by cluster-api-provider-openstack"),
+		tokenapi.BootstrapTokenIDKey:          []byte(tokenID),
+		tokenapi.BootstrapTokenSecretKey:      []byte(tokenSecret),
+		tokenapi.BootstrapTokenExpirationKey:  []byte(expirationStr),
+		tokenapi.BootstrapTokenExtraGroupsKey: []byte(strings.Join(groups, ",")),
+		tokenapi.BootstrapTokenDescriptionKey: []byte("bootstrap token generated by cluster-api-provider-openstack"),
+	}
+	for _, usage := range usages {
+		data[tokenapi.BootstrapTokenUsagePrefix+usage] = []byte("true")
 	}
 
 	return &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      tokenutil.BootstrapTokenSecretName(tokenID),
-			Namespace: metav1.NamespaceSystem,
+			Name:        tokenutil.BootstrapTokenSecretName(tokenID),
+			Namespace:   metav1.NamespaceSystem,
+			Labels:      opts.Labels,
+			Annotations: opts.Annotations,
 		},
 		Type: v1.SecretType(tokenapi.SecretTypeBootstrapToken),
 		Data: data,
@@ -1,11 +1,13 @@
 package bootstrap
 
 import (
+	"strings"
 	"testing"
 	"time"
 
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 var (
@@ -19,6 +21,7 @@ func TestGenerateTokenSecret(t *testing.T) {
 	testcases := []struct {
 		name   string
 		secret string
+		opts   TokenOptions
 
 		wantSecret *v1.Secret
 		wantErr    func(err error) (string, bool)
@@ -40,6 +43,47 @@ func TestGenerateTokenSecret(t *testing.T) {
 			name:   "testing invalid token name",
 			secret: "fooo",
 
+			wantSecret: nil,
+			wantErr: func(err error) (string, bool) {
+				return "err != nil", err != nil
+			},
+		},
+		{
+			name:   "testing custom usages and groups",
+			secret: "50ydlk.7up8oiki8zp3qoyh",
+			opts: TokenOptions{
+				Usages: []string{"authentication"},
+				Groups: []string{"system:bootstrappers:worker", "system:bootstrappers:openstack"},
+			},
+
+			wantSecret: &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "bootstrap-token-50ydlk",
+				},
+				Data: map[string][]byte{
+					"usage-bootstrap-authentication": []byte("true"),
+					"auth-extra-groups":              []byte("system:bootstrappers:worker,system:bootstrappers:openstack"),
+				},
+			},
+			wantErr: func(err error) (string, bool) {
+				return "err == nil", err == nil
+			},
+		},
+		{
+			name:   "testing invalid usage",
+			secret: "50ydlk.7up8oiki8zp3qoyh",
+			opts:   TokenOptions{Usages: []string{"everything"}},
+
+			wantSecret: nil,
+			wantErr: func(err error) (string, bool) {
+				return "err != nil", err != nil
+			},
+		},
+		{
+			name:   "testing invalid group",
+			secret: "50ydlk.7up8oiki8zp3qoyh",
+			opts:   TokenOptions{Groups: []string{"system:masters"}},
+
 			wantSecret: nil,
 			wantErr: func(err error) (string, bool) {
 				return "err != nil", err != nil
@@ -49,7 +93,9 @@ func TestGenerateTokenSecret(t *testing.T) {
 
 	for _, testcase := range testcases {
 		t.Run(testcase.name, func(t *testing.T) {
-			secret, err := GenerateTokenSecret(testcase.secret, expiration)
+			opts := testcase.opts
+			opts.Expiration = expiration
+			secret, err := GenerateTokenSecret(testcase.secret, opts)
 			if desc, ok := testcase.wantErr(err); !ok {
 				t.Errorf("expected %s, got %v", desc, err)
 			}
@@ -64,7 +110,31 @@ func TestGenerateTokenSecret(t *testing.T) {
 				if want, got := testcase.wantSecret.ObjectMeta.Name, secret.ObjectMeta.Name; want != got {
 					t.Errorf("expected %v, got %v", want, got)
 				}
+				for key, want := range testcase.wantSecret.Data {
+					if got := secret.Data[key]; string(want) != string(got) {
+						t.Errorf("expected %s to be %q, got %q", key, want, got)
+					}
+				}
+				if _, ok := secret.Data["usage-bootstrap-signing"]; ok && len(testcase.opts.Usages) > 0 {
+					t.Errorf("expected only the given usages, got %v", secret.Data)
+				}
 			}
 		})
 	}
 }
+
+func TestTokenOptionsForMachine(t *testing.T) {
+	longName := strings.Repeat("worker", 20)
+	opts := TokenOptionsForMachine("openshift-machine-api", longName)
+
+	value := opts.Labels[TokenMachineLabel]
+	if errs := validation.IsValidLabelValue(value); len(errs) != 0 {
+		t.Errorf("invalid machine label value %q: %v", value, errs)
+	}
+	if opts.Annotations[TokenMachineAnnotation] != longName {
+		t.Errorf("expected the machine name in the annotation, got %v", opts.Annotations)
+	}
+	if value == MachineLabelValue("other", longName) {
+		t.Errorf("expected machines of other namespaces to have another label value")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstraptoken
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/bootstrap"
+	ctrlRuntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// RequeueTime is how often the node of a machine is checked while the
+// machine has a bootstrap token.
+const RequeueTime = 30 * time.Second
+
+// Reconciler deletes the bootstrap tokens of machines once their node is
+// ready, or once they are deleted.
+type Reconciler struct {
+	Client client.Client
+	Log    logr.Logger
+
+	// apiReader reads tokens and nodes without caching them, the manager
+	// cache may be restricted to the namespace of the machines. Tokens are
+	// only listed once their machine is deleted.
+	apiReader client.Reader
+}
+
+// Reconcile reconciles the bootstrap tokens of a Machine.
+func (r *Reconciler) Reconcile(ctx context.Context, req ctrlRuntime.Request) (ctrlRuntime.Result, error) {
+	logger := r.Log.WithValues("machine", req.Name, "namespace", req.Namespace)
+
+	machine := &machinev1.Machine{}
+	if err := r.Client.Get(ctx, req.NamespacedName, machine); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrlRuntime.Result{}, err
+		}
+		machine = nil
+	}
+
+	reason, err := r.tokenUnneededReason(ctx, machine)
+	if err != nil {
+		return ctrlRuntime.Result{}, err
+	}
+	if reason == "" {
+		return ctrlRuntime.Result{RequeueAfter: RequeueTime}, nil
+	}
+
+	tokens, err := r.machineTokens(ctx, req, machine)
+	if err != nil {
+		return ctrlRuntime.Result{}, err
+	}
+	for i := range tokens {
+		if err := r.Client.Delete(ctx, &tokens[i]); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return ctrlRuntime.Result{}, fmt.Errorf("failed to delete bootstrap token %s: %w", tokens[i].Name, err)
+		}
+		logger.Info("Deleted bootstrap token", "secret", tokens[i].Name, "reason", reason)
+	}
+	return ctrlRuntime.Result{}, nil
+}
+
+// machineTokens returns the bootstrap tokens of a machine. The token of an
+// existing machine is the one recorded in its annotation. The tokens of a
+// deleted machine are listed by their labels.
+func (r *Reconciler) machineTokens(ctx context.Context, req ctrlRuntime.Request, machine *machinev1.Machine) ([]corev1.Secret, error) {
+	if machine != nil {
+		secretName, ok := machine.Annotations[bootstrap.MachineTokenAnnotation]
+		if !ok {
+			return nil, nil
+		}
+		return []corev1.Secret{{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceSystem, Name: secretName}}}, nil
+	}
+
+	list := &corev1.SecretList{}
+	err := r.apiReader.List(ctx, list, client.InNamespace(metav1.NamespaceSystem), client.MatchingLabels{
+		bootstrap.TokenMachineLabel:          bootstrap.MachineLabelValue(req.Namespace, req.Name),
+		bootstrap.TokenMachineNamespaceLabel: req.Namespace,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bootstrap tokens: %w", err)
+	}
+	tokens := []corev1.Secret{}
+	for _, token := range list.Items {
+		if token.Annotations[bootstrap.TokenMachineAnnotation] == req.Name {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// tokenUnneededReason returns why the bootstrap tokens of a machine are not
+// needed anymore, or an empty string if they still are. The machine is nil
+// once it is deleted.
+func (r *Reconciler) tokenUnneededReason(ctx context.Context, machine *machinev1.Machine) (string, error) {
+	if machine == nil || !machine.DeletionTimestamp.IsZero() {
+		return "machine deleted", nil
+	}
+	if machine.Status.NodeRef == nil {
+		return "", nil
+	}
+
+	node := &corev1.Node{}
+	if err := r.apiReader.Get(ctx, client.ObjectKey{Name: machine.Status.NodeRef.Name}, node); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+			return "node ready", nil
+		}
+	}
+	return "", nil
+}
+
+func (r *Reconciler) SetupWithManager(mgr ctrlRuntime.Manager, options controller.Options) error {
+	err := ctrlRuntime.NewControllerManagedBy(mgr).
+		Named("bootstraptoken").
+		// Only machines with a bootstrap token are reconciled, including
+		// their deletion.
+		For(&machinev1.Machine{}, builder.WithPredicates(predicate.NewPredicateFuncs(hasBootstrapToken))).
+		WithOptions(options).
+		Complete(r)
+	if err != nil {
+		return fmt.Errorf("controller creation failed: %w", err)
+	}
+
+	r.Client = mgr.GetClient()
+	r.apiReader = mgr.GetAPIReader()
+	return nil
+}
+
+func hasBootstrapToken(obj client.Object) bool {
+	_, ok := obj.GetAnnotations()[bootstrap.MachineTokenAnnotation]
+	return ok
+}
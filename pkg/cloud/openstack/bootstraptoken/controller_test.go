@@ -0,0 +1,166 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstraptoken
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/bootstrap"
+	ctrlRuntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcile(t *testing.T) {
+	now := metav1.Now()
+
+	testCases := []struct {
+		name            string
+		machine         *machinev1.Machine
+		node            *corev1.Node
+		expectDeleted   bool
+		expectedRequeue time.Duration
+	}{
+		{
+			name:            "machine without node",
+			machine:         newMachine(""),
+			expectedRequeue: RequeueTime,
+		},
+		{
+			name:            "node not ready",
+			machine:         newMachine("worker-0"),
+			node:            newNode("worker-0", corev1.ConditionFalse),
+			expectedRequeue: RequeueTime,
+		},
+		{
+			name:            "node not created yet",
+			machine:         newMachine("worker-0"),
+			expectedRequeue: RequeueTime,
+		},
+		{
+			name:          "node ready",
+			machine:       newMachine("worker-0"),
+			node:          newNode("worker-0", corev1.ConditionTrue),
+			expectDeleted: true,
+		},
+		{
+			name:          "machine deleted",
+			expectDeleted: true,
+		},
+		{
+			name: "machine being deleted",
+			machine: func() *machinev1.Machine {
+				machine := newMachine("")
+				machine.DeletionTimestamp = &now
+				machine.Finalizers = []string{"machine.machine.openshift.io"}
+				return machine
+			}(),
+			expectDeleted: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			g := NewWithT(tt)
+
+			scheme := runtime.NewScheme()
+			g.Expect(corev1.AddToScheme(scheme)).To(Succeed())
+			g.Expect(machinev1.AddToScheme(scheme)).To(Succeed())
+
+			objects := []client.Object{newToken("abcdef", "worker-0"), newToken("ghijkl", "worker-1")}
+			if tc.machine != nil {
+				objects = append(objects, tc.machine)
+			}
+			if tc.node != nil {
+				objects = append(objects, tc.node)
+			}
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+
+			r := &Reconciler{
+				Client:    fakeClient,
+				Log:       logr.Discard(),
+				apiReader: fakeClient,
+			}
+			result, err := r.Reconcile(context.TODO(), ctrlRuntime.Request{NamespacedName: types.NamespacedName{Namespace: "openshift-machine-api", Name: "worker-0"}})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(result.RequeueAfter).To(Equal(tc.expectedRequeue))
+
+			tokens := &corev1.SecretList{}
+			g.Expect(fakeClient.List(context.TODO(), tokens, client.InNamespace(metav1.NamespaceSystem))).To(Succeed())
+			names := []string{}
+			for _, token := range tokens.Items {
+				names = append(names, token.Name)
+			}
+			if tc.expectDeleted {
+				g.Expect(names).To(ConsistOf("bootstrap-token-ghijkl"))
+			} else {
+				g.Expect(names).To(ConsistOf("bootstrap-token-abcdef", "bootstrap-token-ghijkl"))
+			}
+		})
+	}
+}
+
+func newMachine(nodeName string) *machinev1.Machine {
+	machine := &machinev1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "worker-0",
+			Namespace:   "openshift-machine-api",
+			Annotations: map[string]string{bootstrap.MachineTokenAnnotation: "bootstrap-token-abcdef"},
+		},
+	}
+	if nodeName != "" {
+		machine.Status.NodeRef = &corev1.ObjectReference{Kind: "Node", Name: nodeName}
+	}
+	return machine
+}
+
+func newNode(name string, ready corev1.ConditionStatus) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+		},
+	}
+}
+
+func newToken(id string, machineName string) *corev1.Secret {
+	opts := bootstrap.TokenOptionsForMachine("openshift-machine-api", machineName)
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "bootstrap-token-" + id,
+			Namespace:   metav1.NamespaceSystem,
+			Labels:      opts.Labels,
+			Annotations: opts.Annotations,
+		},
+	}
+}
+
+func TestHasBootstrapToken(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(hasBootstrapToken(newMachine(""))).To(BeTrue())
+	g.Expect(hasBootstrapToken(&machinev1.Machine{})).To(BeFalse())
+}
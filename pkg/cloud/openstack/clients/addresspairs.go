@@ -0,0 +1,135 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	configclient "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+// defaultClusterVIPs are the cluster VIPs added to the ports of networks
+// which do not select any.
+var defaultClusterVIPs = []openstackconfigv1.ClusterVIP{
+	openstackconfigv1.ClusterVIPAPI,
+	openstackconfigv1.ClusterVIPDNS,
+	openstackconfigv1.ClusterVIPIngress,
+}
+
+// openStackPlatformStatus holds the VIPs of the OpenStack platform status of
+// the Infrastructure object. Dual-stack clusters list their IPv4 and IPv6 VIPs
+// in apiServerInternalIPs and ingressIPs, which are read from the raw object
+// as they are not part of the vendored API.
+type openStackPlatformStatus struct {
+	APIServerInternalIP  string   `json:"apiServerInternalIP,omitempty"`
+	APIServerInternalIPs []string `json:"apiServerInternalIPs,omitempty"`
+	IngressIP            string   `json:"ingressIP,omitempty"`
+	IngressIPs           []string `json:"ingressIPs,omitempty"`
+	NodeDNSIP            string   `json:"nodeDNSIP,omitempty"`
+}
+
+// clusterVIPs are the addresses of each kind of VIP of the cluster.
+type clusterVIPs map[openstackconfigv1.ClusterVIP][]string
+
+// getClusterVIPs returns the VIPs of the cluster.
+func getClusterVIPs(configClient configclient.ConfigV1Interface) (clusterVIPs, error) {
+	raw, err := configClient.RESTClient().Get().Resource("infrastructures").Name("cluster").Do(context.TODO()).Raw()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve cluster Infrastructure object: %v", err)
+	}
+	var infra struct {
+		Status struct {
+			PlatformStatus *struct {
+				OpenStack *openStackPlatformStatus `json:"openstack,omitempty"`
+			} `json:"platformStatus,omitempty"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(raw, &infra); err != nil {
+		return nil, fmt.Errorf("Failed to decode cluster Infrastructure object: %v", err)
+	}
+	if infra.Status.PlatformStatus == nil || infra.Status.PlatformStatus.OpenStack == nil {
+		return clusterVIPs{}, nil
+	}
+	return newClusterVIPs(infra.Status.PlatformStatus.OpenStack), nil
+}
+
+func newClusterVIPs(status *openStackPlatformStatus) clusterVIPs {
+	vips := clusterVIPs{}
+	vips[openstackconfigv1.ClusterVIPAPI] = vipList(status.APIServerInternalIP, status.APIServerInternalIPs)
+	vips[openstackconfigv1.ClusterVIPDNS] = vipList(status.NodeDNSIP, nil)
+	vips[openstackconfigv1.ClusterVIPIngress] = vipList(status.IngressIP, status.IngressIPs)
+	return vips
+}
+
+// vipList returns the VIPs of a list, which starts with the single VIP in
+// dual-stack clusters, or the single VIP.
+func vipList(vip string, list []string) []string {
+	var vips []string
+	if vip != "" {
+		vips = append(vips, vip)
+	}
+	for _, v := range list {
+		if v != "" && !isDuplicate(vips, v) {
+			vips = append(vips, v)
+		}
+	}
+	return vips
+}
+
+// addressPairs returns the allowed address pairs of a port: the cluster VIPs
+// of the given kinds, all of them by default, followed by the extra pairs.
+func (vips clusterVIPs) addressPairs(kinds []openstackconfigv1.ClusterVIP, extra []openstackconfigv1.AddressPair) ([]openstackconfigv1.AddressPair, error) {
+	if len(kinds) == 0 {
+		kinds = defaultClusterVIPs
+	}
+
+	allowedAddressPairs := []openstackconfigv1.AddressPair{}
+	for _, kind := range kinds {
+		switch kind {
+		case openstackconfigv1.ClusterVIPNone:
+			if len(kinds) > 1 {
+				return nil, fmt.Errorf("cluster VIPs %v cannot contain %s with other VIPs", kinds, kind)
+			}
+		case openstackconfigv1.ClusterVIPAPI, openstackconfigv1.ClusterVIPDNS, openstackconfigv1.ClusterVIPIngress:
+			for _, vip := range vips[kind] {
+				allowedAddressPairs = append(allowedAddressPairs, openstackconfigv1.AddressPair{IPAddress: vip})
+			}
+		default:
+			return nil, fmt.Errorf("unknown cluster VIP %q, it must be one of %s, %s, %s or %s", kind,
+				openstackconfigv1.ClusterVIPAPI, openstackconfigv1.ClusterVIPDNS, openstackconfigv1.ClusterVIPIngress, openstackconfigv1.ClusterVIPNone)
+		}
+	}
+
+	for _, pair := range extra {
+		if net.ParseIP(pair.IPAddress) == nil {
+			if _, _, err := net.ParseCIDR(pair.IPAddress); err != nil {
+				return nil, fmt.Errorf("allowed address pair %q is neither an IP address nor a CIDR", pair.IPAddress)
+			}
+		}
+		if pair.MACAddress != "" {
+			if _, err := net.ParseMAC(pair.MACAddress); err != nil {
+				return nil, fmt.Errorf("allowed address pair %q has an invalid MAC address: %v", pair.IPAddress, err)
+			}
+		}
+		allowedAddressPairs = append(allowedAddressPairs, pair)
+	}
+	return allowedAddressPairs, nil
+}
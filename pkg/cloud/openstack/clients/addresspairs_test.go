@@ -0,0 +1,86 @@
+package clients
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestClusterVIPAddressPairs(t *testing.T) {
+	vips := newClusterVIPs(&openStackPlatformStatus{
+		APIServerInternalIP:  "10.0.0.5",
+		APIServerInternalIPs: []string{"10.0.0.5", "fd2e:6f44:5dd8::5"},
+		IngressIP:            "10.0.0.7",
+		IngressIPs:           []string{"10.0.0.7", "fd2e:6f44:5dd8::7"},
+		NodeDNSIP:            "10.0.0.6",
+	})
+
+	testCases := []struct {
+		name        string
+		kinds       []openstackconfigv1.ClusterVIP
+		extra       []openstackconfigv1.AddressPair
+		expected    []string
+		expectedErr string
+	}{
+		{
+			name:     "all VIPs by default",
+			expected: []string{"10.0.0.5", "fd2e:6f44:5dd8::5", "10.0.0.6", "10.0.0.7", "fd2e:6f44:5dd8::7"},
+		},
+		{
+			name:     "selected VIPs and extra pairs",
+			kinds:    []openstackconfigv1.ClusterVIP{openstackconfigv1.ClusterVIPIngress},
+			extra:    []openstackconfigv1.AddressPair{{IPAddress: "192.168.100.0/24"}, {IPAddress: "192.168.0.10", MACAddress: "fa:16:3e:00:00:01"}},
+			expected: []string{"10.0.0.7", "fd2e:6f44:5dd8::7", "192.168.100.0/24", "192.168.0.10"},
+		},
+		{
+			name:     "no VIPs",
+			kinds:    []openstackconfigv1.ClusterVIP{openstackconfigv1.ClusterVIPNone},
+			extra:    []openstackconfigv1.AddressPair{{IPAddress: "192.168.100.0/24"}},
+			expected: []string{"192.168.100.0/24"},
+		},
+		{
+			name:        "None with other VIPs",
+			kinds:       []openstackconfigv1.ClusterVIP{openstackconfigv1.ClusterVIPNone, openstackconfigv1.ClusterVIPAPI},
+			expectedErr: "cannot contain None",
+		},
+		{
+			name:        "unknown VIP",
+			kinds:       []openstackconfigv1.ClusterVIP{"Console"},
+			expectedErr: "unknown cluster VIP",
+		},
+		{
+			name:        "invalid address",
+			extra:       []openstackconfigv1.AddressPair{{IPAddress: "192.168.100.0/33"}},
+			expectedErr: "neither an IP address nor a CIDR",
+		},
+		{
+			name:        "invalid MAC address",
+			extra:       []openstackconfigv1.AddressPair{{IPAddress: "192.168.0.10", MACAddress: "fa:16:3e"}},
+			expectedErr: "invalid MAC address",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pairs, err := vips.addressPairs(tc.kinds, tc.extra)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("Expected error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var addresses []string
+			for _, pair := range pairs {
+				addresses = append(addresses, pair.IPAddress)
+			}
+			if !reflect.DeepEqual(addresses, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, addresses)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+const (
+	// ClusterConfigMapName is the ConfigMap holding the provider spec and
+	// status of the cluster, in the namespace of its machines.
+	ClusterConfigMapName = "openstack-cluster"
+	// ClusterSpecKey is the key of the OpenstackClusterProviderSpec in the
+	// cluster ConfigMap.
+	ClusterSpecKey = "spec"
+	// ClusterStatusKey is the key of the OpenstackClusterProviderStatus in
+	// the cluster ConfigMap, written by the cluster controller.
+	ClusterStatusKey = "status"
+)
+
+// ClusterConfigFromConfigMap unmarshals the provider spec and status of the
+// cluster from its ConfigMap.
+func ClusterConfigFromConfigMap(configMap *corev1.ConfigMap) (*openstackconfigv1.OpenstackClusterProviderSpec, *openstackconfigv1.OpenstackClusterProviderStatus, error) {
+	spec := &openstackconfigv1.OpenstackClusterProviderSpec{}
+	if err := yaml.Unmarshal([]byte(configMap.Data[ClusterSpecKey]), spec); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal the %s key of ConfigMap %s: %v", ClusterSpecKey, configMap.Name, err)
+	}
+	status := &openstackconfigv1.OpenstackClusterProviderStatus{}
+	if err := yaml.Unmarshal([]byte(configMap.Data[ClusterStatusKey]), status); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal the %s key of ConfigMap %s: %v", ClusterStatusKey, configMap.Name, err)
+	}
+	return spec, status, nil
+}
+
+// GetClusterConfig returns the provider spec and status of the cluster of the
+// machines of the namespace. They are empty if the namespace has no cluster
+// ConfigMap.
+func GetClusterConfig(kubeClient kubernetes.Interface, namespace string) (*openstackconfigv1.OpenstackClusterProviderSpec, *openstackconfigv1.OpenstackClusterProviderStatus, error) {
+	configMap, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), ClusterConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return &openstackconfigv1.OpenstackClusterProviderSpec{}, &openstackconfigv1.OpenstackClusterProviderStatus{}, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get ConfigMap %s/%s: %v", namespace, ClusterConfigMapName, err)
+	}
+	return ClusterConfigFromConfigMap(configMap)
+}
+
+// newClusterNetworkClient returns a networking client authenticated with the
+// clouds secret of the provider spec of the cluster.
+func newClusterNetworkClient(kubeClient kubernetes.Interface, namespace string, spec *openstackconfigv1.OpenstackClusterProviderSpec) (*gophercloud.ServiceClient, error) {
+	if spec.CloudsSecret == nil || spec.CloudsSecret.Name == "" {
+		return nil, fmt.Errorf("Cloud secret name can't be empty")
+	}
+	if spec.CloudsSecret.Namespace != "" {
+		namespace = spec.CloudsSecret.Namespace
+	}
+	cloud, err := GetCloudFromSecret(kubeClient, namespace, spec.CloudsSecret.Name, spec.CloudName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get cloud from secret: %v", err)
+	}
+
+	provider, err := GetProviderClient(cloud, GetCACertificate(kubeClient))
+	if err != nil {
+		return nil, err
+	}
+	networkingClient, err := openstack.NewNetworkV2(provider, gophercloud.EndpointOpts{
+		Region: cloud.RegionName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Create networkingClient err: %v", err)
+	}
+	return networkingClient, nil
+}
+
+// NewNetworkServiceFromClusterSpec returns a NetworkService authenticated
+// with the clouds secret of the provider spec of the cluster.
+func NewNetworkServiceFromClusterSpec(kubeClient kubernetes.Interface, namespace string, spec *openstackconfigv1.OpenstackClusterProviderSpec) (*NetworkService, error) {
+	client, err := newClusterNetworkClient(kubeClient, namespace, spec)
+	if err != nil {
+		return nil, err
+	}
+	return NewNetworkService(client)
+}
+
+// NewSecGroupServiceFromClusterSpec returns a SecGroupService authenticated
+// with the clouds secret of the provider spec of the cluster.
+func NewSecGroupServiceFromClusterSpec(kubeClient kubernetes.Interface, namespace string, spec *openstackconfigv1.OpenstackClusterProviderSpec) (*SecGroupService, error) {
+	client, err := newClusterNetworkClient(kubeClient, namespace, spec)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecGroupService(client)
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+// hostnameData holds the values the hostname template may refer to.
+type hostnameData struct {
+	MachineName string
+	ClusterName string
+}
+
+// Hostname returns the hostname of the machine, rendered from the hostname
+// template of the provider spec.
+func Hostname(config *openstackconfigv1.OpenstackProviderSpec, clusterName string, name string) (string, error) {
+	if config.Hostname == "" {
+		return name, nil
+	}
+	tmpl, err := template.New("hostname").Option("missingkey=error").Parse(config.Hostname)
+	if err != nil {
+		return "", fmt.Errorf("invalid hostname template %q: %v", config.Hostname, err)
+	}
+	var hostname bytes.Buffer
+	if err := tmpl.Execute(&hostname, hostnameData{MachineName: name, ClusterName: clusterName}); err != nil {
+		return "", fmt.Errorf("invalid hostname template %q: %v", config.Hostname, err)
+	}
+	if errs := validation.IsDNS1123Label(hostname.String()); len(errs) > 0 {
+		return "", fmt.Errorf("invalid hostname %q: %s", hostname.String(), strings.Join(errs, ", "))
+	}
+	return hostname.String(), nil
+}
+
+// dnsSupportNeeded returns true if the provider spec sets the DNS names or
+// domains of ports.
+func dnsSupportNeeded(config *openstackconfigv1.OpenstackProviderSpec) bool {
+	if config.Hostname != "" {
+		return true
+	}
+	for _, net := range config.Networks {
+		if net.DNSDomain != "" {
+			return true
+		}
+	}
+	for _, portOpts := range config.Ports {
+		if portOpts.DNSName != "" || portOpts.DNSDomain != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// PortDNSEnabled returns true if the provider spec sets the DNS names or
+// domains of ports and Neutron has the dns-integration extension. Neutron is
+// only queried if the provider spec sets them.
+func (is *InstanceService) PortDNSEnabled(config *openstackconfigv1.OpenstackProviderSpec) (bool, error) {
+	if !dnsSupportNeeded(config) {
+		return false, nil
+	}
+	return hasNetworkExtension(is, "dns-integration")
+}
+
+// portDNSSettings returns whether Neutron supports setting the DNS names and
+// domains of ports, and the hostname set as DNS name of the ports of the
+// machine, which is empty if the provider spec has no hostname template.
+// The template is rendered with the infrastructure name of the cluster.
+func (is *InstanceService) portDNSSettings(clusterInfraName string, name string, config *openstackconfigv1.OpenstackProviderSpec) (bool, bool, string, error) {
+	dnsSupport, err := is.PortDNSEnabled(config)
+	if err != nil {
+		return false, false, "", err
+	}
+	if !dnsSupport {
+		if dnsSupportNeeded(config) {
+			klog.V(3).Infof("Neutron has no dns-integration extension, not setting the DNS names of the ports of server %s", name)
+		}
+		return false, false, "", nil
+	}
+	dnsDomainSupport, err := hasNetworkExtension(is, "dns-domain-ports")
+	if err != nil {
+		return false, false, "", err
+	}
+	var hostname string
+	if config.Hostname != "" {
+		if hostname, err = Hostname(config, clusterInfraName, name); err != nil {
+			return false, false, "", err
+		}
+	}
+	return dnsSupport, dnsDomainSupport, hostname, nil
+}
+
+// serverHostname returns the hostname Nova derives from the name of a server.
+func serverHostname(name string) string {
+	hostname := strings.Map(func(r rune) rune {
+		switch {
+		case r == ' ' || r == '_' || r == '.':
+			return '-'
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		default:
+			return -1
+		}
+	}, strings.ToLower(name))
+	if len(hostname) > validation.DNS1123LabelMaxLength {
+		hostname = hostname[:validation.DNS1123LabelMaxLength]
+	}
+	return strings.Trim(hostname, "-")
+}
+
+// portDNSAtCreation returns the port options with the DNS name and domain
+// which can be set when the port is created. Nova refuses ports whose DNS name
+// differs from the hostname of the server, so other DNS names are left to
+// portDNSUpdate once the port is in use. The DNS domain is only kept if
+// dnsDomainSupport is set.
+func portDNSAtCreation(name string, portOpts openstackconfigv1.PortOpts, hostname string, dnsSupport bool, dnsDomainSupport bool) openstackconfigv1.PortOpts {
+	dnsName := portOpts.DNSName
+	if dnsName == "" {
+		dnsName = hostname
+	}
+	portOpts.DNSName = ""
+	if !dnsSupport {
+		portOpts.DNSDomain = ""
+		return portOpts
+	}
+	if dnsName != "" && dnsName == serverHostname(name) {
+		portOpts.DNSName = dnsName
+	}
+	if !dnsDomainSupport {
+		portOpts.DNSDomain = ""
+	}
+	return portOpts
+}
+
+// PortDNSExt is the DNS extension of a port. Unlike dns.PortDNSExt, it
+// includes the DNS domain of the port. It is exported since gophercloud
+// cannot extract results into unexported embedded structs.
+type PortDNSExt struct {
+	DNSName       string              `json:"dns_name"`
+	DNSDomain     string              `json:"dns_domain"`
+	DNSAssignment []map[string]string `json:"dns_assignment"`
+}
+
+// portDNSCreateOpts sets the DNS name and domain of a new port.
+type portDNSCreateOpts struct {
+	ports.CreateOptsBuilder
+
+	DNSName   string `json:"dns_name,omitempty"`
+	DNSDomain string `json:"dns_domain,omitempty"`
+}
+
+func (opts portDNSCreateOpts) ToPortCreateMap() (map[string]interface{}, error) {
+	base, err := opts.CreateOptsBuilder.ToPortCreateMap()
+	if err != nil {
+		return nil, err
+	}
+	port := base["port"].(map[string]interface{})
+	if opts.DNSName != "" {
+		port["dns_name"] = opts.DNSName
+	}
+	if opts.DNSDomain != "" {
+		port["dns_domain"] = opts.DNSDomain
+	}
+	return base, nil
+}
+
+// portDNSUpdateOpts sets the DNS name and domain of a port.
+type portDNSUpdateOpts struct {
+	ports.UpdateOptsBuilder
+
+	DNSName   *string `json:"dns_name,omitempty"`
+	DNSDomain *string `json:"dns_domain,omitempty"`
+}
+
+func (opts portDNSUpdateOpts) ToPortUpdateMap() (map[string]interface{}, error) {
+	base, err := opts.UpdateOptsBuilder.ToPortUpdateMap()
+	if err != nil {
+		return nil, err
+	}
+	port := base["port"].(map[string]interface{})
+	if opts.DNSName != nil {
+		port["dns_name"] = *opts.DNSName
+	}
+	if opts.DNSDomain != nil {
+		port["dns_domain"] = *opts.DNSDomain
+	}
+	return base, nil
+}
+
+// portDNSUpdate returns the update of the DNS name and domain of the port
+// needed to match the port options, and whether there is anything to update.
+// The DNS domain is only updated if dnsDomainSupport is set.
+func portDNSUpdate(port reconciledPort, portOpts openstackconfigv1.PortOpts, hostname string, dnsDomainSupport bool) (portDNSUpdateOpts, bool) {
+	update := portDNSUpdateOpts{UpdateOptsBuilder: ports.UpdateOpts{}}
+	needed := false
+
+	dnsName := portOpts.DNSName
+	if dnsName == "" {
+		dnsName = hostname
+	}
+	if dnsName != "" && port.DNSName != dnsName {
+		update.DNSName = &dnsName
+		needed = true
+	}
+	if dnsDomainSupport && portOpts.DNSDomain != "" && port.DNSDomain != portOpts.DNSDomain {
+		update.DNSDomain = &portOpts.DNSDomain
+		needed = true
+	}
+	return update, needed
+}
+
+// DNSNames returns the FQDNs assigned by Neutron to the port.
+func (p *InstancePort) DNSNames() []string {
+	var names []string
+	for _, assignment := range p.DNSAssignment {
+		fqdn := strings.TrimSuffix(assignment["fqdn"], ".")
+		if fqdn != "" && !isDuplicate(names, fqdn) {
+			names = append(names, fqdn)
+		}
+	}
+	return names
+}
@@ -0,0 +1,265 @@
+package clients
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestHostname(t *testing.T) {
+	testCases := []struct {
+		name      string
+		template  string
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:     "default",
+			expected: "ocp-abc12-worker-0-xyz",
+		},
+		{
+			name:     "template",
+			template: "{{.ClusterName}}-node-{{.MachineName}}",
+			expected: "ocp-abc12-node-ocp-abc12-worker-0-xyz",
+		},
+		{
+			name:      "invalid template",
+			template:  "{{.MachineName",
+			expectErr: true,
+		},
+		{
+			name:      "unknown field",
+			template:  "{{.Namespace}}",
+			expectErr: true,
+		},
+		{
+			name:      "invalid hostname",
+			template:  "{{.MachineName}}.example.com",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hostname, err := Hostname(&openstackconfigv1.OpenstackProviderSpec{Hostname: tc.template}, "ocp-abc12", "ocp-abc12-worker-0-xyz")
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error, got hostname %q", hostname)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if hostname != tc.expected {
+				t.Errorf("expected hostname %q, got %q", tc.expected, hostname)
+			}
+		})
+	}
+}
+
+func TestPortDNSUpdate(t *testing.T) {
+	port := reconciledPort{
+		Port:       ports.Port{ID: "port"},
+		PortDNSExt: PortDNSExt{DNSName: "worker-0"},
+	}
+
+	testCases := []struct {
+		name             string
+		portOpts         openstackconfigv1.PortOpts
+		hostname         string
+		dnsDomainSupport bool
+		expected         map[string]interface{}
+	}{
+		{
+			name:     "no hostname template",
+			expected: nil,
+		},
+		{
+			name:     "same hostname",
+			hostname: "worker-0",
+			expected: nil,
+		},
+		{
+			name:     "hostname",
+			hostname: "node-0",
+			expected: map[string]interface{}{"dns_name": "node-0"},
+		},
+		{
+			name:     "port DNS name",
+			portOpts: openstackconfigv1.PortOpts{DNSName: "storage-0"},
+			hostname: "node-0",
+			expected: map[string]interface{}{"dns_name": "storage-0"},
+		},
+		{
+			name:     "DNS domain unsupported",
+			portOpts: openstackconfigv1.PortOpts{DNSDomain: "example.com."},
+			expected: nil,
+		},
+		{
+			name:             "DNS domain",
+			portOpts:         openstackconfigv1.PortOpts{DNSDomain: "example.com."},
+			dnsDomainSupport: true,
+			expected:         map[string]interface{}{"dns_domain": "example.com."},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			updateOpts, needed := portDNSUpdate(port, tc.portOpts, tc.hostname, tc.dnsDomainSupport)
+			if needed != (tc.expected != nil) {
+				t.Fatalf("expected update needed to be %v", tc.expected != nil)
+			}
+			if !needed {
+				return
+			}
+			body, err := updateOpts.ToPortUpdateMap()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(body["port"], tc.expected) {
+				t.Errorf("expected update %v, got %v", tc.expected, body["port"])
+			}
+		})
+	}
+}
+
+func TestExtractPortDNS(t *testing.T) {
+	result := ports.GetResult{}
+	result.Body = map[string]interface{}{
+		"port": map[string]interface{}{
+			"id":         "port-1",
+			"dns_name":   "worker-0",
+			"dns_domain": "example.com.",
+		},
+	}
+
+	var port reconciledPort
+	if err := result.ExtractInto(&port); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if port.ID != "port-1" || port.DNSName != "worker-0" || port.DNSDomain != "example.com." {
+		t.Errorf("Unexpected port %+v", port)
+	}
+
+	var instancePort InstancePort
+	if err := result.ExtractInto(&instancePort); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if instancePort.DNSName != "worker-0" {
+		t.Errorf("Unexpected instance port %+v", instancePort)
+	}
+}
+
+func TestPortDNSAtCreation(t *testing.T) {
+	testCases := []struct {
+		name             string
+		portOpts         openstackconfigv1.PortOpts
+		hostname         string
+		dnsSupport       bool
+		dnsDomainSupport bool
+		expectedName     string
+		expectedDomain   string
+	}{
+		{
+			name:     "DNS unsupported",
+			portOpts: openstackconfigv1.PortOpts{DNSName: "worker-0", DNSDomain: "example.com."},
+		},
+		{
+			name:         "hostname of the server",
+			hostname:     "worker-0",
+			dnsSupport:   true,
+			expectedName: "worker-0",
+		},
+		{
+			name:       "hostname differing from the server",
+			hostname:   "node-0",
+			dnsSupport: true,
+		},
+		{
+			name:       "port DNS name differing from the server",
+			portOpts:   openstackconfigv1.PortOpts{DNSName: "storage-0"},
+			hostname:   "worker-0",
+			dnsSupport: true,
+		},
+		{
+			name:       "DNS domain unsupported",
+			portOpts:   openstackconfigv1.PortOpts{DNSDomain: "example.com."},
+			dnsSupport: true,
+		},
+		{
+			name:             "DNS domain",
+			portOpts:         openstackconfigv1.PortOpts{DNSDomain: "example.com."},
+			dnsSupport:       true,
+			dnsDomainSupport: true,
+			expectedDomain:   "example.com.",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			portOpts := portDNSAtCreation("worker-0", tc.portOpts, tc.hostname, tc.dnsSupport, tc.dnsDomainSupport)
+			if portOpts.DNSName != tc.expectedName || portOpts.DNSDomain != tc.expectedDomain {
+				t.Errorf("expected DNS name %q and domain %q, got %q and %q", tc.expectedName, tc.expectedDomain, portOpts.DNSName, portOpts.DNSDomain)
+			}
+		})
+	}
+}
+
+func TestServerHostname(t *testing.T) {
+	testCases := map[string]string{
+		"worker-0":              "worker-0",
+		"ocp.worker_0":          "ocp-worker-0",
+		"Worker 0!":             "worker-0",
+		strings.Repeat("a", 70): strings.Repeat("a", 63),
+	}
+	for name, expected := range testCases {
+		if hostname := serverHostname(name); hostname != expected {
+			t.Errorf("expected hostname %q for server %q, got %q", expected, name, hostname)
+		}
+	}
+}
+
+func TestPortDNSCreateOpts(t *testing.T) {
+	opts := portDNSCreateOpts{
+		CreateOptsBuilder: ports.CreateOpts{NetworkID: "net-1"},
+		DNSName:           "worker-0",
+		DNSDomain:         "example.com.",
+	}
+	body, err := opts.ToPortCreateMap()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	port := body["port"].(map[string]interface{})
+	if port["dns_name"] != "worker-0" || port["dns_domain"] != "example.com." || port["network_id"] != "net-1" {
+		t.Errorf("unexpected port %v", port)
+	}
+}
+
+func TestPortDNSSettings(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/v2.0/extensions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		fmt.Fprint(w, `{"extensions": [{"alias": "dns-integration", "name": "DNS Integration"}]}`)
+	})
+
+	config := &openstackconfigv1.OpenstackProviderSpec{Hostname: "{{.ClusterName}}-{{.MachineName}}"}
+	dnsSupport, dnsDomainSupport, hostname, err := newFakeInstanceService().portDNSSettings("ostest", "worker-0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dnsSupport || dnsDomainSupport {
+		t.Errorf("expected DNS name support only, got %v and %v", dnsSupport, dnsDomainSupport)
+	}
+	if hostname != "ostest-worker-0" {
+		t.Errorf("expected the hostname to be rendered with the infrastructure name, got %q", hostname)
+	}
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/bootfromvolume"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	imageutils "github.com/gophercloud/utils/openstack/imageservice/v2/images"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+// Image properties and flavor extra specs checked before creating a server
+const (
+	imageArchitectureProperty = "hw_architecture"
+	imageFirmwareProperty     = "hw_firmware_type"
+	imageSecureBootProperty   = "os_secure_boot"
+	flavorArchitectureSpec    = "hw:cpu_arch"
+	flavorSecureBootSpec      = "os:secure_boot"
+)
+
+// ImageNotReadyError is returned when an image is still being uploaded and
+// can be used once its upload completes.
+type ImageNotReadyError struct {
+	Name   string
+	Status images.ImageStatus
+}
+
+func (e *ImageNotReadyError) Error() string {
+	return fmt.Sprintf("image %q is not ready yet, its status is %s", e.Name, e.Status)
+}
+
+// ImageNotReady returns true if the image is still being uploaded.
+func ImageNotReady(image *images.Image) bool {
+	return image.Status == images.ImageStatusQueued || image.Status == images.ImageStatusSaving
+}
+
+// ValidateImage checks that the image of the provider spec can be used to
+// boot a server with its flavor. Nothing is checked when booting from an
+// existing volume.
+func (is *InstanceService) ValidateImage(config *openstackconfigv1.OpenstackProviderSpec) error {
+	imageName, imageFilter := ImageOf(config)
+	if imageName == "" && imageFilter == nil {
+		return nil
+	}
+
+	image, err := is.FindImage(imageName, imageFilter)
+	if err != nil {
+		return err
+	}
+
+	flavorID, err := is.GetFlavorID(config.Flavor)
+	if err != nil {
+		return err
+	}
+	flavor, err := is.GetFlavorInfo(flavorID)
+	if err != nil {
+		return err
+	}
+	extraSpecs, err := is.GetFlavorExtraSpecs(flavorID)
+	if err != nil {
+		return err
+	}
+
+	rootVolumeSize := 0
+	if config.RootVolume != nil {
+		rootVolumeSize = config.RootVolume.Size
+	}
+	return checkImage(image, flavor, extraSpecs, rootVolumeSize)
+}
+
+// ImageOf returns the name or else the filter of the image a server is booted
+// from. Both are empty when the server boots from an existing volume.
+func ImageOf(config *openstackconfigv1.OpenstackProviderSpec) (string, *openstackconfigv1.ImageFilter) {
+	if config.RootVolume != nil {
+		if bootfromvolume.SourceType(config.RootVolume.SourceType) != bootfromvolume.SourceImage {
+			return "", nil
+		}
+		if config.RootVolume.SourceUUID != "" {
+			return config.RootVolume.SourceUUID, nil
+		}
+		return "", config.ImageFilter
+	}
+	if config.Image != "" {
+		return config.Image, nil
+	}
+	return "", config.ImageFilter
+}
+
+// FindImage returns the image with the given name or ID. When the name is
+// empty, it returns the most recently created active image matching the filter.
+func (is *InstanceService) FindImage(imageName string, filter *openstackconfigv1.ImageFilter) (*images.Image, error) {
+	if imageName != "" || filter == nil {
+		imageID, err := imageutils.IDFromName(is.imagesClient, imageName)
+		if err != nil {
+			return nil, err
+		}
+		return images.Get(is.imagesClient, imageID).Extract()
+	}
+
+	listOpts := images.ListOpts{
+		Name:       filter.Name,
+		Tags:       filter.Tags,
+		Visibility: images.ImageVisibility(filter.Visibility),
+		Owner:      filter.Owner,
+		Status:     images.ImageStatusActive,
+	}
+	allPages, err := images.List(is.imagesClient, listOpts).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("Could not list images: %v", err)
+	}
+	allImages, err := images.ExtractImages(allPages)
+	if err != nil {
+		return nil, fmt.Errorf("Could not list images: %v", err)
+	}
+
+	image := newestImage(allImages, filter)
+	if image == nil {
+		return nil, fmt.Errorf("no active image matches the image filter")
+	}
+	return image, nil
+}
+
+// newestImage returns the most recently created image matching the filter,
+// or nil if none does. Only the properties of the filter are checked, the
+// other attributes are expected to be filtered by the image service.
+func newestImage(allImages []images.Image, filter *openstackconfigv1.ImageFilter) *images.Image {
+	var newest *images.Image
+	for i := range allImages {
+		image := &allImages[i]
+		if !imageHasProperties(image, filter.Properties) {
+			continue
+		}
+		if newest == nil || image.CreatedAt.After(newest.CreatedAt) {
+			newest = image
+		}
+	}
+	return newest
+}
+
+// imageHasProperties returns true if the image has all the given properties.
+func imageHasProperties(image *images.Image, properties map[string]string) bool {
+	for name, value := range properties {
+		imageValue, ok := image.Properties[name]
+		if !ok || fmt.Sprint(imageValue) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// checkImage checks an image against the flavor and root volume size it is
+// booted with.
+func checkImage(image *images.Image, flavor *flavors.Flavor, extraSpecs map[string]string, rootVolumeSize int) error {
+	if ImageNotReady(image) {
+		return &ImageNotReadyError{Name: image.Name, Status: image.Status}
+	}
+	if image.Status != images.ImageStatusActive {
+		return fmt.Errorf("image %q is not active, its status is %s", image.Name, image.Status)
+	}
+
+	if rootVolumeSize != 0 {
+		if image.MinDiskGigabytes > rootVolumeSize {
+			return fmt.Errorf("image %q requires a disk of at least %d GB, but the root volume size is %d GB", image.Name, image.MinDiskGigabytes, rootVolumeSize)
+		}
+	} else if flavor.Disk != 0 && image.MinDiskGigabytes > flavor.Disk {
+		return fmt.Errorf("image %q requires a disk of at least %d GB, but flavor %q has a %d GB disk", image.Name, image.MinDiskGigabytes, flavor.Name, flavor.Disk)
+	}
+
+	if image.MinRAMMegabytes > flavor.RAM {
+		return fmt.Errorf("image %q requires at least %d MB of RAM, but flavor %q has %d MB", image.Name, image.MinRAMMegabytes, flavor.Name, flavor.RAM)
+	}
+
+	imageArch := imageProperty(image, imageArchitectureProperty)
+	flavorArch := extraSpecs[flavorArchitectureSpec]
+	if imageArch != "" && flavorArch != "" && normalizeArch(imageArch) != normalizeArch(flavorArch) {
+		return fmt.Errorf("image %q is built for the %s architecture, but flavor %q requires %s", image.Name, imageArch, flavor.Name, flavorArch)
+	}
+
+	firmware := imageProperty(image, imageFirmwareProperty)
+	if firmware != "" && firmware != "bios" && firmware != "uefi" {
+		return fmt.Errorf("image %q has an invalid %s property %q, it must be bios or uefi", image.Name, imageFirmwareProperty, firmware)
+	}
+	if firmware != "uefi" {
+		if imageProperty(image, imageSecureBootProperty) == "required" {
+			return fmt.Errorf("image %q requires secure boot, which needs the %s property to be uefi", image.Name, imageFirmwareProperty)
+		}
+		if extraSpecs[flavorSecureBootSpec] == "required" {
+			return fmt.Errorf("flavor %q requires secure boot, but image %q does not use uefi firmware", flavor.Name, image.Name)
+		}
+	}
+
+	return nil
+}
+
+// kubernetesArchs maps the architecture names used by Glance and Nova, and
+// their common aliases, to the ones used by Kubernetes.
+var kubernetesArchs = map[string]string{
+	"x86_64":  "amd64",
+	"x86-64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+}
+
+// KubernetesArch returns the Kubernetes name of an OpenStack architecture,
+// and false if the architecture is not known.
+func KubernetesArch(arch string) (string, bool) {
+	kubeArch, ok := kubernetesArchs[strings.ToLower(arch)]
+	return kubeArch, ok
+}
+
+// normalizeArch returns the Kubernetes name of an architecture, so that the
+// aliases of the same architecture compare equal. Unknown architectures are
+// only lower cased.
+func normalizeArch(arch string) string {
+	if kubeArch, ok := KubernetesArch(arch); ok {
+		return kubeArch
+	}
+	return strings.ToLower(arch)
+}
+
+// imageProperty returns a string property of an image, or an empty string if
+// it is not set.
+func imageProperty(image *images.Image, name string) string {
+	value, _ := image.Properties[name].(string)
+	return value
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestCheckImage(t *testing.T) {
+	flavor := &flavors.Flavor{Name: "m1.large", Disk: 40, RAM: 8192}
+
+	testCases := []struct {
+		name           string
+		image          images.Image
+		extraSpecs     map[string]string
+		rootVolumeSize int
+		expectedErr    string
+	}{
+		{
+			name:  "active image",
+			image: images.Image{Name: "rhcos", Status: images.ImageStatusActive, MinDiskGigabytes: 40, MinRAMMegabytes: 8192},
+		},
+		{
+			name:        "deactivated image",
+			image:       images.Image{Name: "rhcos", Status: images.ImageStatusDeactivated},
+			expectedErr: "image \"rhcos\" is not active, its status is deactivated",
+		},
+		{
+			name:        "queued image",
+			image:       images.Image{Name: "rhcos", Status: images.ImageStatusQueued},
+			expectedErr: "image \"rhcos\" is not ready yet, its status is queued",
+		},
+		{
+			name:        "saving image",
+			image:       images.Image{Name: "rhcos", Status: images.ImageStatusSaving},
+			expectedErr: "its status is saving",
+		},
+		{
+			name:        "flavor disk too small",
+			image:       images.Image{Name: "rhcos", Status: images.ImageStatusActive, MinDiskGigabytes: 50},
+			expectedErr: "requires a disk of at least 50 GB, but flavor \"m1.large\" has a 40 GB disk",
+		},
+		{
+			name:           "root volume large enough",
+			image:          images.Image{Name: "rhcos", Status: images.ImageStatusActive, MinDiskGigabytes: 50},
+			rootVolumeSize: 100,
+		},
+		{
+			name:           "root volume too small",
+			image:          images.Image{Name: "rhcos", Status: images.ImageStatusActive, MinDiskGigabytes: 50},
+			rootVolumeSize: 25,
+			expectedErr:    "but the root volume size is 25 GB",
+		},
+		{
+			name:        "not enough RAM",
+			image:       images.Image{Name: "rhcos", Status: images.ImageStatusActive, MinRAMMegabytes: 16384},
+			expectedErr: "requires at least 16384 MB of RAM",
+		},
+		{
+			name:        "architecture mismatch",
+			image:       images.Image{Name: "rhcos", Status: images.ImageStatusActive, Properties: map[string]interface{}{"hw_architecture": "aarch64"}},
+			extraSpecs:  map[string]string{"hw:cpu_arch": "x86_64"},
+			expectedErr: "built for the aarch64 architecture",
+		},
+		{
+			name:       "architecture aliases",
+			image:      images.Image{Name: "rhcos", Status: images.ImageStatusActive, Properties: map[string]interface{}{"hw_architecture": "arm64"}},
+			extraSpecs: map[string]string{"hw:cpu_arch": "aarch64"},
+		},
+		{
+			name:       "x86_64 aliases",
+			image:      images.Image{Name: "rhcos", Status: images.ImageStatusActive, Properties: map[string]interface{}{"hw_architecture": "x86_64"}},
+			extraSpecs: map[string]string{"hw:cpu_arch": "AMD64"},
+		},
+		{
+			name:        "invalid firmware",
+			image:       images.Image{Name: "rhcos", Status: images.ImageStatusActive, Properties: map[string]interface{}{"hw_firmware_type": "efi"}},
+			expectedErr: "invalid hw_firmware_type property",
+		},
+		{
+			name:        "secure boot flavor with bios image",
+			image:       images.Image{Name: "rhcos", Status: images.ImageStatusActive},
+			extraSpecs:  map[string]string{"os:secure_boot": "required"},
+			expectedErr: "flavor \"m1.large\" requires secure boot",
+		},
+		{
+			name:       "secure boot flavor with uefi image",
+			image:      images.Image{Name: "rhcos", Status: images.ImageStatusActive, Properties: map[string]interface{}{"hw_firmware_type": "uefi"}},
+			extraSpecs: map[string]string{"os:secure_boot": "required"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkImage(&tc.image, flavor, tc.extraSpecs, tc.rootVolumeSize)
+			if tc.expectedErr == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("expected error containing %q, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestImageOf(t *testing.T) {
+	filter := &openstackconfigv1.ImageFilter{Tags: []string{"rhcos"}}
+
+	testCases := []struct {
+		name           string
+		config         openstackconfigv1.OpenstackProviderSpec
+		expectedName   string
+		expectedFilter *openstackconfigv1.ImageFilter
+	}{
+		{
+			name:         "image name",
+			config:       openstackconfigv1.OpenstackProviderSpec{Image: "rhcos", ImageFilter: filter},
+			expectedName: "rhcos",
+		},
+		{
+			name:           "image filter",
+			config:         openstackconfigv1.OpenstackProviderSpec{ImageFilter: filter},
+			expectedFilter: filter,
+		},
+		{
+			name: "root volume from image",
+			config: openstackconfigv1.OpenstackProviderSpec{
+				Image:      "ignored",
+				RootVolume: &openstackconfigv1.RootVolume{SourceType: "image", SourceUUID: "rhcos"},
+			},
+			expectedName: "rhcos",
+		},
+		{
+			name: "root volume from image filter",
+			config: openstackconfigv1.OpenstackProviderSpec{
+				ImageFilter: filter,
+				RootVolume:  &openstackconfigv1.RootVolume{SourceType: "image"},
+			},
+			expectedFilter: filter,
+		},
+		{
+			name: "root volume from volume",
+			config: openstackconfigv1.OpenstackProviderSpec{
+				ImageFilter: filter,
+				RootVolume:  &openstackconfigv1.RootVolume{SourceType: "volume", SourceUUID: "volume-id"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name, filter := ImageOf(&tc.config)
+			if name != tc.expectedName || filter != tc.expectedFilter {
+				t.Errorf("expected %q and %v, got %q and %v", tc.expectedName, tc.expectedFilter, name, filter)
+			}
+		})
+	}
+}
+
+func TestNewestImage(t *testing.T) {
+	now := time.Now()
+	allImages := []images.Image{
+		{ID: "old", CreatedAt: now.Add(-2 * time.Hour), Properties: map[string]interface{}{"os_distro": "rhcos"}},
+		{ID: "newest", CreatedAt: now, Properties: map[string]interface{}{"os_distro": "fedora"}},
+		{ID: "recent", CreatedAt: now.Add(-time.Hour), Properties: map[string]interface{}{"os_distro": "rhcos", "min_version": 412}},
+	}
+
+	testCases := []struct {
+		name       string
+		properties map[string]string
+		expectedID string
+	}{
+		{
+			name:       "no properties",
+			expectedID: "newest",
+		},
+		{
+			name:       "matching property",
+			properties: map[string]string{"os_distro": "rhcos"},
+			expectedID: "recent",
+		},
+		{
+			name:       "non string property",
+			properties: map[string]string{"min_version": "412"},
+			expectedID: "recent",
+		},
+		{
+			name:       "no match",
+			properties: map[string]string{"os_distro": "centos"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			image := newestImage(allImages, &openstackconfigv1.ImageFilter{Properties: tc.properties})
+			id := ""
+			if image != nil {
+				id = image.ID
+			}
+			if id != tc.expectedID {
+				t.Errorf("expected image %q, got %q", tc.expectedID, id)
+			}
+		})
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"net"
 	"time"
 
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/groups"
@@ -35,8 +36,12 @@ import (
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/bootfromvolume"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/floatingips"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/keypairs"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/pauseunpause"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/schedulerhints"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/servergroups"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/shelveunshelve"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/startstop"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/suspendresume"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
@@ -44,6 +49,7 @@ import (
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/portsbinding"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/portsecurity"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/qos/policies"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/trunks"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
@@ -329,7 +335,8 @@ func getSubnetsByFilter(is *InstanceService, opts *subnets.ListOpts) ([]subnets.
 	return snets, nil
 }
 
-func getOrCreatePort(is *InstanceService, name string, portOpts openstackconfigv1.PortOpts) (*ports.Port, error) {
+// PortName returns the name of the port of the server with the given options.
+func PortName(name string, portOpts openstackconfigv1.PortOpts) string {
 	portName := name
 	if portOpts.NameSuffix != "" {
 		portName = name + "-" + portOpts.NameSuffix
@@ -337,6 +344,12 @@ func getOrCreatePort(is *InstanceService, name string, portOpts openstackconfigv
 	if len(portName) > PortNameMaxSize {
 		portName = portName[len(portName)-PortNameMaxSize:]
 	}
+	return portName
+}
+
+func getOrCreatePort(is *InstanceService, name string, portOpts openstackconfigv1.PortOpts) (*ports.Port, error) {
+	defaultPortSecurity(&portOpts)
+	portName := PortName(name, portOpts)
 	existingPorts, err := listPorts(is, ports.ListOpts{
 		Name:      portName,
 		NetworkID: portOpts.NetworkID,
@@ -371,8 +384,26 @@ func getOrCreatePort(is *InstanceService, name string, portOpts openstackconfigv
 			}
 			createOpts.FixedIPs = fixedIPs
 		}
+		var createOptsBuilder ports.CreateOptsBuilder = createOpts
+		if portOpts.QoSPolicy != nil {
+			qosPolicyID, err := getQoSPolicyID(is, *portOpts.QoSPolicy)
+			if err != nil {
+				return nil, err
+			}
+			createOptsBuilder = policies.PortCreateOptsExt{
+				CreateOptsBuilder: createOpts,
+				QoSPolicyID:       qosPolicyID,
+			}
+		}
+		if portOpts.DNSName != "" || portOpts.DNSDomain != "" {
+			createOptsBuilder = portDNSCreateOpts{
+				CreateOptsBuilder: createOptsBuilder,
+				DNSName:           portOpts.DNSName,
+				DNSDomain:         portOpts.DNSDomain,
+			}
+		}
 		newPort, err := ports.Create(is.networkClient, portsbinding.CreateOptsExt{
-			CreateOptsBuilder: createOpts,
+			CreateOptsBuilder: createOptsBuilder,
 			HostID:            portOpts.HostID,
 			VNICType:          portOpts.VNICType,
 			Profile:           getPortProfile(portOpts.Profile),
@@ -405,6 +436,15 @@ func getOrCreatePort(is *InstanceService, name string, portOpts openstackconfigv
 	return nil, fmt.Errorf("multiple ports found with name \"%s\"", portName)
 }
 
+// subnetContains returns true if the address is in the CIDR of the subnet.
+func subnetContains(cidr string, address string) bool {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	return ipNet.Contains(net.ParseIP(address))
+}
+
 func getPortProfile(p map[string]string) map[string]interface{} {
 	portProfile := make(map[string]interface{})
 	for k, v := range p {
@@ -520,34 +560,8 @@ func getOrCreateTrunk(is *InstanceService, port *ports.Port, machineTags []strin
 	return &trunk, nil
 }
 
-// InstanceCreate creates a compute instance.
-// If ServerGroupName is nonempty and no server group exists with that name,
-// then InstanceCreate creates a server group with that name.
-func (is *InstanceService) InstanceCreate(clusterName string, name string, clusterSpec *openstackconfigv1.OpenstackClusterProviderSpec, config *openstackconfigv1.OpenstackProviderSpec, cmd string, keyName string, configClient configclient.ConfigV1Interface) (instance *Instance, err error) {
-	// server is only non-nil in case of successful server creation.
-	//
-	// There are multiple preparation steps in this method, some of which
-	// create resources (e.g. a volume in case of boot-from-volume with
-	// "image" source), and all of which have a chance to fail.
-	//
-	// This variable is guaranteed to remain nil until server creation is
-	// successful. Deferred cleanup functions can restore the initial state
-	// in case of failure, by only acting if "server" is nil.
-	var server *servers.Server
-
-	if config == nil {
-		return nil, fmt.Errorf("create Options need be specified to create instace")
-	}
-	if trunkSupportNeeded(config) == true {
-		trunkSupport, err := GetTrunkSupport(is)
-		if err != nil {
-			return nil, fmt.Errorf("There was an issue verifying whether trunk support is available, please disable it: %v", err)
-		}
-		if trunkSupport == false {
-			return nil, fmt.Errorf("There is no trunk support. Please disable it")
-		}
-	}
-
+// getMachineTags returns the tags of the server and ports of a machine.
+func getMachineTags(clusterName string, clusterSpec *openstackconfigv1.OpenstackClusterProviderSpec, config *openstackconfigv1.OpenstackProviderSpec) []string {
 	// Set default Tags
 	machineTags := []string{
 		"cluster-api-provider-openstack",
@@ -561,15 +575,32 @@ func (is *InstanceService) InstanceCreate(clusterName string, name string, clust
 	if clusterSpec != nil && clusterSpec.Tags != nil {
 		machineTags = append(machineTags, clusterSpec.Tags...)
 	}
+	return machineTags
+}
+
+// desiredNetworkPorts returns the options of the ports created for the
+// networks of the provider spec, with the given security groups and the
+// allowed address pairs of the cluster VIPs.
+func desiredNetworkPorts(is *InstanceService, config *openstackconfigv1.OpenstackProviderSpec, securityGroups []string, configClient configclient.ConfigV1Interface) ([]openstackconfigv1.PortOpts, error) {
+	vips, err := getClusterVIPs(configClient)
+	if err != nil {
+		return nil, err
+	}
 
-	// Get security groups
-	securityGroups, err := GetSecurityGroups(is, config.SecurityGroups)
+	nets, err := networkPortOpts(is, config, vips)
 	if err != nil {
 		return nil, err
 	}
-	// Get all network UUIDs
+	for i := range nets {
+		nets[i].SecurityGroups = &securityGroups
+	}
+	return nets, nil
+}
+
+// networkPortOpts returns the options of the ports created for the networks
+// of the provider spec, with their allowed address pairs.
+func networkPortOpts(is *InstanceService, config *openstackconfigv1.OpenstackProviderSpec, vips clusterVIPs) ([]openstackconfigv1.PortOpts, error) {
 	var nets []openstackconfigv1.PortOpts
-	subnetsWithoutAllowedAddressPairs := map[string]struct{}{}
 	for _, net := range config.Networks {
 		opts := networks.ListOpts(net.Filter)
 		opts.ID = net.UUID
@@ -579,19 +610,33 @@ func (is *InstanceService) InstanceCreate(clusterName string, name string, clust
 		}
 		for _, netID := range ids {
 			if net.Subnets == nil {
+				allowedAddressPairs := []openstackconfigv1.AddressPair{}
+				if !net.NoAllowedAddressPairs {
+					allowedAddressPairs, err = vips.addressPairs(net.ClusterVIPs, net.AllowedAddressPairs)
+					if err != nil {
+						return nil, err
+					}
+				}
+				var fixedIPs []openstackconfigv1.FixedIPs
+				if net.FixedIp != "" {
+					fixedIPs = []openstackconfigv1.FixedIPs{{IPAddress: net.FixedIp}}
+				}
 				nets = append(nets, openstackconfigv1.PortOpts{
 					NetworkID:    netID,
 					NameSuffix:   net.UUID,
+					FixedIPs:     fixedIPs,
 					Tags:         net.PortTags,
 					VNICType:     net.VNICType,
 					Profile:      net.Profile,
 					PortSecurity: net.PortSecurity,
+					QoSPolicy:    net.QoSPolicy,
+					DNSDomain:    net.DNSDomain,
+
+					AllowedAddressPairs: allowedAddressPairs,
 				})
-				if net.NoAllowedAddressPairs {
-					subnetsWithoutAllowedAddressPairs[net.UUID] = struct{}{}
-				}
 			}
 
+			fixedIPAssigned := false
 			for _, snetParam := range net.Subnets {
 				sopts := subnets.ListOpts(snetParam.Filter)
 				sopts.ID = snetParam.UUID
@@ -601,6 +646,25 @@ func (is *InstanceService) InstanceCreate(clusterName string, name string, clust
 				if snetParam.PortSecurity != nil {
 					portSecurity = snetParam.PortSecurity
 				}
+				// Inherit qosPolicy from network if unset on subnet
+				qosPolicy := net.QoSPolicy
+				if snetParam.QoSPolicy != nil {
+					qosPolicy = snetParam.QoSPolicy
+				}
+				// Inherit clusterVIPs from network if unset on subnet, and
+				// add the allowed address pairs of the subnet to those of the network
+				allowedAddressPairs := []openstackconfigv1.AddressPair{}
+				if !net.NoAllowedAddressPairs {
+					clusterVIPs := net.ClusterVIPs
+					if snetParam.ClusterVIPs != nil {
+						clusterVIPs = snetParam.ClusterVIPs
+					}
+					extraPairs := append(append([]openstackconfigv1.AddressPair{}, net.AllowedAddressPairs...), snetParam.AllowedAddressPairs...)
+					allowedAddressPairs, err = vips.addressPairs(clusterVIPs, extraPairs)
+					if err != nil {
+						return nil, err
+					}
+				}
 
 				// Query for all subnets that match filters
 				snetResults, err := getSubnetsByFilter(is, &sopts)
@@ -613,56 +677,97 @@ func (is *InstanceService) InstanceCreate(clusterName string, name string, clust
 					if snet.NetworkID != netID {
 						continue
 					}
-					if net.NoAllowedAddressPairs {
-						subnetsWithoutAllowedAddressPairs[snet.ID] = struct{}{}
+					fixedIP := openstackconfigv1.FixedIPs{SubnetID: snet.ID}
+					if net.FixedIp != "" && subnetContains(snet.CIDR, net.FixedIp) {
+						fixedIP.IPAddress = net.FixedIp
+						fixedIPAssigned = true
 					}
 					nets = append(nets, openstackconfigv1.PortOpts{
 						NetworkID:    snet.NetworkID,
 						NameSuffix:   snet.ID,
-						FixedIPs:     []openstackconfigv1.FixedIPs{{SubnetID: snet.ID}},
+						FixedIPs:     []openstackconfigv1.FixedIPs{fixedIP},
 						Tags:         append(net.PortTags, snetParam.PortTags...),
 						VNICType:     net.VNICType,
 						Profile:      net.Profile,
 						PortSecurity: portSecurity,
+						QoSPolicy:    qosPolicy,
+						DNSDomain:    net.DNSDomain,
+
+						AllowedAddressPairs: allowedAddressPairs,
 					})
 				}
 			}
+			if net.Subnets != nil && net.FixedIp != "" && !fixedIPAssigned {
+				return nil, fmt.Errorf("Fixed IP %s is not in any subnet of network %s", net.FixedIp, netID)
+			}
 		}
 	}
+	return nets, nil
+}
 
-	clusterInfra, err := configClient.Infrastructures().Get(context.TODO(), "cluster", metav1.GetOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("Failed to retrieve cluster Infrastructure object: %v", err)
-	}
+// InstanceCreate creates a compute instance. Its resources are tagged with
+// clusterName, and the hostname template is rendered with clusterInfraName.
+// If ServerGroupName is nonempty and no server group exists with that name,
+// then InstanceCreate creates a server group with that name.
+func (is *InstanceService) InstanceCreate(clusterName string, clusterInfraName string, name string, clusterSpec *openstackconfigv1.OpenstackClusterProviderSpec, config *openstackconfigv1.OpenstackProviderSpec, cmd string, keyName string, personality servers.Personality, configClient configclient.ConfigV1Interface) (instance *Instance, err error) {
+	// server is only non-nil in case of successful server creation.
+	//
+	// There are multiple preparation steps in this method, some of which
+	// create resources (e.g. a volume in case of boot-from-volume with
+	// "image" source), and all of which have a chance to fail.
+	//
+	// This variable is guaranteed to remain nil until server creation is
+	// successful. Deferred cleanup functions can restore the initial state
+	// in case of failure, by only acting if "server" is nil.
+	var server *servers.Server
 
-	allowedAddressPairs := []openstackconfigv1.AddressPair{}
-	if clusterInfra != nil && clusterInfra.Status.PlatformStatus != nil && clusterInfra.Status.PlatformStatus.OpenStack != nil {
-		clusterVips := []string{
-			clusterInfra.Status.PlatformStatus.OpenStack.APIServerInternalIP,
-			clusterInfra.Status.PlatformStatus.OpenStack.NodeDNSIP,
-			clusterInfra.Status.PlatformStatus.OpenStack.IngressIP,
+	if config == nil {
+		return nil, fmt.Errorf("create Options need be specified to create instace")
+	}
+	if trunkSupportNeeded(config) == true {
+		trunkSupport, err := GetTrunkSupport(is)
+		if err != nil {
+			return nil, fmt.Errorf("There was an issue verifying whether trunk support is available, please disable it: %v", err)
 		}
-
-		for _, vip := range clusterVips {
-			if vip != "" {
-				allowedAddressPairs = append(allowedAddressPairs, openstackconfigv1.AddressPair{IPAddress: vip})
-			}
+		if trunkSupport == false {
+			return nil, fmt.Errorf("There is no trunk support. Please disable it")
+		}
+	}
+	if qosSupportNeeded(config) {
+		qosSupport, err := GetQoSSupport(is)
+		if err != nil {
+			return nil, fmt.Errorf("There was an issue verifying whether QoS support is available: %v", err)
+		}
+		if !qosSupport {
+			return nil, fmt.Errorf("There is no QoS support. Please remove the QoS policies")
 		}
 	}
 
+	machineTags := getMachineTags(clusterName, clusterSpec, config)
+
+	dnsSupport, dnsDomainSupport, hostname, err := is.portDNSSettings(clusterInfraName, name, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get security groups
+	securityGroups, err := GetSecurityGroups(is, config.SecurityGroups)
+	if err != nil {
+		return nil, err
+	}
+	nets, err := desiredNetworkPorts(is, config, securityGroups, configClient)
+	if err != nil {
+		return nil, err
+	}
+
 	userData := base64.StdEncoding.EncodeToString([]byte(cmd))
 	var portsList []servers.Network
+	var serverPorts []*ports.Port
 	for _, portOpt := range nets {
 		if portOpt.NetworkID == "" {
 			return nil, fmt.Errorf("A network was not found or provided for one of the networks or subnets in this machineset")
 		}
-		portOpt.SecurityGroups = &securityGroups
-		portOpt.AllowedAddressPairs = allowedAddressPairs
-		if _, ok := subnetsWithoutAllowedAddressPairs[portOpt.NameSuffix]; ok {
-			portOpt.AllowedAddressPairs = []openstackconfigv1.AddressPair{}
-		}
-
-		port, err := getOrCreatePort(is, name, portOpt)
+		port, err := getOrCreatePort(is, name, portDNSAtCreation(name, portOpt, hostname, dnsSupport, dnsDomainSupport))
 		if err != nil {
 			return nil, fmt.Errorf("Failed to create port err: %v", err)
 		}
@@ -688,6 +793,7 @@ func (is *InstanceService) InstanceCreate(clusterName string, name string, clust
 		portsList = append(portsList, servers.Network{
 			Port: port.ID,
 		})
+		serverPorts = append(serverPorts, port)
 
 		if config.Trunk == true {
 			trunk, err := getOrCreateTrunk(is, port, machineTags)
@@ -711,7 +817,7 @@ func (is *InstanceService) InstanceCreate(clusterName string, name string, clust
 		if portCreateOpts.Trunk == nil {
 			portCreateOpts.Trunk = &config.Trunk
 		}
-		port, err := getOrCreatePort(is, name, portCreateOpts)
+		port, err := getOrCreatePort(is, name, portDNSAtCreation(name, portCreateOpts, hostname, dnsSupport, dnsDomainSupport))
 		if err != nil {
 			return nil, err
 		}
@@ -726,12 +832,35 @@ func (is *InstanceService) InstanceCreate(clusterName string, name string, clust
 		portsList = append(portsList, servers.Network{
 			Port: port.ID,
 		})
+		serverPorts = append(serverPorts, port)
 
 		if *portCreateOpts.Trunk == true {
-			_, err := getOrCreateTrunk(is, port, machineTags)
+			trunk, err := getOrCreateTrunk(is, port, machineTags)
 			if err != nil {
 				return nil, err
 			}
+			if len(portCreateOpts.Subports) > 0 {
+				subportPortIDs, err := is.addSubports(name, trunk, portCreateOpts, machineTags, securityGroups)
+				defer func() {
+					// The trunk is deleted first, as the ports of its
+					// subports cannot be deleted while they are in use.
+					if server == nil {
+						if err := trunks.Delete(is.networkClient, trunk.ID).ExtractErr(); err != nil {
+							klog.Infof("Failed to delete stale trunk %q", trunk.ID)
+						}
+						for _, portID := range subportPortIDs {
+							if err := ports.Delete(is.networkClient, portID).ExtractErr(); err != nil {
+								klog.Infof("Failed to delete stale port %q", portID)
+							} else {
+								klog.Infof("Deleted stale port %q", portID)
+							}
+						}
+					}
+				}()
+				if err != nil {
+					return nil, err
+				}
+			}
 		}
 	}
 
@@ -739,6 +868,20 @@ func (is *InstanceService) InstanceCreate(clusterName string, name string, clust
 		return nil, fmt.Errorf("At least one network, subnet, or port must be defined as a networking interface. Please review your machineset and try again")
 	}
 
+	configDrive := config.ConfigDrive
+	staticAddresses, err := is.hasStaticAddresses(serverPorts)
+	if err != nil {
+		return nil, err
+	}
+	if staticAddresses {
+		if configDrive == nil {
+			enabled := true
+			configDrive = &enabled
+		} else if !*configDrive {
+			klog.Warningf("Server %s has addresses on subnets without DHCP but no config drive, it may not get its network configuration", name)
+		}
+	}
+
 	var serverTags []string
 	if clusterSpec.DisableServerTags == false {
 		serverTags = machineTags
@@ -750,10 +893,11 @@ func (is *InstanceService) InstanceCreate(clusterName string, name string, clust
 	var imageID string
 
 	if config.RootVolume == nil {
-		imageID, err = imageutils.IDFromName(is.imagesClient, config.Image)
+		image, err := is.FindImage(ImageOf(config))
 		if err != nil {
 			return nil, fmt.Errorf("Create new server err: %v", err)
 		}
+		imageID = image.ID
 	}
 
 	flavorID, err := flavorutils.IDFromName(is.computeClient, config.Flavor)
@@ -771,7 +915,8 @@ func (is *InstanceService) InstanceCreate(clusterName string, name string, clust
 		SecurityGroups:   securityGroups,
 		Tags:             serverTags,
 		Metadata:         config.ServerMetadata,
-		ConfigDrive:      config.ConfigDrive,
+		ConfigDrive:      configDrive,
+		Personality:      personality,
 	}
 
 	// If the root volume Size is not 0, means boot from volume
@@ -790,7 +935,8 @@ func (is *InstanceService) InstanceCreate(clusterName string, name string, clust
 			SecurityGroups:   securityGroups,
 			Tags:             serverTags,
 			Metadata:         config.ServerMetadata,
-			ConfigDrive:      config.ConfigDrive,
+			ConfigDrive:      configDrive,
+			Personality:      personality,
 		}
 
 		if bootfromvolume.SourceType(config.RootVolume.SourceType) == bootfromvolume.SourceImage {
@@ -817,10 +963,11 @@ func (is *InstanceService) InstanceCreate(clusterName string, name string, clust
 				}
 			}
 
-			imageID, err := imageutils.IDFromName(is.imagesClient, config.RootVolume.SourceUUID)
+			image, err := is.FindImage(ImageOf(config))
 			if err != nil {
 				return nil, fmt.Errorf("Create new server err: %v", err)
 			}
+			imageID := image.ID
 
 			// Create a volume first
 			volumeCreateOpts := volumes.CreateOpts{
@@ -1006,7 +1153,7 @@ func (is *InstanceService) deleteInstancePorts(id string) error {
 		return err
 	}
 	if len(instanceInterfaces) < 1 {
-		return servers.Delete(is.computeClient, id).ExtractErr()
+		return nil
 	}
 
 	trunkSupport, err := GetTrunkSupport(is)
@@ -1032,6 +1179,10 @@ func (is *InstanceService) deleteInstancePorts(id string) error {
 				return err
 			}
 			if len(trunkInfo) == 1 {
+				subports, err := trunks.GetSubports(is.networkClient, trunkInfo[0].ID).Extract()
+				if err != nil {
+					return err
+				}
 				err = util.PollImmediate(RetryIntervalTrunkDelete, TimeoutTrunkDelete, func() (bool, error) {
 					err := trunks.Delete(is.networkClient, trunkInfo[0].ID).ExtractErr()
 					if err != nil {
@@ -1042,6 +1193,9 @@ func (is *InstanceService) deleteInstancePorts(id string) error {
 				if err != nil {
 					return fmt.Errorf("Error deleting the trunk %v", trunkInfo[0].ID)
 				}
+				if err := is.deleteSubportPorts(subports); err != nil {
+					return err
+				}
 			}
 		}
 
@@ -1068,10 +1222,19 @@ func (is *InstanceService) InstanceDelete(id string) error {
 	}
 
 	// delete instance
-	return servers.Delete(is.computeClient, id).ExtractErr()
+	err = servers.Delete(is.computeClient, id).ExtractErr()
+	if _, notFound := err.(gophercloud.ErrDefault404); notFound {
+		return nil
+	}
+	return err
 }
 
 func GetTrunkSupport(is *InstanceService) (bool, error) {
+	return hasNetworkExtension(is, "trunk")
+}
+
+// hasNetworkExtension returns true if Neutron has the extension with the given alias.
+func hasNetworkExtension(is *InstanceService, alias string) (bool, error) {
 	allPages, err := netext.List(is.networkClient).AllPages()
 	if err != nil {
 		return false, err
@@ -1083,7 +1246,7 @@ func GetTrunkSupport(is *InstanceService) (bool, error) {
 	}
 
 	for _, ext := range allExts {
-		if ext.Alias == "trunk" {
+		if ext.Alias == alias {
 			return true, nil
 		}
 	}
@@ -1149,6 +1312,163 @@ func (is *InstanceService) DoesAvailabilityZoneExist(azName string) error {
 	return fmt.Errorf("could not find compute availability zone: %s", azName)
 }
 
+// DoNetworksExist checks that every network and subnet of the provider spec
+// matches at least one OpenStack network or subnet.
+func (is *InstanceService) DoNetworksExist(nets []openstackconfigv1.NetworkParam) error {
+	for _, net := range nets {
+		opts := networks.ListOpts(net.Filter)
+		opts.ID = net.UUID
+		ids, err := getNetworkIDsByFilter(is, &opts)
+		if err != nil {
+			return fmt.Errorf("could not find network %q: %v", networkParamName(net), err)
+		}
+		if len(ids) == 0 {
+			return fmt.Errorf("could not find network %q", networkParamName(net))
+		}
+		for _, netID := range ids {
+			for _, snetParam := range net.Subnets {
+				sopts := subnets.ListOpts(snetParam.Filter)
+				sopts.ID = snetParam.UUID
+				sopts.NetworkID = netID
+				snets, err := getSubnetsByFilter(is, &sopts)
+				if err != nil {
+					return fmt.Errorf("could not find subnet %q in network %s: %v", subnetParamName(snetParam), netID, err)
+				}
+				if len(snets) == 0 {
+					return fmt.Errorf("could not find subnet %q in network %s", subnetParamName(snetParam), netID)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// hasStaticAddresses returns true if one of the ports has an address on a
+// subnet without DHCP.
+func (is *InstanceService) hasStaticAddresses(serverPorts []*ports.Port) (bool, error) {
+	checked := map[string]bool{}
+	for _, port := range serverPorts {
+		for _, fixedIP := range port.FixedIPs {
+			if checked[fixedIP.SubnetID] {
+				continue
+			}
+			checked[fixedIP.SubnetID] = true
+
+			subnet, err := subnets.Get(is.networkClient, fixedIP.SubnetID).Extract()
+			if err != nil {
+				return false, fmt.Errorf("Could not get subnet %s: %v", fixedIP.SubnetID, err)
+			}
+			if !subnet.EnableDHCP {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// ResolveNetworks returns the IDs of the networks and subnets of the provider
+// spec, in the order of the spec.
+func (is *InstanceService) ResolveNetworks(nets []openstackconfigv1.NetworkParam) (networkIDs []string, subnetIDs []string, err error) {
+	for _, net := range nets {
+		opts := networks.ListOpts(net.Filter)
+		opts.ID = net.UUID
+		ids, err := getNetworkIDsByFilter(is, &opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not find network %q: %v", networkParamName(net), err)
+		}
+		if len(ids) == 0 {
+			return nil, nil, fmt.Errorf("could not find network %q", networkParamName(net))
+		}
+		for _, netID := range ids {
+			if !isDuplicate(networkIDs, netID) {
+				networkIDs = append(networkIDs, netID)
+			}
+			for _, snetParam := range net.Subnets {
+				sopts := subnets.ListOpts(snetParam.Filter)
+				sopts.ID = snetParam.UUID
+				sopts.NetworkID = netID
+				snetResults, err := getSubnetsByFilter(is, &sopts)
+				if err != nil {
+					return nil, nil, fmt.Errorf("could not find subnet %q in network %s: %v", subnetParamName(snetParam), netID, err)
+				}
+				if len(snetResults) == 0 {
+					return nil, nil, fmt.Errorf("could not find subnet %q in network %s", subnetParamName(snetParam), netID)
+				}
+				for _, snet := range snetResults {
+					if snet.NetworkID == netID && !isDuplicate(subnetIDs, snet.ID) {
+						subnetIDs = append(subnetIDs, snet.ID)
+					}
+				}
+			}
+		}
+	}
+	return networkIDs, subnetIDs, nil
+}
+
+// DoSecurityGroupsExist checks that every security group of the provider spec
+// matches at least one OpenStack security group.
+func (is *InstanceService) DoSecurityGroupsExist(sgParams []openstackconfigv1.SecurityGroupParam) error {
+	for _, sg := range sgParams {
+		ids, err := GetSecurityGroups(is, []openstackconfigv1.SecurityGroupParam{sg})
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			name := sg.Name
+			if name == "" {
+				name = sg.UUID
+			}
+			return fmt.Errorf("could not find security group %q", name)
+		}
+	}
+	return nil
+}
+
+// GetServerGroup returns the server group the machines are added to. It
+// returns nil if the server group does not exist yet and will be created with
+// the first machine.
+func (is *InstanceService) GetServerGroup(serverGroupID, serverGroupName string) (*servergroups.ServerGroup, error) {
+	if serverGroupID != "" {
+		serverGroup, err := servergroups.Get(is.computeClient, serverGroupID).Extract()
+		if err != nil {
+			return nil, fmt.Errorf("could not find server group %s: %v", serverGroupID, err)
+		}
+		if serverGroupName != "" && serverGroup.Name != serverGroupName {
+			return nil, fmt.Errorf("incompatible ServerGroupID and ServerGroupName")
+		}
+		return serverGroup, nil
+	}
+
+	if serverGroupName == "" {
+		return nil, nil
+	}
+	serverGroups, err := getServerGroupsByName(is.computeClient, serverGroupName)
+	if err != nil {
+		return nil, err
+	}
+	switch len(serverGroups) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &serverGroups[0], nil
+	}
+	return nil, fmt.Errorf("multiple server groups found with the same ServerGroupName")
+}
+
+func networkParamName(net openstackconfigv1.NetworkParam) string {
+	if net.UUID != "" {
+		return net.UUID
+	}
+	return net.Filter.Name
+}
+
+func subnetParamName(snet openstackconfigv1.SubnetParam) string {
+	if snet.UUID != "" {
+		return snet.UUID
+	}
+	return snet.Filter.Name
+}
+
 func (is *InstanceService) GetInstance(resourceId string) (instance *Instance, err error) {
 	if resourceId == "" {
 		return nil, fmt.Errorf("ResourceId should be specified to  get detail.")
@@ -1160,6 +1480,40 @@ func (is *InstanceService) GetInstance(resourceId string) (instance *Instance, e
 	return serverToInstance(server), err
 }
 
+// DoesInstanceExist returns false if no server exists with the given ID.
+func (is *InstanceService) DoesInstanceExist(resourceId string) (bool, error) {
+	_, err := servers.Get(is.computeClient, resourceId).Extract()
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			return false, nil
+		}
+		return false, fmt.Errorf("Get server %q detail failed: %v", resourceId, err)
+	}
+	return true, nil
+}
+
+// RebootInstance hard reboots the server with the given ID.
+func (is *InstanceService) RebootInstance(resourceId string) error {
+	return servers.Reboot(is.computeClient, resourceId, servers.RebootOpts{Type: servers.HardReboot}).ExtractErr()
+}
+
+// StartInstance brings a server back to ACTIVE using the action matching its
+// current status: start for SHUTOFF, unpause for PAUSED, resume for SUSPENDED
+// and unshelve for SHELVED and SHELVED_OFFLOADED.
+func (is *InstanceService) StartInstance(resourceId string, status string) error {
+	switch status {
+	case "SHUTOFF":
+		return startstop.Start(is.computeClient, resourceId).ExtractErr()
+	case "PAUSED":
+		return pauseunpause.Unpause(is.computeClient, resourceId).ExtractErr()
+	case "SUSPENDED":
+		return suspendresume.Resume(is.computeClient, resourceId).ExtractErr()
+	case "SHELVED", "SHELVED_OFFLOADED":
+		return shelveunshelve.Unshelve(is.computeClient, resourceId, shelveunshelve.UnshelveOpts{}).ExtractErr()
+	}
+	return fmt.Errorf("server %q cannot be started from status %s", resourceId, status)
+}
+
 // SetMachineLabels set labels describing the machine
 func (is *InstanceService) SetMachineLabels(machine *machinev1.Machine, instanceID string) error {
 	if machine.Labels[MachineRegionLabelName] != "" && machine.Labels[MachineAZLabelName] != "" && machine.Labels[MachineInstanceTypeLabelName] != "" {
@@ -1205,6 +1559,15 @@ func (is *InstanceService) GetFlavorID(flavorName string) (string, error) {
 	return flavorutils.IDFromName(is.computeClient, flavorName)
 }
 
+// GetFlavorExtraSpecs returns the extra specs of a flavor.
+func (is *InstanceService) GetFlavorExtraSpecs(flavorID string) (map[string]string, error) {
+	extraSpecs, err := flavors.ListExtraSpecs(is.computeClient, flavorID).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get extra specs of flavor id %s: %v", flavorID, err)
+	}
+	return extraSpecs, nil
+}
+
 func serverToInstance(server *servers.Server) *Instance {
 	return &Instance{*server}
 }
@@ -17,10 +17,27 @@ limitations under the License.
 package clients
 
 import (
+	"fmt"
+	"net/http"
 	"strings"
 	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
 )
 
+// newFakeInstanceService returns an instance service whose clients talk to the
+// testhelper server. th.SetupHTTP must be called first.
+func newFakeInstanceService() *InstanceService {
+	networkClient := fakeclient.ServiceClient()
+	networkClient.ResourceBase = networkClient.Endpoint + "v2.0/"
+	return &InstanceService{
+		computeClient: fakeclient.ServiceClient(),
+		networkClient: networkClient,
+	}
+}
+
 func TestMachineServiceInstance(t *testing.T) {
 	_, err := NewInstanceService()
 	if !(strings.Contains(err.Error(), "[auth_url]")) {
@@ -61,3 +78,67 @@ func equal(a, b []string) bool {
 	}
 	return true
 }
+
+func TestDoNetworksExist(t *testing.T) {
+	testCases := []struct {
+		name          string
+		networks      string
+		subnets       string
+		expectedError bool
+	}{
+		{
+			name:     "network and subnet exist",
+			networks: `{"networks": [{"id": "net-1", "name": "machines"}]}`,
+			subnets:  `{"subnets": [{"id": "subnet-1", "network_id": "net-1"}]}`,
+		},
+		{
+			name:          "network not found",
+			networks:      `{"networks": []}`,
+			subnets:       `{"subnets": [{"id": "subnet-1", "network_id": "net-1"}]}`,
+			expectedError: true,
+		},
+		{
+			name:          "subnet not found",
+			networks:      `{"networks": [{"id": "net-1", "name": "machines"}]}`,
+			subnets:       `{"subnets": []}`,
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			th.SetupHTTP()
+			defer th.TeardownHTTP()
+			th.Mux.HandleFunc("/v2.0/networks", func(w http.ResponseWriter, r *http.Request) {
+				th.TestMethod(t, r, "GET")
+				w.Header().Add("Content-Type", "application/json")
+				fmt.Fprint(w, tc.networks)
+			})
+			th.Mux.HandleFunc("/v2.0/subnets", func(w http.ResponseWriter, r *http.Request) {
+				th.TestMethod(t, r, "GET")
+				w.Header().Add("Content-Type", "application/json")
+				fmt.Fprint(w, tc.subnets)
+			})
+
+			is := newFakeInstanceService()
+			nets := []openstackconfigv1.NetworkParam{{
+				Filter:  openstackconfigv1.Filter{Name: "machines"},
+				Subnets: []openstackconfigv1.SubnetParam{{Filter: openstackconfigv1.SubnetFilter{Name: "machines"}}},
+			}}
+			err := is.DoNetworksExist(nets)
+			if tc.expectedError && err == nil {
+				t.Errorf("expected an error")
+			}
+			if !tc.expectedError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			_, _, err = is.ResolveNetworks(nets)
+			if tc.expectedError && err == nil {
+				t.Errorf("expected ResolveNetworks to fail")
+			}
+			if !tc.expectedError && err != nil {
+				t.Errorf("unexpected ResolveNetworks error: %v", err)
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@ package clients
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
@@ -91,6 +92,82 @@ func (s *NetworkService) Reconcile(clusterName string, desired openstackconfigv1
 	return nil
 }
 
+// Delete deletes the router, subnet and network of the cluster recorded in
+// the status. Nothing is deleted while ports other than those of the router
+// and the DHCP agents remain on the network, such as the ports of servers
+// which are still being deleted, and an error is returned so that the
+// deletion is retried.
+func (s *NetworkService) Delete(clusterName string, status *openstackconfigv1.OpenstackClusterProviderStatus) error {
+	network := status.Network
+	if network == nil {
+		return nil
+	}
+	portList, err := s.getNetworkPorts(network.ID)
+	if err != nil {
+		return err
+	}
+	if inUse := portsInUse(portList); len(inUse) > 0 {
+		return fmt.Errorf("network %s is still used by ports %v", network.ID, inUse)
+	}
+	klog.Infof("Deleting network components for cluster %s", clusterName)
+
+	if network.Router != nil {
+		if network.Subnet != nil {
+			_, err := routers.RemoveInterface(s.client, network.Router.ID, routers.RemoveInterfaceOpts{
+				SubnetID: network.Subnet.ID,
+			}).Extract()
+			if _, notFound := err.(gophercloud.ErrDefault404); err != nil && !notFound {
+				return fmt.Errorf("unable to remove router interface: %v", err)
+			}
+		}
+		err := routers.Delete(s.client, network.Router.ID).ExtractErr()
+		if _, notFound := err.(gophercloud.ErrDefault404); err != nil && !notFound {
+			return fmt.Errorf("unable to delete router %s: %v", network.Router.ID, err)
+		}
+		network.Router = nil
+	}
+
+	if network.Subnet != nil {
+		err := subnets.Delete(s.client, network.Subnet.ID).ExtractErr()
+		if _, notFound := err.(gophercloud.ErrDefault404); err != nil && !notFound {
+			return fmt.Errorf("unable to delete subnet %s: %v", network.Subnet.ID, err)
+		}
+		network.Subnet = nil
+	}
+
+	err = networks.Delete(s.client, network.ID).ExtractErr()
+	if _, notFound := err.(gophercloud.ErrDefault404); err != nil && !notFound {
+		return fmt.Errorf("unable to delete network %s: %v", network.ID, err)
+	}
+	status.Network = nil
+	return nil
+}
+
+// getNetworkPorts returns the ports of the network.
+func (s *NetworkService) getNetworkPorts(networkID string) ([]ports.Port, error) {
+	allPages, err := ports.List(s.client, ports.ListOpts{
+		NetworkID: networkID,
+	}).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the ports of network %s: %v", networkID, err)
+	}
+	return ports.ExtractPorts(allPages)
+}
+
+// portsInUse returns the IDs of the ports which prevent the deletion of their
+// network. The ports owned by Neutron, such as router interfaces and DHCP
+// ports, are deleted along with the router and the network.
+func portsInUse(portList []ports.Port) []string {
+	var inUse []string
+	for _, port := range portList {
+		if strings.HasPrefix(port.DeviceOwner, "network:") {
+			continue
+		}
+		inUse = append(inUse, port.ID)
+	}
+	return inUse
+}
+
 func (s *NetworkService) reconcileNetwork(clusterName, networkName string, desired openstackconfigv1.OpenstackClusterProviderSpec) (openstackconfigv1.Network, error) {
 	klog.Infof("Reconciling network %s", networkName)
 	emptyNetwork := openstackconfigv1.Network{}
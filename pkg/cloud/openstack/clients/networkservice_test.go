@@ -0,0 +1,117 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestNetworkServiceDelete(t *testing.T) {
+	testCases := []struct {
+		name            string
+		ports           string
+		expectErr       bool
+		expectedDeleted []string
+	}{
+		{
+			name:      "ports of servers left",
+			ports:     `[{"id": "port-dhcp", "device_owner": "network:dhcp"}, {"id": "port-server", "device_owner": "compute:nova"}]`,
+			expectErr: true,
+		},
+		{
+			name:            "only Neutron ports left",
+			ports:           `[{"id": "port-dhcp", "device_owner": "network:dhcp"}, {"id": "port-router", "device_owner": "network:router_interface"}]`,
+			expectedDeleted: []string{"router", "subnet", "network"},
+		},
+		{
+			name:            "resources already deleted",
+			ports:           `[]`,
+			expectedDeleted: []string{"router", "subnet", "network"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			th.SetupHTTP()
+			defer th.TeardownHTTP()
+
+			var deleted []string
+			th.Mux.HandleFunc("/v2.0/ports", func(w http.ResponseWriter, r *http.Request) {
+				th.TestMethod(t, r, "GET")
+				th.TestFormValues(t, r, map[string]string{"network_id": "net"})
+				w.Header().Add("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"ports": %s}`, tc.ports)
+			})
+			th.Mux.HandleFunc("/v2.0/routers/router/remove_router_interface", func(w http.ResponseWriter, r *http.Request) {
+				th.TestMethod(t, r, "PUT")
+				w.Header().Add("Content-Type", "application/json")
+				fmt.Fprint(w, `{"subnet_id": "subnet", "port_id": "port-router"}`)
+			})
+			for path, resource := range map[string]string{"/v2.0/routers/router": "router", "/v2.0/subnets/subnet": "subnet", "/v2.0/networks/net": "network"} {
+				resource := resource
+				th.Mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+					th.TestMethod(t, r, "DELETE")
+					deleted = append(deleted, resource)
+					if tc.ports == `[]` {
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+					w.WriteHeader(http.StatusNoContent)
+				})
+			}
+
+			status := &openstackconfigv1.OpenstackClusterProviderStatus{Network: &openstackconfigv1.Network{
+				ID:     "net",
+				Subnet: &openstackconfigv1.Subnet{ID: "subnet"},
+				Router: &openstackconfigv1.Router{ID: "router"},
+			}}
+			networkClient := fakeclient.ServiceClient()
+			networkClient.ResourceBase = networkClient.Endpoint + "v2.0/"
+			s, err := NewNetworkService(networkClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = s.Delete("ocp", status)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				if status.Network == nil {
+					t.Errorf("expected the network to be kept in the status")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if status.Network != nil {
+					t.Errorf("expected the network to be removed from the status, got %+v", status.Network)
+				}
+			}
+			if !reflect.DeepEqual(deleted, tc.expectedDeleted) {
+				t.Errorf("expected %v to be deleted, got %v", tc.expectedDeleted, deleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/objectstorage/v1/containers"
+	"github.com/gophercloud/gophercloud/openstack/objectstorage/v1/objects"
+)
+
+// UploadUserData stores user data in an object of a Swift container, creating
+// the container if needed, and returns a temporary URL to download it. The
+// account of the project must have a temporary URL key.
+func (is *InstanceService) UploadUserData(containerName, objectName string, userData []byte, ttl time.Duration) (string, error) {
+	objectClient, err := openstack.NewObjectStorageV1(is.provider, gophercloud.EndpointOpts{
+		Region: is.regionName,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Create ObjectStorageClient err: %v", err)
+	}
+
+	if _, err := containers.Create(objectClient, containerName, nil).Extract(); err != nil {
+		return "", fmt.Errorf("Could not create container %s: %v", containerName, err)
+	}
+
+	createOpts := objects.CreateOpts{
+		Content:     bytes.NewReader(userData),
+		ContentType: "application/json",
+	}
+	if _, err := objects.Create(objectClient, containerName, objectName, createOpts).Extract(); err != nil {
+		return "", fmt.Errorf("Could not upload object %s/%s: %v", containerName, objectName, err)
+	}
+
+	url, err := objects.CreateTempURL(objectClient, containerName, objectName, objects.CreateTempURLOpts{
+		Method: objects.GET,
+		TTL:    int(ttl.Seconds()),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Could not create a temporary URL for object %s/%s: %v", containerName, objectName, err)
+	}
+	return url, nil
+}
+
+// DeleteUserData deletes user data stored by UploadUserData. It returns nil if
+// the object does not exist.
+func (is *InstanceService) DeleteUserData(containerName, objectName string) error {
+	objectClient, err := openstack.NewObjectStorageV1(is.provider, gophercloud.EndpointOpts{
+		Region: is.regionName,
+	})
+	if err != nil {
+		return fmt.Errorf("Create ObjectStorageClient err: %v", err)
+	}
+
+	_, err = objects.Delete(objectClient, containerName, objectName, nil).Extract()
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			return nil
+		}
+		return fmt.Errorf("Could not delete object %s/%s: %v", containerName, objectName, err)
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/attachinterfaces"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/portsecurity"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/qos/policies"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	configclient "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
+	"k8s.io/klog/v2"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+// reconciledPort is a port along with the attributes of its extensions which
+// are reconciled.
+type reconciledPort struct {
+	ports.Port
+	portsecurity.PortSecurityExt
+	policies.QoSPolicyExt
+	PortDNSExt
+}
+
+// ReconcilePorts updates the ports of the server to match the provider spec,
+// and returns the ports attached to the server since it was created.
+//
+// Missing tags, allowed address pairs and trunk subports are added, and QoS
+// policies and DNS names and domains are set.
+// Security groups, allowed address pairs and port security are only replaced
+// if UpdatePortSecurity is set in the provider spec. Ports added to the
+// provider spec are created and attached to the server. The attached ports
+// removed from the provider spec are detached and deleted if DetachPorts is
+// set in the provider spec. Ports are only attached and detached if the ID of
+// the server is given. Ports are tagged with clusterName, and the hostname
+// template is rendered with clusterInfraName.
+func (is *InstanceService) ReconcilePorts(clusterName string, clusterInfraName string, name string, instanceID string, clusterSpec *openstackconfigv1.OpenstackClusterProviderSpec, config *openstackconfigv1.OpenstackProviderSpec, configClient configclient.ConfigV1Interface, attachedPorts []openstackconfigv1.AttachedPort) ([]openstackconfigv1.AttachedPort, error) {
+	qosPolicies := newQoSPolicyResolver(is)
+	dnsSupport, dnsDomainSupport, hostname, err := is.portDNSSettings(clusterInfraName, name, config)
+	if err != nil {
+		return attachedPorts, err
+	}
+
+	securityGroups, err := GetSecurityGroups(is, config.SecurityGroups)
+	if err != nil {
+		return attachedPorts, err
+	}
+	nets, err := desiredNetworkPorts(is, config, securityGroups, configClient)
+	if err != nil {
+		return attachedPorts, err
+	}
+	machineTags := getMachineTags(clusterName, clusterSpec, config)
+
+	var desiredNames []string
+	for _, portOpts := range append(nets, config.Ports...) {
+		portName := PortName(name, portOpts)
+		desiredNames = append(desiredNames, portName)
+		pages, err := ports.List(is.networkClient, ports.ListOpts{
+			Name:      portName,
+			NetworkID: portOpts.NetworkID,
+		}).AllPages()
+		if err != nil {
+			return attachedPorts, err
+		}
+		var portList []reconciledPort
+		if err := ports.ExtractPortsInto(pages, &portList); err != nil {
+			return attachedPorts, err
+		}
+		if len(portList) == 0 && instanceID != "" {
+			port, err := is.createPort(name, portDNSAtCreation(name, portOpts, hostname, dnsSupport, dnsDomainSupport))
+			if err != nil {
+				return attachedPorts, err
+			}
+			portList = append(portList, *port)
+		}
+		if len(portList) != 1 {
+			klog.V(3).Infof("Skipping reconciliation of port %s of server %s, %d ports found", portName, name, len(portList))
+			continue
+		}
+		port := portList[0]
+
+		if port.DeviceID == "" && instanceID != "" {
+			klog.Infof("Attaching port %s to server %s", port.ID, name)
+			if _, err := attachinterfaces.Create(is.computeClient, instanceID, attachinterfaces.CreateOpts{PortID: port.ID}).Extract(); err != nil {
+				return attachedPorts, fmt.Errorf("Failed to attach port %s: %v", port.ID, err)
+			}
+			attachedPorts = append(attachedPorts, openstackconfigv1.AttachedPort{
+				ID:        port.ID,
+				Name:      portName,
+				NetworkID: port.NetworkID,
+			})
+		}
+
+		missingTags := missingStrings(port.Tags, append(append([]string{}, machineTags...), portOpts.Tags...))
+		if len(missingTags) > 0 {
+			klog.Infof("Adding tags %v to port %s of server %s", missingTags, port.ID, name)
+			_, err = attributestags.ReplaceAll(is.networkClient, "ports", port.ID, attributestags.ReplaceAllOpts{
+				Tags: append(port.Tags, missingTags...)}).Extract()
+			if err != nil {
+				return attachedPorts, fmt.Errorf("Tagging port %s err: %v", port.ID, err)
+			}
+		}
+
+		if portOpts.QoSPolicy != nil {
+			qosPolicyID, err := qosPolicies.policyIDFor(port.QoSPolicyID, *portOpts.QoSPolicy)
+			if err != nil {
+				return attachedPorts, err
+			}
+			if port.QoSPolicyID != qosPolicyID {
+				klog.Infof("Setting QoS policy %s on port %s of server %s", qosPolicyID, port.ID, name)
+				_, err := ports.Update(is.networkClient, port.ID, policies.PortUpdateOptsExt{
+					UpdateOptsBuilder: ports.UpdateOpts{},
+					QoSPolicyID:       &qosPolicyID,
+				}).Extract()
+				if err != nil {
+					return attachedPorts, fmt.Errorf("Failed to set QoS policy on port %s: %v", port.ID, err)
+				}
+			}
+		}
+
+		if updateOpts, needed := portSecurityUpdate(port, portOpts, config.UpdatePortSecurity); needed {
+			klog.Infof("Updating security of port %s of server %s", port.ID, name)
+			_, err := ports.Update(is.networkClient, port.ID, updateOpts).Extract()
+			if err != nil {
+				return attachedPorts, fmt.Errorf("Failed to update security of port %s: %v", port.ID, err)
+			}
+		}
+
+		if dnsSupport {
+			if updateOpts, needed := portDNSUpdate(port, portOpts, hostname, dnsDomainSupport); needed {
+				klog.Infof("Updating DNS name and domain of port %s of server %s", port.ID, name)
+				_, err := ports.Update(is.networkClient, port.ID, updateOpts).Extract()
+				if err != nil {
+					return attachedPorts, fmt.Errorf("Failed to update DNS name of port %s: %v", port.ID, err)
+				}
+			}
+		}
+
+		if len(portOpts.Subports) > 0 {
+			trunk, err := getTrunk(is, port.ID)
+			if err != nil {
+				return attachedPorts, err
+			}
+			if trunk == nil {
+				klog.V(3).Infof("Skipping reconciliation of the subports of port %s of server %s, it has no trunk", port.ID, name)
+				continue
+			}
+			if _, err := is.addSubports(name, trunk, portOpts, machineTags, securityGroups); err != nil {
+				return attachedPorts, err
+			}
+		}
+	}
+	return is.detachRemovedPorts(instanceID, config, attachedPorts, desiredNames)
+}
+
+// createPort creates a port for the server, which is attached to it later.
+func (is *InstanceService) createPort(name string, portOpts openstackconfigv1.PortOpts) (*reconciledPort, error) {
+	newPort, err := getOrCreatePort(is, name, portOpts)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create port err: %v", err)
+	}
+	klog.Infof("Created port %s for server %s", newPort.ID, name)
+
+	var port reconciledPort
+	if err := ports.Get(is.networkClient, newPort.ID).ExtractInto(&port); err != nil {
+		return nil, err
+	}
+	return &port, nil
+}
+
+// detachRemovedPorts detaches and deletes the attached ports which are not in
+// the provider spec anymore, if DetachPorts is set, and returns the remaining
+// attached ports.
+func (is *InstanceService) detachRemovedPorts(instanceID string, config *openstackconfigv1.OpenstackProviderSpec, attachedPorts []openstackconfigv1.AttachedPort, desiredNames []string) ([]openstackconfigv1.AttachedPort, error) {
+	var remaining []openstackconfigv1.AttachedPort
+	for i, attachedPort := range attachedPorts {
+		if isDuplicate(desiredNames, attachedPort.Name) || !config.DetachPorts || instanceID == "" {
+			remaining = append(remaining, attachedPort)
+			continue
+		}
+
+		klog.Infof("Detaching port %s from server %s", attachedPort.ID, instanceID)
+		err := attachinterfaces.Delete(is.computeClient, instanceID, attachedPort.ID).ExtractErr()
+		if _, notFound := err.(gophercloud.ErrDefault404); err != nil && !notFound {
+			return append(remaining, attachedPorts[i:]...), fmt.Errorf("Failed to detach port %s: %v", attachedPort.ID, err)
+		}
+		err = ports.Delete(is.networkClient, attachedPort.ID).ExtractErr()
+		if _, notFound := err.(gophercloud.ErrDefault404); err != nil && !notFound {
+			return append(remaining, attachedPorts[i:]...), fmt.Errorf("Failed to delete port %s: %v", attachedPort.ID, err)
+		}
+	}
+	return remaining, nil
+}
+
+// portSecurityUpdate returns the update of the security groups, allowed
+// address pairs and port security of the port needed to match the port
+// options, and whether there is anything to update. Unless updatePortSecurity
+// is set, only the missing allowed address pairs are added.
+func portSecurityUpdate(port reconciledPort, portOpts openstackconfigv1.PortOpts, updatePortSecurity bool) (portsecurity.PortUpdateOptsExt, bool) {
+	updateOpts := ports.UpdateOpts{}
+	update := portsecurity.PortUpdateOptsExt{}
+	needed := false
+
+	portSecurity := port.PortSecurityEnabled
+	if updatePortSecurity && portOpts.PortSecurity != nil && *portOpts.PortSecurity != port.PortSecurityEnabled {
+		portSecurity = *portOpts.PortSecurity
+		update.PortSecurityEnabled = &portSecurity
+		needed = true
+		if !portSecurity {
+			updateOpts.SecurityGroups = &[]string{}
+			updateOpts.AllowedAddressPairs = &[]ports.AddressPair{}
+		}
+	}
+
+	// Ports without port security have neither security groups nor allowed
+	// address pairs.
+	if portSecurity {
+		if portOpts.AllowedAddressPairs != nil {
+			// Neutron uses the MAC address of the port for pairs without one.
+			desiredPairs := make([]ports.AddressPair, 0, len(portOpts.AllowedAddressPairs))
+			for _, pair := range portOpts.AllowedAddressPairs {
+				desired := ports.AddressPair{IPAddress: pair.IPAddress, MACAddress: pair.MACAddress}
+				if desired.MACAddress == "" {
+					desired.MACAddress = port.MACAddress
+				}
+				desiredPairs = append(desiredPairs, desired)
+			}
+			missingPairs := missingAddressPairs(port.AllowedAddressPairs, desiredPairs)
+
+			if updatePortSecurity {
+				if len(missingPairs) > 0 || len(missingAddressPairs(desiredPairs, port.AllowedAddressPairs)) > 0 {
+					updateOpts.AllowedAddressPairs = &desiredPairs
+					needed = true
+				}
+			} else if len(missingPairs) > 0 {
+				pairs := append(append([]ports.AddressPair{}, port.AllowedAddressPairs...), missingPairs...)
+				updateOpts.AllowedAddressPairs = &pairs
+				needed = true
+			}
+		}
+
+		if updatePortSecurity && portOpts.SecurityGroups != nil {
+			if len(missingStrings(port.SecurityGroups, *portOpts.SecurityGroups)) > 0 || len(missingStrings(*portOpts.SecurityGroups, port.SecurityGroups)) > 0 {
+				updateOpts.SecurityGroups = portOpts.SecurityGroups
+				needed = true
+			}
+		}
+	}
+
+	update.UpdateOptsBuilder = updateOpts
+	return update, needed
+}
+
+// UpdateSecurityGroups adds the security groups missing from the given ports
+// of the server and removes the unwanted ones. Ports without port security
+// are left alone, and only the ports whose groups change are updated.
+func (is *InstanceService) UpdateSecurityGroups(instancePorts []InstancePort, add []string, remove []string) error {
+	for _, port := range instancePorts {
+		if !port.PortSecurityEnabled {
+			continue
+		}
+		groups, changed := updatedSecurityGroups(port.SecurityGroups, add, remove)
+		if !changed {
+			continue
+		}
+		klog.Infof("Updating security groups of port %s of server %s to %v", port.ID, port.DeviceID, groups)
+		if _, err := ports.Update(is.networkClient, port.ID, ports.UpdateOpts{SecurityGroups: &groups}).Extract(); err != nil {
+			return fmt.Errorf("Failed to update security groups of port %s: %v", port.ID, err)
+		}
+	}
+	return nil
+}
+
+// updatedSecurityGroups returns the security groups with the missing groups
+// of add and without those of remove, and whether they changed.
+func updatedSecurityGroups(securityGroups []string, add []string, remove []string) ([]string, bool) {
+	groups := []string{}
+	changed := false
+	for _, group := range securityGroups {
+		if isDuplicate(remove, group) {
+			changed = true
+			continue
+		}
+		groups = append(groups, group)
+	}
+	if missing := missingStrings(groups, add); len(missing) > 0 {
+		groups = append(groups, missing...)
+		changed = true
+	}
+	return groups, changed
+}
+
+// missingAddressPairs returns the pairs of desired which are not in pairs.
+func missingAddressPairs(pairs []ports.AddressPair, desired []ports.AddressPair) []ports.AddressPair {
+	var missing []ports.AddressPair
+	for _, pair := range desired {
+		if !hasAddressPair(pairs, pair) && !hasAddressPair(missing, pair) {
+			missing = append(missing, pair)
+		}
+	}
+	return missing
+}
+
+// missingStrings returns the strings of desired which are not in list.
+func missingStrings(list []string, desired []string) []string {
+	var missing []string
+	for _, s := range desired {
+		if !isDuplicate(list, s) && !isDuplicate(missing, s) {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+func hasAddressPair(pairs []ports.AddressPair, pair ports.AddressPair) bool {
+	for _, p := range pairs {
+		if p == pair {
+			return true
+		}
+	}
+	return false
+}
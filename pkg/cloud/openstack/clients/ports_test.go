@@ -0,0 +1,320 @@
+package clients
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	configclient "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
+	"k8s.io/client-go/rest"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestPortSecurityUpdate(t *testing.T) {
+	const mac = "fa:16:3e:00:00:01"
+	apiVIP := ports.AddressPair{IPAddress: "10.0.0.5", MACAddress: mac}
+	ingressVIP := ports.AddressPair{IPAddress: "10.0.0.7", MACAddress: mac}
+	enabled, disabled := true, false
+
+	newPort := func(portSecurity bool, securityGroups []string, pairs ...ports.AddressPair) reconciledPort {
+		port := reconciledPort{}
+		port.MACAddress = mac
+		port.SecurityGroups = securityGroups
+		port.AllowedAddressPairs = pairs
+		port.PortSecurityEnabled = portSecurity
+		return port
+	}
+	vipOpts := []openstackconfigv1.AddressPair{{IPAddress: "10.0.0.5"}, {IPAddress: "10.0.0.7"}}
+
+	testCases := []struct {
+		name               string
+		port               reconciledPort
+		portOpts           openstackconfigv1.PortOpts
+		updatePortSecurity bool
+		expectedNeeded     bool
+		expectedOpts       ports.UpdateOpts
+		expectedSecurity   *bool
+	}{
+		{
+			name:           "up to date",
+			port:           newPort(true, []string{"sg-1"}, apiVIP, ingressVIP),
+			portOpts:       openstackconfigv1.PortOpts{SecurityGroups: &[]string{"sg-1"}, AllowedAddressPairs: vipOpts},
+			expectedNeeded: false,
+		},
+		{
+			name:           "missing VIP is added",
+			port:           newPort(true, []string{"sg-1"}, apiVIP),
+			portOpts:       openstackconfigv1.PortOpts{SecurityGroups: &[]string{"sg-2"}, AllowedAddressPairs: vipOpts},
+			expectedNeeded: true,
+			expectedOpts:   ports.UpdateOpts{AllowedAddressPairs: &[]ports.AddressPair{apiVIP, ingressVIP}},
+		},
+		{
+			name:           "extra pair is kept without updatePortSecurity",
+			port:           newPort(true, nil, apiVIP, ingressVIP),
+			portOpts:       openstackconfigv1.PortOpts{AllowedAddressPairs: vipOpts[:1]},
+			expectedNeeded: false,
+		},
+		{
+			name:               "pairs and security groups are replaced with updatePortSecurity",
+			port:               newPort(true, []string{"sg-1"}, apiVIP, ingressVIP),
+			portOpts:           openstackconfigv1.PortOpts{SecurityGroups: &[]string{"sg-2"}, AllowedAddressPairs: vipOpts[:1]},
+			updatePortSecurity: true,
+			expectedNeeded:     true,
+			expectedOpts: ports.UpdateOpts{
+				SecurityGroups:      &[]string{"sg-2"},
+				AllowedAddressPairs: &[]ports.AddressPair{apiVIP},
+			},
+		},
+		{
+			name:           "ports without port security are left alone",
+			port:           newPort(false, nil),
+			portOpts:       openstackconfigv1.PortOpts{SecurityGroups: &[]string{"sg-1"}, AllowedAddressPairs: vipOpts, PortSecurity: &enabled},
+			expectedNeeded: false,
+		},
+		{
+			name:               "port security is disabled with updatePortSecurity",
+			port:               newPort(true, []string{"sg-1"}, apiVIP),
+			portOpts:           openstackconfigv1.PortOpts{SecurityGroups: &[]string{"sg-1"}, AllowedAddressPairs: vipOpts, PortSecurity: &disabled},
+			updatePortSecurity: true,
+			expectedNeeded:     true,
+			expectedOpts: ports.UpdateOpts{
+				SecurityGroups:      &[]string{},
+				AllowedAddressPairs: &[]ports.AddressPair{},
+			},
+			expectedSecurity: &disabled,
+		},
+		{
+			name:               "port security is enabled with updatePortSecurity",
+			port:               newPort(false, nil),
+			portOpts:           openstackconfigv1.PortOpts{SecurityGroups: &[]string{"sg-1"}, AllowedAddressPairs: vipOpts[:1], PortSecurity: &enabled},
+			updatePortSecurity: true,
+			expectedNeeded:     true,
+			expectedOpts: ports.UpdateOpts{
+				SecurityGroups:      &[]string{"sg-1"},
+				AllowedAddressPairs: &[]ports.AddressPair{apiVIP},
+			},
+			expectedSecurity: &enabled,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			update, needed := portSecurityUpdate(tc.port, tc.portOpts, tc.updatePortSecurity)
+			if needed != tc.expectedNeeded {
+				t.Fatalf("Expected needed %v, got %v", tc.expectedNeeded, needed)
+			}
+			if !needed {
+				return
+			}
+			if !reflect.DeepEqual(update.UpdateOptsBuilder, tc.expectedOpts) {
+				t.Errorf("Expected update %+v, got %+v", tc.expectedOpts, update.UpdateOptsBuilder)
+			}
+			if !reflect.DeepEqual(update.PortSecurityEnabled, tc.expectedSecurity) {
+				t.Errorf("Expected port security %v, got %v", tc.expectedSecurity, update.PortSecurityEnabled)
+			}
+		})
+	}
+}
+
+func TestMissingStrings(t *testing.T) {
+	missing := missingStrings([]string{"a", "b"}, []string{"b", "c", "c", "d"})
+	if !reflect.DeepEqual(missing, []string{"c", "d"}) {
+		t.Errorf("Expected [c d], got %v", missing)
+	}
+}
+
+func TestDetachRemovedPortsKeepsPorts(t *testing.T) {
+	attachedPorts := []openstackconfigv1.AttachedPort{
+		{ID: "port-1", Name: "worker-0-storage"},
+		{ID: "port-2", Name: "worker-0-sriov"},
+	}
+	is := &InstanceService{}
+
+	// Without detachPorts, removed ports stay attached
+	remaining, err := is.detachRemovedPorts("server", &openstackconfigv1.OpenstackProviderSpec{}, attachedPorts, []string{"worker-0-storage"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(remaining, attachedPorts) {
+		t.Errorf("Expected %v, got %v", attachedPorts, remaining)
+	}
+
+	// Ports still in the provider spec are never detached
+	remaining, err = is.detachRemovedPorts("server", &openstackconfigv1.OpenstackProviderSpec{DetachPorts: true}, attachedPorts, []string{"worker-0-storage", "worker-0-sriov"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(remaining, attachedPorts) {
+		t.Errorf("Expected %v, got %v", attachedPorts, remaining)
+	}
+}
+
+// fakePortsCloud serves the Neutron and Nova requests of ReconcilePorts and
+// records the ports created, attached and detached.
+type fakePortsCloud struct {
+	created  []string
+	attached []string
+	detached []string
+	deleted  []string
+}
+
+func (c *fakePortsCloud) setup(t *testing.T) {
+	th.Mux.HandleFunc("/apis/config.openshift.io/v1/infrastructures/cluster", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		fmt.Fprint(w, `{"kind": "Infrastructure", "apiVersion": "config.openshift.io/v1", "status": {}}`)
+	})
+	th.Mux.HandleFunc("/v2.0/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		switch r.Method {
+		case "GET":
+			if len(c.created) == 0 {
+				fmt.Fprint(w, `{"ports": []}`)
+				return
+			}
+			fmt.Fprint(w, `{"ports": [{"id": "port-new", "name": "worker-0-storage", "network_id": "net-2"}]}`)
+		case "POST":
+			c.created = append(c.created, "port-new")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"port": {"id": "port-new", "name": "worker-0-storage", "network_id": "net-2"}}`)
+		}
+	})
+	th.Mux.HandleFunc("/v2.0/ports/port-new", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "GET")
+		w.Header().Add("Content-Type", "application/json")
+		fmt.Fprint(w, `{"port": {"id": "port-new", "name": "worker-0-storage", "network_id": "net-2"}}`)
+	})
+	th.Mux.HandleFunc("/v2.0/ports/port-new/tags", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "PUT")
+		w.Header().Add("Content-Type", "application/json")
+		fmt.Fprint(w, `{"tags": []}`)
+	})
+	th.Mux.HandleFunc("/v2.0/ports/port-old", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "DELETE")
+		c.deleted = append(c.deleted, "port-old")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	th.Mux.HandleFunc("/servers/server-1/os-interface", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "POST")
+		c.attached = append(c.attached, "port-new")
+		w.Header().Add("Content-Type", "application/json")
+		fmt.Fprint(w, `{"interfaceAttachment": {"port_id": "port-new", "net_id": "net-2", "port_state": "ACTIVE"}}`)
+	})
+	th.Mux.HandleFunc("/servers/server-1/os-interface/port-old", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "DELETE")
+		c.detached = append(c.detached, "port-old")
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+func TestReconcilePortsAttachAndDetach(t *testing.T) {
+	config := &openstackconfigv1.OpenstackProviderSpec{
+		Ports:       []openstackconfigv1.PortOpts{{NameSuffix: "storage", NetworkID: "net-2"}},
+		DetachPorts: true,
+	}
+	attachedPorts := []openstackconfigv1.AttachedPort{{ID: "port-old", Name: "worker-0-sriov", NetworkID: "net-3"}}
+
+	testCases := []struct {
+		name             string
+		instanceID       string
+		expectedAttached []string
+		expectedDetached []string
+		expectedPorts    []openstackconfigv1.AttachedPort
+	}{
+		{
+			name:             "active server",
+			instanceID:       "server-1",
+			expectedAttached: []string{"port-new"},
+			expectedDetached: []string{"port-old"},
+			expectedPorts:    []openstackconfigv1.AttachedPort{{ID: "port-new", Name: "worker-0-storage", NetworkID: "net-2"}},
+		},
+		{
+			name:          "server not active",
+			expectedPorts: attachedPorts,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			th.SetupHTTP()
+			defer th.TeardownHTTP()
+			cloud := &fakePortsCloud{}
+			cloud.setup(t)
+
+			configClient, err := configclient.NewForConfig(&rest.Config{Host: th.Server.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			is := newFakeInstanceService()
+			got, err := is.ReconcilePorts("openshift-machine-api-ostest", "ostest", "worker-0", tc.instanceID, nil, config, configClient, attachedPorts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.expectedPorts) {
+				t.Errorf("expected attached ports %v, got %v", tc.expectedPorts, got)
+			}
+			if !reflect.DeepEqual(cloud.attached, tc.expectedAttached) {
+				t.Errorf("expected ports %v to be attached, got %v", tc.expectedAttached, cloud.attached)
+			}
+			if !reflect.DeepEqual(cloud.detached, tc.expectedDetached) || !reflect.DeepEqual(cloud.deleted, tc.expectedDetached) {
+				t.Errorf("expected ports %v to be detached and deleted, got %v and %v", tc.expectedDetached, cloud.detached, cloud.deleted)
+			}
+			if tc.instanceID == "" && len(cloud.created) != 0 {
+				t.Errorf("expected no port to be created for a server which is not active, got %v", cloud.created)
+			}
+		})
+	}
+}
+
+func TestUpdatedSecurityGroups(t *testing.T) {
+	testCases := []struct {
+		name            string
+		securityGroups  []string
+		add             []string
+		remove          []string
+		expected        []string
+		expectedChanged bool
+	}{
+		{
+			name:           "unchanged",
+			securityGroups: []string{"default", "global"},
+			add:            []string{"global"},
+			remove:         []string{"controlplane"},
+			expected:       []string{"default", "global"},
+		},
+		{
+			name:            "added",
+			securityGroups:  []string{"default"},
+			add:             []string{"global", "controlplane"},
+			expected:        []string{"default", "global", "controlplane"},
+			expectedChanged: true,
+		},
+		{
+			name:            "removed",
+			securityGroups:  []string{"default", "global", "controlplane"},
+			add:             []string{"global"},
+			remove:          []string{"controlplane"},
+			expected:        []string{"default", "global"},
+			expectedChanged: true,
+		},
+		{
+			name:            "all removed",
+			securityGroups:  []string{"global"},
+			remove:          []string{"global"},
+			expected:        []string{},
+			expectedChanged: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			groups, changed := updatedSecurityGroups(tc.securityGroups, tc.add, tc.remove)
+			if !reflect.DeepEqual(groups, tc.expected) || changed != tc.expectedChanged {
+				t.Errorf("expected %v (changed %v), got %v (changed %v)", tc.expected, tc.expectedChanged, groups, changed)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/qos/policies"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+// qosSupportNeeded returns true if a QoS policy is set on any network, subnet
+// or port of the provider spec.
+func qosSupportNeeded(config *openstackconfigv1.OpenstackProviderSpec) bool {
+	for _, net := range config.Networks {
+		if net.QoSPolicy != nil {
+			return true
+		}
+		for _, snet := range net.Subnets {
+			if snet.QoSPolicy != nil {
+				return true
+			}
+		}
+	}
+	for _, portOpts := range config.Ports {
+		if portOpts.QoSPolicy != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// GetQoSSupport returns true if Neutron has the QoS extension.
+func GetQoSSupport(is *InstanceService) (bool, error) {
+	return hasNetworkExtension(is, "qos")
+}
+
+// getQoSPolicyID returns the ID of the only QoS policy matching the parameter.
+func getQoSPolicyID(is *InstanceService, param openstackconfigv1.QoSPolicyParam) (string, error) {
+	listOpts := policies.ListOpts(param.Filter)
+	if param.UUID != "" {
+		listOpts.ID = param.UUID
+	}
+	if param.Name != "" {
+		listOpts.Name = param.Name
+	}
+	pages, err := policies.List(is.networkClient, listOpts).AllPages()
+	if err != nil {
+		return "", err
+	}
+	policyList, err := policies.ExtractPolicies(pages)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(policyList) {
+	case 0:
+		return "", fmt.Errorf("No QoS policy could be found with the filters provided")
+	case 1:
+		return policyList[0].ID, nil
+	}
+	return "", fmt.Errorf("Multiple QoS policies match the filters provided")
+}
+
+// qosPolicyResolver resolves the QoS policies of ports. The QoS support is
+// checked and each policy is looked up at most once.
+type qosPolicyResolver struct {
+	is      *InstanceService
+	checked bool
+	ids     map[openstackconfigv1.QoSPolicyParam]string
+}
+
+func newQoSPolicyResolver(is *InstanceService) *qosPolicyResolver {
+	return &qosPolicyResolver{is: is, ids: map[openstackconfigv1.QoSPolicyParam]string{}}
+}
+
+// policyIDFor returns the ID of the QoS policy to set on a port with the given
+// policy. Nothing is looked up when the policy is given by the ID of the
+// current one.
+func (r *qosPolicyResolver) policyIDFor(currentID string, param openstackconfigv1.QoSPolicyParam) (string, error) {
+	if param.UUID != "" && param.UUID == currentID {
+		return currentID, nil
+	}
+	if id, ok := r.ids[param]; ok {
+		return id, nil
+	}
+
+	if !r.checked {
+		qosSupport, err := GetQoSSupport(r.is)
+		if err != nil {
+			return "", err
+		}
+		if !qosSupport {
+			return "", fmt.Errorf("There is no QoS support. Please remove the QoS policies")
+		}
+		r.checked = true
+	}
+	id, err := getQoSPolicyID(r.is, param)
+	if err != nil {
+		return "", err
+	}
+	r.ids[param] = id
+	return id, nil
+}
@@ -0,0 +1,98 @@
+package clients
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestQoSSupportNeeded(t *testing.T) {
+	policy := &openstackconfigv1.QoSPolicyParam{Name: "storage"}
+
+	testCases := []struct {
+		name     string
+		config   openstackconfigv1.OpenstackProviderSpec
+		expected bool
+	}{
+		{
+			name: "no QoS policy",
+			config: openstackconfigv1.OpenstackProviderSpec{
+				Networks: []openstackconfigv1.NetworkParam{{UUID: "net", Subnets: []openstackconfigv1.SubnetParam{{UUID: "subnet"}}}},
+				Ports:    []openstackconfigv1.PortOpts{{NetworkID: "net"}},
+			},
+			expected: false,
+		},
+		{
+			name: "network",
+			config: openstackconfigv1.OpenstackProviderSpec{
+				Networks: []openstackconfigv1.NetworkParam{{UUID: "net", QoSPolicy: policy}},
+			},
+			expected: true,
+		},
+		{
+			name: "subnet",
+			config: openstackconfigv1.OpenstackProviderSpec{
+				Networks: []openstackconfigv1.NetworkParam{{UUID: "net", Subnets: []openstackconfigv1.SubnetParam{{UUID: "subnet", QoSPolicy: policy}}}},
+			},
+			expected: true,
+		},
+		{
+			name: "port",
+			config: openstackconfigv1.OpenstackProviderSpec{
+				Ports: []openstackconfigv1.PortOpts{{NetworkID: "net", QoSPolicy: policy}},
+			},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := qosSupportNeeded(&tc.config); got != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestQoSPolicyResolver(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	extensionLists, policyLists := 0, 0
+	th.Mux.HandleFunc("/v2.0/extensions", func(w http.ResponseWriter, r *http.Request) {
+		extensionLists++
+		w.Header().Add("Content-Type", "application/json")
+		fmt.Fprint(w, `{"extensions": [{"alias": "qos", "name": "Quality of Service"}]}`)
+	})
+	th.Mux.HandleFunc("/v2.0/qos/policies", func(w http.ResponseWriter, r *http.Request) {
+		policyLists++
+		w.Header().Add("Content-Type", "application/json")
+		fmt.Fprint(w, `{"policies": [{"id": "policy-1", "name": "storage"}]}`)
+	})
+
+	resolver := newQoSPolicyResolver(newFakeInstanceService())
+
+	// The ID of the current policy needs no lookup
+	id, err := resolver.policyIDFor("policy-1", openstackconfigv1.QoSPolicyParam{UUID: "policy-1"})
+	if err != nil || id != "policy-1" {
+		t.Fatalf("expected policy-1, got %q, %v", id, err)
+	}
+	if extensionLists != 0 || policyLists != 0 {
+		t.Errorf("expected no lookup for the current policy, got %d extension and %d policy lists", extensionLists, policyLists)
+	}
+
+	// Policies given by name are looked up once
+	for i := 0; i < 2; i++ {
+		id, err := resolver.policyIDFor("", openstackconfigv1.QoSPolicyParam{Name: "storage"})
+		if err != nil || id != "policy-1" {
+			t.Fatalf("expected policy-1, got %q, %v", id, err)
+		}
+	}
+	if extensionLists != 1 || policyLists != 1 {
+		t.Errorf("expected a single lookup, got %d extension and %d policy lists", extensionLists, policyLists)
+	}
+}
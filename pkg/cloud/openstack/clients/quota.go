@@ -0,0 +1,150 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+	"strings"
+
+	volumequotasets "github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/quotasets"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/bootfromvolume"
+	computequotasets "github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/quotasets"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	networkquotas "github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/quotas"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+// QuotaRequest holds the resources needed to create a machine.
+type QuotaRequest struct {
+	Instances int
+	Cores     int
+	RAM       int
+	Ports     int
+	Volumes   int
+	Gigabytes int
+}
+
+// QuotaShortage describes a project quota which does not leave room for a QuotaRequest.
+type QuotaShortage struct {
+	Service   string
+	Resource  string
+	Requested int
+	InUse     int
+	Limit     int
+}
+
+func (s QuotaShortage) String() string {
+	return fmt.Sprintf("%s %s quota exceeded: requested %d, %d of %d in use", s.Service, s.Resource, s.Requested, s.InUse, s.Limit)
+}
+
+// QuotaShortagesMessage joins the descriptions of the given shortages.
+func QuotaShortagesMessage(shortages []QuotaShortage) string {
+	messages := make([]string, 0, len(shortages))
+	for _, s := range shortages {
+		messages = append(messages, s.String())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// NewQuotaRequest returns the resources needed to create one machine with the
+// given provider spec and flavor. Ports created from networks with subnet
+// filters are counted once per subnet entry, as the filters are only resolved
+// when the machine is created.
+func NewQuotaRequest(config *openstackconfigv1.OpenstackProviderSpec, flavor *flavors.Flavor) QuotaRequest {
+	request := QuotaRequest{
+		Instances: 1,
+		Cores:     flavor.VCPUs,
+		RAM:       flavor.RAM,
+		Ports:     len(config.Ports),
+	}
+	for _, net := range config.Networks {
+		if net.Subnets == nil {
+			request.Ports++
+		}
+		request.Ports += len(net.Subnets)
+	}
+	if config.RootVolume != nil && config.RootVolume.Size != 0 &&
+		bootfromvolume.SourceType(config.RootVolume.SourceType) == bootfromvolume.SourceImage {
+		request.Volumes = 1
+		request.Gigabytes = config.RootVolume.Size
+	}
+	return request
+}
+
+// quotaShortage returns the shortage of a resource, or nil if the request fits.
+// A negative limit means the resource is unlimited.
+func quotaShortage(service, resource string, requested, inUse, reserved, limit int) *QuotaShortage {
+	if requested == 0 || limit < 0 || inUse+reserved+requested <= limit {
+		return nil
+	}
+	return &QuotaShortage{
+		Service:   service,
+		Resource:  resource,
+		Requested: requested,
+		InUse:     inUse + reserved,
+		Limit:     limit,
+	}
+}
+
+func appendShortage(shortages []QuotaShortage, shortage *QuotaShortage) []QuotaShortage {
+	if shortage == nil {
+		return shortages
+	}
+	return append(shortages, *shortage)
+}
+
+// CheckQuota returns the Nova, Neutron and Cinder quotas of the current
+// project which do not leave room for the request.
+func (is *InstanceService) CheckQuota(request QuotaRequest) ([]QuotaShortage, error) {
+	project, err := tokens.Get(is.identityClient, is.provider.Token()).ExtractProject()
+	if err != nil {
+		return nil, fmt.Errorf("Get project of token err: %v", err)
+	}
+	if project == nil {
+		return nil, fmt.Errorf("token is not scoped to a project")
+	}
+
+	var shortages []QuotaShortage
+
+	compute, err := computequotasets.GetDetail(is.computeClient, project.ID).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("Get compute quota err: %v", err)
+	}
+	shortages = appendShortage(shortages, quotaShortage("compute", "instances", request.Instances, compute.Instances.InUse, compute.Instances.Reserved, compute.Instances.Limit))
+	shortages = appendShortage(shortages, quotaShortage("compute", "cores", request.Cores, compute.Cores.InUse, compute.Cores.Reserved, compute.Cores.Limit))
+	shortages = appendShortage(shortages, quotaShortage("compute", "ram", request.RAM, compute.RAM.InUse, compute.RAM.Reserved, compute.RAM.Limit))
+
+	if request.Ports > 0 {
+		network, err := networkquotas.GetDetail(is.networkClient, project.ID).Extract()
+		if err != nil {
+			return nil, fmt.Errorf("Get network quota err: %v", err)
+		}
+		shortages = appendShortage(shortages, quotaShortage("network", "ports", request.Ports, network.Port.Used, network.Port.Reserved, network.Port.Limit))
+	}
+
+	if request.Volumes > 0 {
+		volume, err := volumequotasets.GetUsage(is.volumeClient, project.ID).Extract()
+		if err != nil {
+			return nil, fmt.Errorf("Get volume quota err: %v", err)
+		}
+		shortages = appendShortage(shortages, quotaShortage("volume", "volumes", request.Volumes, volume.Volumes.InUse, volume.Volumes.Reserved, volume.Volumes.Limit))
+		shortages = appendShortage(shortages, quotaShortage("volume", "gigabytes", request.Gigabytes, volume.Gigabytes.InUse, volume.Gigabytes.Reserved, volume.Gigabytes.Limit))
+	}
+
+	return shortages, nil
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestNewQuotaRequest(t *testing.T) {
+	flavor := &flavors.Flavor{VCPUs: 4, RAM: 16384}
+
+	testCases := []struct {
+		name     string
+		config   openstackconfigv1.OpenstackProviderSpec
+		expected QuotaRequest
+	}{
+		{
+			name: "image boot",
+			config: openstackconfigv1.OpenstackProviderSpec{
+				Networks: []openstackconfigv1.NetworkParam{
+					{UUID: "net1"},
+					{UUID: "net2", Subnets: []openstackconfigv1.SubnetParam{{UUID: "subnet1"}, {UUID: "subnet2"}}},
+				},
+				Ports: []openstackconfigv1.PortOpts{{NetworkID: "net3"}},
+			},
+			expected: QuotaRequest{Instances: 1, Cores: 4, RAM: 16384, Ports: 4},
+		},
+		{
+			name: "boot from a new volume",
+			config: openstackconfigv1.OpenstackProviderSpec{
+				RootVolume: &openstackconfigv1.RootVolume{SourceType: "image", SourceUUID: "rhcos", Size: 25},
+			},
+			expected: QuotaRequest{Instances: 1, Cores: 4, RAM: 16384, Volumes: 1, Gigabytes: 25},
+		},
+		{
+			name: "boot from an existing volume",
+			config: openstackconfigv1.OpenstackProviderSpec{
+				RootVolume: &openstackconfigv1.RootVolume{SourceType: "volume", SourceUUID: "root", Size: 25},
+			},
+			expected: QuotaRequest{Instances: 1, Cores: 4, RAM: 16384},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := NewQuotaRequest(&tc.config, flavor); got != tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestQuotaShortage(t *testing.T) {
+	testCases := []struct {
+		name                              string
+		requested, inUse, reserved, limit int
+		expectShortage                    bool
+	}{
+		{name: "fits", requested: 4, inUse: 10, reserved: 2, limit: 16},
+		{name: "reserved counts as used", requested: 4, inUse: 10, reserved: 3, limit: 16, expectShortage: true},
+		{name: "unlimited", requested: 4, inUse: 100, limit: -1},
+		{name: "nothing requested", requested: 0, inUse: 20, limit: 10},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			shortage := quotaShortage("compute", "cores", tc.requested, tc.inUse, tc.reserved, tc.limit)
+			if (shortage != nil) != tc.expectShortage {
+				t.Fatalf("expected shortage %v, got %+v", tc.expectShortage, shortage)
+			}
+			if shortage != nil && shortage.InUse != tc.inUse+tc.reserved {
+				t.Errorf("expected %d in use, got %d", tc.inUse+tc.reserved, shortage.InUse)
+			}
+		})
+	}
+}
@@ -66,50 +66,65 @@ func NewSecGroupService(client *gophercloud.ServiceClient) (*SecGroupService, er
 	}, nil
 }
 
-// Reconcile the security groups.
+// Reconcile the security groups. Each group is recorded in the status as
+// soon as it exists, so that it is deleted with the cluster even if
+// reconciling its rules fails. Once ManagedSecurityGroups is unset, the groups
+// recorded in the status are deleted, which fails while ports still use them.
 func (s *SecGroupService) Reconcile(clusterName string, desired openstackconfigv1.OpenstackClusterProviderSpec, status *openstackconfigv1.OpenstackClusterProviderStatus) error {
 	klog.Infof("Reconciling security groups for cluster %s", clusterName)
 	if !desired.ManagedSecurityGroups {
-		klog.V(4).Infof("No need to reconcile security groups for cluster %s", clusterName)
-		return nil
+		if status.ControlPlaneSecurityGroup == nil && status.GlobalSecurityGroup == nil {
+			klog.V(4).Infof("No need to reconcile security groups for cluster %s", clusterName)
+			return nil
+		}
+		return s.DeleteClusterGroups(clusterName, status)
 	}
-	desiredSecGroups := map[string]openstackconfigv1.SecurityGroup{
-		"controlplane": s.generateControlPlaneGroup(clusterName),
-		"global":       s.generateGlobalGroup(clusterName),
+	var extraRules openstackconfigv1.ManagedSecurityGroupRules
+	if desired.ManagedSecurityGroupRules != nil {
+		extraRules = *desired.ManagedSecurityGroupRules
 	}
-	observedSecGroups := make(map[string]*openstackconfigv1.SecurityGroup)
 
-	for k, desiredSecGroup := range desiredSecGroups {
+	for _, group := range []struct {
+		desired  openstackconfigv1.SecurityGroup
+		observed **openstackconfigv1.SecurityGroup
+	}{
+		{s.generateControlPlaneGroup(clusterName, extraRules.ControlPlane), &status.ControlPlaneSecurityGroup},
+		{s.generateGlobalGroup(clusterName, extraRules.All), &status.GlobalSecurityGroup},
+	} {
+		desiredSecGroup := group.desired
 		klog.Infof("Reconciling security group %s", desiredSecGroup.Name)
 
-		var err error
-		observedSecGroups[k], err = s.getSecurityGroupByName(desiredSecGroup.Name)
-
+		observedSecGroup, err := s.getSecurityGroupByName(desiredSecGroup.Name)
 		if err != nil {
 			return err
 		}
 
-		if observedSecGroups[k].ID != "" {
-			if s.matchGroups(&desiredSecGroup, observedSecGroups[k]) {
+		if observedSecGroup.ID != "" {
+			*group.observed = observedSecGroup
+			if s.matchGroups(&desiredSecGroup, observedSecGroup) {
 				klog.V(6).Infof("Group %s matched, have nothing to do.", desiredSecGroup.Name)
 				continue
 			}
 
 			klog.V(6).Infof("Group %s didn't match, reconciling...", desiredSecGroup.Name)
-			observedSecGroups[k], err = s.reconcileGroup(&desiredSecGroup, observedSecGroups[k])
+			observedSecGroup, err = s.reconcileGroup(&desiredSecGroup, observedSecGroup)
 			if err != nil {
 				return err
 			}
+			*group.observed = observedSecGroup
 			continue
 		}
 
 		klog.V(6).Infof("Group %s doesn't exist, creating it.", desiredSecGroup.Name)
-		observedSecGroups[k], err = s.createSecGroup(desiredSecGroup)
+		observedSecGroup, err = s.createSecGroup(desiredSecGroup)
+		if observedSecGroup.ID != "" {
+			*group.observed = observedSecGroup
+		}
+		if err != nil {
+			return err
+		}
 	}
 
-	status.ControlPlaneSecurityGroup = observedSecGroups["controlplane"]
-	status.GlobalSecurityGroup = observedSecGroups["global"]
-
 	return nil
 }
 
@@ -124,6 +139,25 @@ func (s *SecGroupService) Delete(group *openstackconfigv1.SecurityGroup) error {
 	return nil
 }
 
+// DeleteClusterGroups deletes the managed security groups recorded in the
+// status of the cluster, and removes them from it.
+func (s *SecGroupService) DeleteClusterGroups(clusterName string, status *openstackconfigv1.OpenstackClusterProviderStatus) error {
+	klog.Infof("Deleting security groups for cluster %s", clusterName)
+	if status.ControlPlaneSecurityGroup != nil {
+		if err := s.Delete(status.ControlPlaneSecurityGroup); err != nil {
+			return fmt.Errorf("Error deleting security group %s: %v", status.ControlPlaneSecurityGroup.Name, err)
+		}
+		status.ControlPlaneSecurityGroup = nil
+	}
+	if status.GlobalSecurityGroup != nil {
+		if err := s.Delete(status.GlobalSecurityGroup); err != nil {
+			return fmt.Errorf("Error deleting security group %s: %v", status.GlobalSecurityGroup.Name, err)
+		}
+		status.GlobalSecurityGroup = nil
+	}
+	return nil
+}
+
 func (s *SecGroupService) exists(groupID string) (bool, error) {
 	opts := groups.ListOpts{
 		ID: groupID,
@@ -142,7 +176,7 @@ func (s *SecGroupService) exists(groupID string) (bool, error) {
 	return true, nil
 }
 
-func (s *SecGroupService) generateControlPlaneGroup(clusterName string) openstackconfigv1.SecurityGroup {
+func (s *SecGroupService) generateControlPlaneGroup(clusterName string, extraRules []openstackconfigv1.SecurityGroupRule) openstackconfigv1.SecurityGroup {
 	secGroupName := fmt.Sprintf("%s-cluster-%s-secgroup-%s", secGroupPrefix, clusterName, controlPlaneSuffix)
 
 	// Hardcoded rules for now, we might want to make this definable in the Spec but it's more
@@ -168,12 +202,12 @@ func (s *SecGroupService) generateControlPlaneGroup(clusterName string) openstac
 					RemoteIPPrefix: "0.0.0.0/0",
 				},
 			},
-			defaultRules...,
+			append(defaultRules, extraRules...)...,
 		),
 	}
 }
 
-func (s *SecGroupService) generateGlobalGroup(clusterName string) openstackconfigv1.SecurityGroup {
+func (s *SecGroupService) generateGlobalGroup(clusterName string, extraRules []openstackconfigv1.SecurityGroupRule) openstackconfigv1.SecurityGroup {
 	secGroupName := fmt.Sprintf("%s-cluster-%s-secgroup-%s", secGroupPrefix, clusterName, globalSuffix)
 
 	// As above, hardcoded rules.
@@ -206,66 +240,97 @@ func (s *SecGroupService) generateGlobalGroup(clusterName string) openstackconfi
 					RemoteGroupID: "self",
 				},
 			},
-			defaultRules...,
+			append(defaultRules, extraRules...)...,
 		),
 	}
 }
 
 // matchGroups will check if security groups match.
 func (s *SecGroupService) matchGroups(desired, observed *openstackconfigv1.SecurityGroup) bool {
-	// If they have differing amount of rules they obviously don't match.
-	if len(desired.Rules) != len(observed.Rules) {
-		return false
-	}
+	missing, extra := diffRules(desired.Rules, observed.Rules, observed.ID)
+	return len(missing) == 0 && len(extra) == 0
+}
 
-	// Rules aren't in any order, so we're doing this the hard way.
-	for _, desiredRule := range desired.Rules {
-		r := desiredRule
-		if r.RemoteGroupID == "self" {
-			r.RemoteGroupID = observed.ID
-		}
-		ruleMatched := false
-		for _, observedRule := range observed.Rules {
-			if observedRule.Equal(r) {
-				ruleMatched = true
-				break
+// reconcileGroup reconciles an already existing observed group by deleting the
+// rules which are not desired and creating the missing ones. The group is
+// returned with the rules reconciled so far even on error.
+func (s *SecGroupService) reconcileGroup(desired, observed *openstackconfigv1.SecurityGroup) (*openstackconfigv1.SecurityGroup, error) {
+	missing, extra := diffRules(desired.Rules, observed.Rules, observed.ID)
+
+	reconciledRules := make([]openstackconfigv1.SecurityGroupRule, 0, len(desired.Rules))
+	for i, rule := range observed.Rules {
+		if containsRule(extra, rule) {
+			klog.V(6).Infof("Deleting rule %s from group %s", rule.ID, observed.Name)
+			err := rules.Delete(s.client, rule.ID).ExtractErr()
+			if _, notFound := err.(gophercloud.ErrDefault404); err != nil && !notFound {
+				observed.Rules = append(reconciledRules, observed.Rules[i:]...)
+				return observed, err
 			}
+			continue
 		}
-
-		if !ruleMatched {
-			return false
+		reconciledRules = append(reconciledRules, rule)
+	}
+	for _, rule := range missing {
+		klog.V(6).Infof("Creating missing rule %+v in group %s", rule, observed.Name)
+		newRule, err := s.createRule(rule)
+		if err != nil {
+			observed.Rules = reconciledRules
+			return observed, err
 		}
+		reconciledRules = append(reconciledRules, newRule)
 	}
-	return true
+	observed.Rules = reconciledRules
+	return observed, nil
 }
 
-// reconcileGroup reconciles an already existing observed group by essentially emptying out all the rules and
-// recreating them.
-func (s *SecGroupService) reconcileGroup(desired, observed *openstackconfigv1.SecurityGroup) (*openstackconfigv1.SecurityGroup, error) {
-	klog.V(6).Infof("Deleting all rules for group %s", observed.Name)
-	for _, rule := range observed.Rules {
-		klog.V(6).Infof("Deleting rule %s from group %s", rule.ID, observed.Name)
-		err := rules.Delete(s.client, rule.ID).ExtractErr()
-		if err != nil {
-			return &openstackconfigv1.SecurityGroup{}, err
+// diffRules returns the desired rules missing from the observed group, ready
+// to be created in it, and the observed rules which are not desired.
+func diffRules(desired, observed []openstackconfigv1.SecurityGroupRule, groupID string) ([]openstackconfigv1.SecurityGroupRule, []openstackconfigv1.SecurityGroupRule) {
+	desiredRules := make([]openstackconfigv1.SecurityGroupRule, 0, len(desired))
+	for _, rule := range desired {
+		r := normalizeRule(rule)
+		r.SecurityGroupID = groupID
+		if r.RemoteGroupID == "self" {
+			r.RemoteGroupID = groupID
 		}
+		desiredRules = append(desiredRules, r)
 	}
-	recreatedRules := make([]openstackconfigv1.SecurityGroupRule, 0, len(desired.Rules))
-	klog.V(6).Infof("Recreating all rules for group %s", observed.Name)
-	for _, rule := range desired.Rules {
-		r := rule
-		r.SecurityGroupID = observed.ID
-		if r.RemoteGroupID == "self" {
-			r.RemoteGroupID = observed.ID
+
+	var missing, extra []openstackconfigv1.SecurityGroupRule
+	for _, rule := range desiredRules {
+		if !containsRule(observed, rule) && !containsRule(missing, rule) {
+			missing = append(missing, rule)
 		}
-		newRule, err := s.createRule(r)
-		if err != nil {
-			return &openstackconfigv1.SecurityGroup{}, err
+	}
+	for _, rule := range observed {
+		if !containsRule(desiredRules, rule) {
+			extra = append(extra, rule)
 		}
-		recreatedRules = append(recreatedRules, newRule)
 	}
-	observed.Rules = recreatedRules
-	return observed, nil
+	return missing, extra
+}
+
+// normalizeRule sets the defaults Neutron applies to a rule, so that desired
+// rules can be compared with observed ones.
+func normalizeRule(rule openstackconfigv1.SecurityGroupRule) openstackconfigv1.SecurityGroupRule {
+	if rule.EtherType == "" {
+		rule.EtherType = "IPv4"
+	}
+	// Neutron may store rules open to any address without prefix
+	if rule.RemoteIPPrefix == "0.0.0.0/0" || rule.RemoteIPPrefix == "::/0" {
+		rule.RemoteIPPrefix = ""
+	}
+	return rule
+}
+
+func containsRule(list []openstackconfigv1.SecurityGroupRule, rule openstackconfigv1.SecurityGroupRule) bool {
+	r := normalizeRule(rule)
+	for _, l := range list {
+		if normalizeRule(l).Equal(r) {
+			return true
+		}
+	}
+	return false
 }
 
 func (s *SecGroupService) createSecGroup(group openstackconfigv1.SecurityGroup) (*openstackconfigv1.SecurityGroup, error) {
@@ -279,24 +344,12 @@ func (s *SecGroupService) createSecGroup(group openstackconfigv1.SecurityGroup)
 		return &openstackconfigv1.SecurityGroup{}, err
 	}
 
+	// Neutron creates the group with the default egress rules, the other
+	// rules are reconciled. The group is returned even if they fail, so that
+	// it is recorded and deleted with the cluster.
 	newGroup := s.convertOSSecGroupToConfigSecGroup(*g)
-	rules := make([]openstackconfigv1.SecurityGroupRule, 0, len(group.Rules))
 	klog.V(6).Infof("Creating rules for group %s", group.Name)
-	for _, rule := range group.Rules {
-		r := rule
-		r.SecurityGroupID = newGroup.ID
-		if r.RemoteGroupID == "self" {
-			r.RemoteGroupID = newGroup.ID
-		}
-		newRule, err := s.createRule(r)
-		if err != nil {
-			return &openstackconfigv1.SecurityGroup{}, err
-		}
-		rules = append(rules, newRule)
-	}
-	newGroup.Rules = rules
-
-	return newGroup, nil
+	return s.reconcileGroup(&group, newGroup)
 }
 
 func (s *SecGroupService) getSecurityGroupByName(name string) (*openstackconfigv1.SecurityGroup, error) {
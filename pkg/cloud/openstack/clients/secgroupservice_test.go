@@ -0,0 +1,174 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestDiffRules(t *testing.T) {
+	ssh := openstackconfigv1.SecurityGroupRule{Direction: "ingress", EtherType: "IPv4", PortRangeMin: 22, PortRangeMax: 22, Protocol: "tcp", RemoteIPPrefix: "0.0.0.0/0"}
+	api := openstackconfigv1.SecurityGroupRule{Direction: "ingress", PortRangeMin: 6443, PortRangeMax: 6443, Protocol: "tcp"}
+	self := openstackconfigv1.SecurityGroupRule{Direction: "ingress", EtherType: "IPv4", RemoteGroupID: "self"}
+
+	testCases := []struct {
+		name            string
+		desired         []openstackconfigv1.SecurityGroupRule
+		observed        []openstackconfigv1.SecurityGroupRule
+		expectedMissing []openstackconfigv1.SecurityGroupRule
+		expectedExtra   []openstackconfigv1.SecurityGroupRule
+	}{
+		{
+			name:    "new group",
+			desired: []openstackconfigv1.SecurityGroupRule{ssh, self},
+			expectedMissing: []openstackconfigv1.SecurityGroupRule{
+				{Direction: "ingress", EtherType: "IPv4", PortRangeMin: 22, PortRangeMax: 22, Protocol: "tcp", SecurityGroupID: "sg"},
+				{Direction: "ingress", EtherType: "IPv4", RemoteGroupID: "sg", SecurityGroupID: "sg"},
+			},
+		},
+		{
+			name:    "matching group",
+			desired: []openstackconfigv1.SecurityGroupRule{ssh, api, self},
+			observed: []openstackconfigv1.SecurityGroupRule{
+				{ID: "r1", Direction: "ingress", EtherType: "IPv4", PortRangeMin: 22, PortRangeMax: 22, Protocol: "tcp", SecurityGroupID: "sg"},
+				{ID: "r2", Direction: "ingress", EtherType: "IPv4", PortRangeMin: 6443, PortRangeMax: 6443, Protocol: "tcp", SecurityGroupID: "sg"},
+				{ID: "r3", Direction: "ingress", EtherType: "IPv4", RemoteGroupID: "sg", SecurityGroupID: "sg"},
+			},
+		},
+		{
+			name:    "drifted group",
+			desired: []openstackconfigv1.SecurityGroupRule{ssh, api},
+			observed: []openstackconfigv1.SecurityGroupRule{
+				{ID: "r1", Direction: "ingress", EtherType: "IPv4", PortRangeMin: 22, PortRangeMax: 22, Protocol: "tcp", SecurityGroupID: "sg"},
+				{ID: "r4", Direction: "ingress", EtherType: "IPv4", PortRangeMin: 80, PortRangeMax: 80, Protocol: "tcp", SecurityGroupID: "sg"},
+			},
+			expectedMissing: []openstackconfigv1.SecurityGroupRule{
+				{Direction: "ingress", EtherType: "IPv4", PortRangeMin: 6443, PortRangeMax: 6443, Protocol: "tcp", SecurityGroupID: "sg"},
+			},
+			expectedExtra: []openstackconfigv1.SecurityGroupRule{
+				{ID: "r4", Direction: "ingress", EtherType: "IPv4", PortRangeMin: 80, PortRangeMax: 80, Protocol: "tcp", SecurityGroupID: "sg"},
+			},
+		},
+		{
+			name:    "duplicate desired rules",
+			desired: []openstackconfigv1.SecurityGroupRule{api, api},
+			expectedMissing: []openstackconfigv1.SecurityGroupRule{
+				{Direction: "ingress", EtherType: "IPv4", PortRangeMin: 6443, PortRangeMax: 6443, Protocol: "tcp", SecurityGroupID: "sg"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			missing, extra := diffRules(tc.desired, tc.observed, "sg")
+			if !reflect.DeepEqual(missing, tc.expectedMissing) {
+				t.Errorf("Expected missing rules %+v, got %+v", tc.expectedMissing, missing)
+			}
+			if !reflect.DeepEqual(extra, tc.expectedExtra) {
+				t.Errorf("Expected extra rules %+v, got %+v", tc.expectedExtra, extra)
+			}
+		})
+	}
+}
+
+func newFakeSecGroupService(t *testing.T) *SecGroupService {
+	networkClient := fakeclient.ServiceClient()
+	networkClient.ResourceBase = networkClient.Endpoint + "v2.0/"
+	s, err := NewSecGroupService(networkClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSecGroupServiceReconcileRecordsCreatedGroups(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/v2.0/security-groups", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		switch r.Method {
+		case "GET":
+			fmt.Fprint(w, `{"security_groups": []}`)
+		case "POST":
+			var body struct {
+				SecurityGroup struct {
+					Name string `json:"name"`
+				} `json:"security_group"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"security_group": {"id": "sg-controlplane", "name": %q}}`, body.SecurityGroup.Name)
+		}
+	})
+	th.Mux.HandleFunc("/v2.0/security-group-rules", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "POST")
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	status := &openstackconfigv1.OpenstackClusterProviderStatus{}
+	err := newFakeSecGroupService(t).Reconcile("ocp", openstackconfigv1.OpenstackClusterProviderSpec{ManagedSecurityGroups: true}, status)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if status.ControlPlaneSecurityGroup == nil || status.ControlPlaneSecurityGroup.ID != "sg-controlplane" {
+		t.Errorf("expected the created group to be recorded, got %+v", status.ControlPlaneSecurityGroup)
+	}
+}
+
+func TestSecGroupServiceReconcileDeletesUnmanagedGroups(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/v2.0/security-groups", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "GET")
+		w.Header().Add("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"security_groups": [{"id": %q}]}`, r.URL.Query().Get("id"))
+	})
+	th.Mux.HandleFunc("/v2.0/security-groups/sg-controlplane", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "DELETE")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	th.Mux.HandleFunc("/v2.0/security-groups/sg-all", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "DELETE")
+		// The group is still used by ports
+		w.WriteHeader(http.StatusConflict)
+	})
+
+	status := &openstackconfigv1.OpenstackClusterProviderStatus{
+		ControlPlaneSecurityGroup: &openstackconfigv1.SecurityGroup{ID: "sg-controlplane"},
+		GlobalSecurityGroup:       &openstackconfigv1.SecurityGroup{ID: "sg-all"},
+	}
+	err := newFakeSecGroupService(t).Reconcile("ocp", openstackconfigv1.OpenstackClusterProviderSpec{}, status)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if status.ControlPlaneSecurityGroup != nil {
+		t.Errorf("expected the deleted group to be removed from the status, got %+v", status.ControlPlaneSecurityGroup)
+	}
+	if status.GlobalSecurityGroup == nil {
+		t.Errorf("expected the group in use to be kept in the status")
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/portsbinding"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/portsecurity"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+const (
+	VNICTypeNormal          = "normal"
+	VNICTypeDirect          = "direct"
+	VNICTypeDirectPhysical  = "direct-physical"
+	VNICTypeMacvtap         = "macvtap"
+	VNICTypeVirtioForwarder = "virtio-forwarder"
+	VNICTypeVDPA            = "vdpa"
+	VNICTypeBaremetal       = "baremetal"
+	VNICTypeSmartNIC        = "smart-nic"
+
+	// VIFTypeBindingFailed is the VIF type of ports Neutron could not bind
+	// on the host of their server.
+	VIFTypeBindingFailed = "binding_failed"
+)
+
+// validVNICTypes are the vNIC types of Neutron ports.
+var validVNICTypes = []string{
+	VNICTypeNormal,
+	VNICTypeDirect,
+	VNICTypeDirectPhysical,
+	VNICTypeMacvtap,
+	VNICTypeVirtioForwarder,
+	VNICTypeVDPA,
+	VNICTypeBaremetal,
+	VNICTypeSmartNIC,
+}
+
+// sriovVNICTypes are the vNIC types of ports passed through to the server by
+// SR-IOV.
+var sriovVNICTypes = []string{
+	VNICTypeDirect,
+	VNICTypeDirectPhysical,
+	VNICTypeMacvtap,
+}
+
+// ValidateVNICTypes checks the vNIC types of the networks and ports of the
+// provider spec.
+func ValidateVNICTypes(config *openstackconfigv1.OpenstackProviderSpec) error {
+	for _, net := range config.Networks {
+		if err := validateVNICType(net.VNICType); err != nil {
+			return err
+		}
+	}
+	for _, portOpts := range config.Ports {
+		if err := validateVNICType(portOpts.VNICType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateVNICType(vnicType string) error {
+	if vnicType == "" || isDuplicate(validVNICTypes, vnicType) {
+		return nil
+	}
+	return fmt.Errorf("invalid vnicType %q, it must be one of %s", vnicType, strings.Join(validVNICTypes, ", "))
+}
+
+// isSRIOVPort returns true if the port is passed through to the server by
+// SR-IOV without OVS hardware offload, so that its traffic bypasses security
+// groups.
+func isSRIOVPort(vnicType string, profile map[string]string) bool {
+	return isDuplicate(sriovVNICTypes, vnicType) && !strings.Contains(profile["capabilities"], "switchdev")
+}
+
+// defaultPortSecurity disables port security on SR-IOV ports unless it is
+// explicitly enabled, as their security groups would not be enforced.
+func defaultPortSecurity(portOpts *openstackconfigv1.PortOpts) {
+	if portOpts.PortSecurity == nil && isSRIOVPort(portOpts.VNICType, portOpts.Profile) {
+		portSecurity := false
+		portOpts.PortSecurity = &portSecurity
+	}
+}
+
+// InstancePort is a port of a server along with its binding, port security
+// and DNS assignment.
+type InstancePort struct {
+	ports.Port
+	portsbinding.PortsBindingExt
+	portsecurity.PortSecurityExt
+	PortDNSExt
+}
+
+// IsSRIOV returns true if the port is passed through to the server by SR-IOV.
+func (p *InstancePort) IsSRIOV() bool {
+	profile := map[string]string{}
+	for k, v := range p.Profile {
+		profile[k] = fmt.Sprint(v)
+	}
+	return isSRIOVPort(p.VNICType, profile)
+}
+
+// GetInstancePorts returns the ports of the server.
+func (is *InstanceService) GetInstancePorts(instanceID string) ([]InstancePort, error) {
+	pages, err := ports.List(is.networkClient, ports.ListOpts{DeviceID: instanceID}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	var portList []InstancePort
+	if err := ports.ExtractPortsInto(pages, &portList); err != nil {
+		return nil, err
+	}
+	return portList, nil
+}
@@ -0,0 +1,92 @@
+package clients
+
+import (
+	"testing"
+
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestValidateVNICTypes(t *testing.T) {
+	testCases := []struct {
+		name      string
+		config    openstackconfigv1.OpenstackProviderSpec
+		expectErr bool
+	}{
+		{
+			name:   "unset",
+			config: openstackconfigv1.OpenstackProviderSpec{Networks: []openstackconfigv1.NetworkParam{{UUID: "net"}}, Ports: []openstackconfigv1.PortOpts{{NetworkID: "net"}}},
+		},
+		{
+			name:   "valid",
+			config: openstackconfigv1.OpenstackProviderSpec{Networks: []openstackconfigv1.NetworkParam{{UUID: "net", VNICType: VNICTypeDirect}}, Ports: []openstackconfigv1.PortOpts{{NetworkID: "net", VNICType: VNICTypeDirectPhysical}}},
+		},
+		{
+			name:      "invalid network",
+			config:    openstackconfigv1.OpenstackProviderSpec{Networks: []openstackconfigv1.NetworkParam{{UUID: "net", VNICType: "sriov"}}},
+			expectErr: true,
+		},
+		{
+			name:      "invalid port",
+			config:    openstackconfigv1.OpenstackProviderSpec{Ports: []openstackconfigv1.PortOpts{{NetworkID: "net", VNICType: "Direct"}}},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateVNICTypes(&tc.config)
+			if tc.expectErr && err == nil {
+				t.Errorf("expected an error")
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDefaultPortSecurity(t *testing.T) {
+	enabled := true
+
+	testCases := []struct {
+		name     string
+		portOpts openstackconfigv1.PortOpts
+		expected *bool
+	}{
+		{
+			name:     "normal",
+			portOpts: openstackconfigv1.PortOpts{VNICType: VNICTypeNormal},
+			expected: nil,
+		},
+		{
+			name:     "direct",
+			portOpts: openstackconfigv1.PortOpts{VNICType: VNICTypeDirect},
+			expected: new(bool),
+		},
+		{
+			name:     "direct with port security enabled",
+			portOpts: openstackconfigv1.PortOpts{VNICType: VNICTypeDirect, PortSecurity: &enabled},
+			expected: &enabled,
+		},
+		{
+			name:     "direct with hardware offload",
+			portOpts: openstackconfigv1.PortOpts{VNICType: VNICTypeDirect, Profile: map[string]string{"capabilities": `["switchdev"]`}},
+			expected: nil,
+		},
+		{
+			name:     "direct-physical",
+			portOpts: openstackconfigv1.PortOpts{VNICType: VNICTypeDirectPhysical},
+			expected: new(bool),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defaultPortSecurity(&tc.portOpts)
+			if (tc.portOpts.PortSecurity == nil) != (tc.expected == nil) ||
+				(tc.expected != nil && *tc.portOpts.PortSecurity != *tc.expected) {
+				t.Errorf("expected port security %v, got %v", tc.expected, tc.portOpts.PortSecurity)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/trunks"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"k8s.io/klog/v2"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+const (
+	SegmentationTypeVLAN    = "vlan"
+	SegmentationTypeInherit = "inherit"
+
+	// SubportTag is set on the ports created for subports, which are deleted
+	// along with the trunk. Other subports, such as those added by Kuryr or
+	// given by ID, are left alone.
+	SubportTag = "cluster-api-provider-openstack-subport"
+
+	minSegmentationID = 1
+	maxSegmentationID = 4094
+)
+
+// ValidateSubports checks the subports of the ports of the provider spec.
+func ValidateSubports(config *openstackconfigv1.OpenstackProviderSpec) error {
+	for _, portOpts := range config.Ports {
+		if len(portOpts.Subports) == 0 {
+			continue
+		}
+		trunk := config.Trunk
+		if portOpts.Trunk != nil {
+			trunk = *portOpts.Trunk
+		}
+		if !trunk {
+			return fmt.Errorf("port %q has subports but trunk is not enabled", portOpts.NameSuffix)
+		}
+
+		segmentationIDs := map[int]bool{}
+		for _, subport := range portOpts.Subports {
+			if (subport.PortID == "") == (subport.NetworkID == "") {
+				return fmt.Errorf("exactly one of portID and networkID must be set on the subports of port %q", portOpts.NameSuffix)
+			}
+			switch subport.SegmentationType {
+			case "", SegmentationTypeVLAN:
+				if subport.SegmentationID == 0 {
+					continue
+				}
+				if subport.SegmentationID < minSegmentationID || subport.SegmentationID > maxSegmentationID {
+					return fmt.Errorf("invalid segmentationID %d on the subports of port %q, it must be between %d and %d", subport.SegmentationID, portOpts.NameSuffix, minSegmentationID, maxSegmentationID)
+				}
+				if segmentationIDs[subport.SegmentationID] {
+					return fmt.Errorf("segmentationID %d is used by several subports of port %q", subport.SegmentationID, portOpts.NameSuffix)
+				}
+				segmentationIDs[subport.SegmentationID] = true
+			case SegmentationTypeInherit:
+				if subport.SegmentationID != 0 {
+					return fmt.Errorf("segmentationID cannot be set on subports of port %q with the %s segmentation type", portOpts.NameSuffix, SegmentationTypeInherit)
+				}
+			default:
+				return fmt.Errorf("invalid segmentationType %q on the subports of port %q, it must be %s or %s", subport.SegmentationType, portOpts.NameSuffix, SegmentationTypeVLAN, SegmentationTypeInherit)
+			}
+		}
+	}
+	return nil
+}
+
+// subport is a subport added to a trunk. Unlike trunks.Subport, the
+// segmentation ID is omitted for the inherit segmentation type.
+type subport struct {
+	PortID           string `json:"port_id"`
+	SegmentationType string `json:"segmentation_type"`
+	SegmentationID   int    `json:"segmentation_id,omitempty"`
+}
+
+type addSubportsOpts struct {
+	Subports []subport `json:"sub_ports"`
+}
+
+func (opts addSubportsOpts) ToTrunkAddSubportsMap() (map[string]interface{}, error) {
+	return gophercloud.BuildRequestBody(opts, "")
+}
+
+// getTrunk returns the trunk of the port, or nil if the port has no trunk.
+func getTrunk(is *InstanceService, portID string) (*trunks.Trunk, error) {
+	allPages, err := trunks.List(is.networkClient, trunks.ListOpts{PortID: portID}).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("Searching for trunk of port %q err: %v", portID, err)
+	}
+	trunkList, err := trunks.ExtractTrunks(allPages)
+	if err != nil {
+		return nil, fmt.Errorf("Searching for trunk of port %q err: %v", portID, err)
+	}
+	if len(trunkList) == 0 {
+		return nil, nil
+	}
+	return &trunkList[0], nil
+}
+
+// addSubports reconciles the subports of the trunk with the port options. The
+// missing subports are added, creating their ports with the security groups
+// if needed, and the subports which were created for the port options but are
+// not in them anymore are removed along with their ports. It returns the IDs
+// of the ports of the subports.
+func (is *InstanceService) addSubports(name string, trunk *trunks.Trunk, portOpts openstackconfigv1.PortOpts, machineTags []string, securityGroups []string) ([]string, error) {
+	if portOpts.SecurityGroups != nil {
+		securityGroups = *portOpts.SecurityGroups
+	}
+
+	var createdPortIDs []string
+	portIDs := make([]string, len(portOpts.Subports))
+	for i, subportOpts := range portOpts.Subports {
+		if subportOpts.PortID != "" {
+			portIDs[i] = subportOpts.PortID
+			continue
+		}
+
+		nameSuffix := subportOpts.NameSuffix
+		if nameSuffix == "" {
+			nameSuffix = portOpts.NameSuffix + "-" + subportOpts.NetworkID
+		}
+		subportPortOpts := openstackconfigv1.PortOpts{
+			NetworkID:  subportOpts.NetworkID,
+			NameSuffix: nameSuffix,
+			FixedIPs:   subportOpts.FixedIPs,
+		}
+		if len(securityGroups) > 0 {
+			subportPortOpts.SecurityGroups = &securityGroups
+		}
+		port, err := getOrCreatePort(is, name, subportPortOpts)
+		if err != nil {
+			return createdPortIDs, fmt.Errorf("Failed to create subport port err: %v", err)
+		}
+		portIDs[i] = port.ID
+		createdPortIDs = append(createdPortIDs, port.ID)
+
+		portTags := append(append(append([]string{}, machineTags...), subportOpts.Tags...), SubportTag)
+		missingTags := missingStrings(port.Tags, portTags)
+		if len(missingTags) == 0 {
+			continue
+		}
+		_, err = attributestags.ReplaceAll(is.networkClient, "ports", port.ID, attributestags.ReplaceAllOpts{
+			Tags: append(port.Tags, missingTags...)}).Extract()
+		if err != nil {
+			return createdPortIDs, fmt.Errorf("Tagging subport port %q err: %v", port.ID, err)
+		}
+	}
+
+	if err := is.removeSubports(name, trunk, portIDs); err != nil {
+		return createdPortIDs, err
+	}
+
+	newSubports, err := missingSubports(trunk.Subports, portOpts.Subports, portIDs)
+	if err != nil {
+		return createdPortIDs, fmt.Errorf("Adding subports to trunk %q err: %v", trunk.ID, err)
+	}
+	if len(newSubports) == 0 {
+		return createdPortIDs, nil
+	}
+
+	klog.Infof("Adding %d subports to trunk %s of server %s", len(newSubports), trunk.ID, name)
+	_, err = trunks.AddSubports(is.networkClient, trunk.ID, addSubportsOpts{Subports: newSubports}).Extract()
+	if err != nil {
+		return createdPortIDs, fmt.Errorf("Adding subports to trunk %q err: %v", trunk.ID, err)
+	}
+	return createdPortIDs, nil
+}
+
+// removeSubports removes the subports of the trunk whose ports were created
+// for subports, but are not among the given port IDs, and deletes their
+// ports. Subports added by others, such as Kuryr, are left alone.
+func (is *InstanceService) removeSubports(name string, trunk *trunks.Trunk, portIDs []string) error {
+	var candidates []string
+	for _, s := range trunk.Subports {
+		if !isDuplicate(portIDs, s.PortID) {
+			candidates = append(candidates, s.PortID)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	ownedPorts, err := listPorts(is, ports.ListOpts{DeviceID: trunk.ID, Tags: SubportTag})
+	if err != nil {
+		return fmt.Errorf("Searching for the subport ports of trunk %q err: %v", trunk.ID, err)
+	}
+	var ownedPortIDs []string
+	for _, port := range ownedPorts {
+		ownedPortIDs = append(ownedPortIDs, port.ID)
+	}
+	removed := removedSubports(trunk.Subports, ownedPortIDs, portIDs)
+	if len(removed) == 0 {
+		return nil
+	}
+
+	klog.Infof("Removing %d subports from trunk %s of server %s", len(removed), trunk.ID, name)
+	removeOpts := trunks.RemoveSubportsOpts{}
+	for _, portID := range removed {
+		removeOpts.Subports = append(removeOpts.Subports, trunks.RemoveSubport{PortID: portID})
+	}
+	if _, err := trunks.RemoveSubports(is.networkClient, trunk.ID, removeOpts).Extract(); err != nil {
+		return fmt.Errorf("Removing subports from trunk %q err: %v", trunk.ID, err)
+	}
+	for _, portID := range removed {
+		err := ports.Delete(is.networkClient, portID).ExtractErr()
+		if _, notFound := err.(gophercloud.ErrDefault404); err != nil && !notFound {
+			return fmt.Errorf("Error deleting the subport port %v: %v", portID, err)
+		}
+	}
+	return nil
+}
+
+// removedSubports returns the ports of the subports of the trunk which were
+// created for subports, given the IDs of the ports tagged as such, but are not
+// among the given port IDs.
+func removedSubports(trunkSubports []trunks.Subport, ownedPortIDs []string, portIDs []string) []string {
+	var removed []string
+	for _, s := range trunkSubports {
+		if isDuplicate(ownedPortIDs, s.PortID) && !isDuplicate(portIDs, s.PortID) {
+			removed = append(removed, s.PortID)
+		}
+	}
+	return removed
+}
+
+// missingSubports returns the subports missing from the trunk, given the
+// subport options and the IDs of their ports. VLAN IDs are assigned to the
+// subports without one, skipping those used on the trunk or in the options.
+func missingSubports(trunkSubports []trunks.Subport, subportOpts []openstackconfigv1.SubportOpts, portIDs []string) ([]subport, error) {
+	usedIDs := map[int]bool{}
+	trunkPorts := map[string]bool{}
+	for _, s := range trunkSubports {
+		trunkPorts[s.PortID] = true
+		if s.SegmentationType == SegmentationTypeVLAN {
+			usedIDs[s.SegmentationID] = true
+		}
+	}
+	for _, opts := range subportOpts {
+		if opts.SegmentationID != 0 {
+			usedIDs[opts.SegmentationID] = true
+		}
+	}
+
+	var missing []subport
+	nextID := minSegmentationID
+	for i, opts := range subportOpts {
+		if trunkPorts[portIDs[i]] {
+			continue
+		}
+		s := subport{
+			PortID:           portIDs[i],
+			SegmentationType: opts.SegmentationType,
+			SegmentationID:   opts.SegmentationID,
+		}
+		if s.SegmentationType == "" {
+			s.SegmentationType = SegmentationTypeVLAN
+		}
+		if s.SegmentationType == SegmentationTypeVLAN && s.SegmentationID == 0 {
+			for nextID <= maxSegmentationID && usedIDs[nextID] {
+				nextID++
+			}
+			if nextID > maxSegmentationID {
+				return nil, fmt.Errorf("no VLAN ID left for port %q", s.PortID)
+			}
+			s.SegmentationID = nextID
+			usedIDs[nextID] = true
+		}
+		missing = append(missing, s)
+	}
+	return missing, nil
+}
+
+// deleteSubportPorts deletes the ports of the subports which were created for
+// them. The trunk must be deleted first.
+func (is *InstanceService) deleteSubportPorts(subports []trunks.Subport) error {
+	for _, s := range subports {
+		port, err := ports.Get(is.networkClient, s.PortID).Extract()
+		if _, notFound := err.(gophercloud.ErrDefault404); notFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !isDuplicate(port.Tags, SubportTag) {
+			continue
+		}
+		err = ports.Delete(is.networkClient, port.ID).ExtractErr()
+		if _, notFound := err.(gophercloud.ErrDefault404); err != nil && !notFound {
+			return fmt.Errorf("Error deleting the subport port %v: %v", port.ID, err)
+		}
+	}
+	return nil
+}
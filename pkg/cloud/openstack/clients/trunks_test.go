@@ -0,0 +1,246 @@
+package clients
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/trunks"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestValidateSubports(t *testing.T) {
+	enabled := true
+	disabled := false
+
+	testCases := []struct {
+		name      string
+		config    openstackconfigv1.OpenstackProviderSpec
+		expectErr bool
+	}{
+		{
+			name: "valid",
+			config: openstackconfigv1.OpenstackProviderSpec{Ports: []openstackconfigv1.PortOpts{{NameSuffix: "trunk", Trunk: &enabled, Subports: []openstackconfigv1.SubportOpts{
+				{NetworkID: "net1", SegmentationID: 100},
+				{NetworkID: "net2"},
+				{PortID: "port", SegmentationType: SegmentationTypeInherit},
+			}}}},
+		},
+		{
+			name: "trunk inherited from the provider spec",
+			config: openstackconfigv1.OpenstackProviderSpec{Trunk: true, Ports: []openstackconfigv1.PortOpts{{NameSuffix: "trunk", Subports: []openstackconfigv1.SubportOpts{
+				{NetworkID: "net1"},
+			}}}},
+		},
+		{
+			name: "trunk disabled",
+			config: openstackconfigv1.OpenstackProviderSpec{Trunk: true, Ports: []openstackconfigv1.PortOpts{{NameSuffix: "trunk", Trunk: &disabled, Subports: []openstackconfigv1.SubportOpts{
+				{NetworkID: "net1"},
+			}}}},
+			expectErr: true,
+		},
+		{
+			name: "port and network",
+			config: openstackconfigv1.OpenstackProviderSpec{Trunk: true, Ports: []openstackconfigv1.PortOpts{{NameSuffix: "trunk", Subports: []openstackconfigv1.SubportOpts{
+				{NetworkID: "net1", PortID: "port"},
+			}}}},
+			expectErr: true,
+		},
+		{
+			name: "neither port nor network",
+			config: openstackconfigv1.OpenstackProviderSpec{Trunk: true, Ports: []openstackconfigv1.PortOpts{{NameSuffix: "trunk", Subports: []openstackconfigv1.SubportOpts{
+				{SegmentationID: 100},
+			}}}},
+			expectErr: true,
+		},
+		{
+			name: "invalid segmentation type",
+			config: openstackconfigv1.OpenstackProviderSpec{Trunk: true, Ports: []openstackconfigv1.PortOpts{{NameSuffix: "trunk", Subports: []openstackconfigv1.SubportOpts{
+				{NetworkID: "net1", SegmentationType: "vxlan"},
+			}}}},
+			expectErr: true,
+		},
+		{
+			name: "VLAN ID out of range",
+			config: openstackconfigv1.OpenstackProviderSpec{Trunk: true, Ports: []openstackconfigv1.PortOpts{{NameSuffix: "trunk", Subports: []openstackconfigv1.SubportOpts{
+				{NetworkID: "net1", SegmentationID: 4095},
+			}}}},
+			expectErr: true,
+		},
+		{
+			name: "duplicate VLAN ID",
+			config: openstackconfigv1.OpenstackProviderSpec{Trunk: true, Ports: []openstackconfigv1.PortOpts{{NameSuffix: "trunk", Subports: []openstackconfigv1.SubportOpts{
+				{NetworkID: "net1", SegmentationID: 100},
+				{NetworkID: "net2", SegmentationID: 100},
+			}}}},
+			expectErr: true,
+		},
+		{
+			name: "segmentation ID with inherit",
+			config: openstackconfigv1.OpenstackProviderSpec{Trunk: true, Ports: []openstackconfigv1.PortOpts{{NameSuffix: "trunk", Subports: []openstackconfigv1.SubportOpts{
+				{NetworkID: "net1", SegmentationType: SegmentationTypeInherit, SegmentationID: 100},
+			}}}},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateSubports(&tc.config)
+			if tc.expectErr && err == nil {
+				t.Errorf("expected an error")
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestMissingSubports(t *testing.T) {
+	trunkSubports := []trunks.Subport{
+		{PortID: "existing", SegmentationType: SegmentationTypeVLAN, SegmentationID: 1},
+		{PortID: "kuryr", SegmentationType: SegmentationTypeVLAN, SegmentationID: 3},
+	}
+	subportOpts := []openstackconfigv1.SubportOpts{
+		{NetworkID: "net1"},
+		{NetworkID: "net2"},
+		{NetworkID: "net3", SegmentationID: 2},
+		{NetworkID: "net4"},
+		{PortID: "inherit", SegmentationType: SegmentationTypeInherit},
+	}
+	portIDs := []string{"existing", "port2", "port3", "port4", "inherit"}
+
+	expected := []subport{
+		{PortID: "port2", SegmentationType: SegmentationTypeVLAN, SegmentationID: 4},
+		{PortID: "port3", SegmentationType: SegmentationTypeVLAN, SegmentationID: 2},
+		{PortID: "port4", SegmentationType: SegmentationTypeVLAN, SegmentationID: 5},
+		{PortID: "inherit", SegmentationType: SegmentationTypeInherit},
+	}
+
+	missing, err := missingSubports(trunkSubports, subportOpts, portIDs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(missing, expected) {
+		t.Errorf("expected %v, got %v", expected, missing)
+	}
+}
+
+func TestRemovedSubports(t *testing.T) {
+	trunkSubports := []trunks.Subport{
+		{PortID: "kept", SegmentationType: SegmentationTypeVLAN, SegmentationID: 1},
+		{PortID: "stale", SegmentationType: SegmentationTypeVLAN, SegmentationID: 2},
+		{PortID: "kuryr", SegmentationType: SegmentationTypeVLAN, SegmentationID: 3},
+	}
+
+	removed := removedSubports(trunkSubports, []string{"kept", "stale"}, []string{"kept"})
+	if expected := []string{"stale"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("expected %v, got %v", expected, removed)
+	}
+}
+
+func TestAddSubports(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	var createdSecurityGroups []string
+	var tagged, removed, added, deleted []string
+	th.Mux.HandleFunc("/v2.0/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		switch {
+		case r.Method == "POST":
+			var body struct {
+				Port struct {
+					SecurityGroups []string `json:"security_groups"`
+				} `json:"port"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			createdSecurityGroups = body.Port.SecurityGroups
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"port": {"id": "port-new", "name": "worker-0-new", "network_id": "net-2"}}`)
+		case r.URL.Query().Get("name") == "worker-0-kept":
+			fmt.Fprintf(w, `{"ports": [{"id": "port-kept", "name": "worker-0-kept", "network_id": "net-1", "tags": ["machine", "%s"]}]}`, SubportTag)
+		case r.URL.Query().Get("device_id") == "trunk-1":
+			fmt.Fprint(w, `{"ports": [{"id": "port-kept"}, {"id": "port-stale"}]}`)
+		default:
+			fmt.Fprint(w, `{"ports": []}`)
+		}
+	})
+	th.Mux.HandleFunc("/v2.0/ports/port-new/tags", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "PUT")
+		tagged = append(tagged, "port-new")
+		w.Header().Add("Content-Type", "application/json")
+		fmt.Fprint(w, `{"tags": []}`)
+	})
+	th.Mux.HandleFunc("/v2.0/ports/port-kept/tags", func(w http.ResponseWriter, r *http.Request) {
+		tagged = append(tagged, "port-kept")
+		w.Header().Add("Content-Type", "application/json")
+		fmt.Fprint(w, `{"tags": []}`)
+	})
+	th.Mux.HandleFunc("/v2.0/ports/port-stale", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "DELETE")
+		deleted = append(deleted, "port-stale")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	th.Mux.HandleFunc("/v2.0/trunks/trunk-1/remove_subports", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "PUT")
+		var body trunks.RemoveSubportsOpts
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range body.Subports {
+			removed = append(removed, s.PortID)
+		}
+		w.Header().Add("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id": "trunk-1"}`)
+	})
+	th.Mux.HandleFunc("/v2.0/trunks/trunk-1/add_subports", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "PUT")
+		var body addSubportsOpts
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range body.Subports {
+			added = append(added, s.PortID)
+		}
+		w.Header().Add("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id": "trunk-1"}`)
+	})
+
+	trunk := &trunks.Trunk{ID: "trunk-1", Subports: []trunks.Subport{
+		{PortID: "port-kept", SegmentationType: SegmentationTypeVLAN, SegmentationID: 1},
+		{PortID: "port-stale", SegmentationType: SegmentationTypeVLAN, SegmentationID: 2},
+		{PortID: "kuryr", SegmentationType: SegmentationTypeVLAN, SegmentationID: 3},
+	}}
+	portOpts := openstackconfigv1.PortOpts{NameSuffix: "trunk", Subports: []openstackconfigv1.SubportOpts{
+		{NetworkID: "net-1", NameSuffix: "kept"},
+		{NetworkID: "net-2", NameSuffix: "new"},
+	}}
+
+	is := newFakeInstanceService()
+	portIDs, err := is.addSubports("worker-0", trunk, portOpts, []string{"machine"}, []string{"sg-1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"port-kept", "port-new"}; !reflect.DeepEqual(portIDs, expected) {
+		t.Errorf("expected subport ports %v, got %v", expected, portIDs)
+	}
+	if expected := []string{"sg-1"}; !reflect.DeepEqual(createdSecurityGroups, expected) {
+		t.Errorf("expected the subport port to be created with security groups %v, got %v", expected, createdSecurityGroups)
+	}
+	if expected := []string{"port-new"}; !reflect.DeepEqual(tagged, expected) {
+		t.Errorf("expected only ports %v to be tagged, got %v", expected, tagged)
+	}
+	if expected := []string{"port-stale"}; !reflect.DeepEqual(removed, expected) || !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("expected subports %v to be removed and deleted, got %v and %v", expected, removed, deleted)
+	}
+	if expected := []string{"port-new"}; !reflect.DeepEqual(added, expected) {
+		t.Errorf("expected subports %v to be added, got %v", expected, added)
+	}
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	configv1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+	ctrlRuntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// RequeueTime is how often the OpenStack resources of the cluster are
+	// reconciled.
+	RequeueTime = 10 * time.Minute
+
+	// Finalizer is set on the cluster ConfigMap while the cluster has
+	// OpenStack resources, which are deleted along with the ConfigMap.
+	Finalizer = "openstack.machine.openshift.io/cluster"
+)
+
+// Reconciler creates the network, subnet, router and managed security groups
+// of the cluster described by the cluster ConfigMap of a namespace, records
+// them in the status of the ConfigMap, and deletes them once the ConfigMap is
+// deleted.
+type Reconciler struct {
+	Client client.Client
+	Log    logr.Logger
+
+	// Namespace restricts the cluster ConfigMaps watched to a namespace. All
+	// namespaces are watched if it is empty.
+	Namespace string
+
+	// KubeClient reads the clouds secret and CA certificate of the cluster.
+	KubeClient kubernetes.Interface
+
+	// apiReader reads the cluster-scoped Infrastructure object, the manager
+	// cache may be restricted to the namespace of the machines.
+	apiReader client.Reader
+
+	// configMaps reads the cluster ConfigMaps from a cache holding only them,
+	// so that the manager does not cache every ConfigMap of the cluster.
+	configMaps client.Reader
+
+	// getServices returns the OpenStack services of the cluster described
+	// by the ConfigMap of the namespace.
+	getServices func(namespace string, spec *openstackconfigv1.OpenstackClusterProviderSpec) (*clients.NetworkService, *clients.SecGroupService, error)
+}
+
+// Reconcile reconciles the OpenStack resources of a cluster.
+func (r *Reconciler) Reconcile(ctx context.Context, req ctrlRuntime.Request) (ctrlRuntime.Result, error) {
+	logger := r.Log.WithValues("configmap", req.Name, "namespace", req.Namespace)
+
+	if req.Name != clients.ClusterConfigMapName {
+		return ctrlRuntime.Result{}, nil
+	}
+	configMap := &corev1.ConfigMap{}
+	if err := r.configMaps.Get(ctx, req.NamespacedName, configMap); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrlRuntime.Result{}, nil
+		}
+		return ctrlRuntime.Result{}, err
+	}
+	spec, status, err := clients.ClusterConfigFromConfigMap(configMap)
+	if err != nil {
+		return ctrlRuntime.Result{}, err
+	}
+
+	if !configMap.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(configMap, Finalizer) {
+			return ctrlRuntime.Result{}, nil
+		}
+		if hasResources(status) {
+			if err := r.delete(ctx, configMap, spec, status); err != nil {
+				return ctrlRuntime.Result{}, err
+			}
+			logger.Info("Deleted the OpenStack resources of the cluster")
+		}
+		controllerutil.RemoveFinalizer(configMap, Finalizer)
+		return ctrlRuntime.Result{}, r.Client.Update(ctx, configMap)
+	}
+
+	if spec.NodeCIDR == "" && !spec.ManagedSecurityGroups && !hasResources(status) {
+		return ctrlRuntime.Result{}, nil
+	}
+	if !controllerutil.ContainsFinalizer(configMap, Finalizer) {
+		controllerutil.AddFinalizer(configMap, Finalizer)
+		if err := r.Client.Update(ctx, configMap); err != nil {
+			return ctrlRuntime.Result{}, err
+		}
+	}
+
+	clusterName, err := r.clusterName(ctx)
+	if err != nil {
+		return ctrlRuntime.Result{}, err
+	}
+	networkService, secGroupService, err := r.getServices(configMap.Namespace, spec)
+	if err != nil {
+		return ctrlRuntime.Result{}, err
+	}
+	reconcileErr := networkService.Reconcile(clusterName, *spec, status)
+	if reconcileErr != nil {
+		reconcileErr = fmt.Errorf("failed to reconcile the network of cluster %s: %w", clusterName, reconcileErr)
+	} else if err := secGroupService.Reconcile(clusterName, *spec, status); err != nil {
+		reconcileErr = fmt.Errorf("failed to reconcile the security groups of cluster %s: %w", clusterName, err)
+	}
+	// The status is updated even on failure, so that the resources already
+	// created are deleted with the cluster.
+	if err := r.updateStatus(ctx, configMap, status); err != nil {
+		return ctrlRuntime.Result{}, err
+	}
+	if reconcileErr != nil {
+		return ctrlRuntime.Result{}, reconcileErr
+	}
+	return ctrlRuntime.Result{RequeueAfter: RequeueTime}, nil
+}
+
+// delete deletes the OpenStack resources recorded in the status of the
+// cluster.
+func (r *Reconciler) delete(ctx context.Context, configMap *corev1.ConfigMap, spec *openstackconfigv1.OpenstackClusterProviderSpec, status *openstackconfigv1.OpenstackClusterProviderStatus) error {
+	clusterName, err := r.clusterName(ctx)
+	if err != nil {
+		return err
+	}
+	networkService, secGroupService, err := r.getServices(configMap.Namespace, spec)
+	if err != nil {
+		return err
+	}
+	deleteErr := secGroupService.DeleteClusterGroups(clusterName, status)
+	if deleteErr != nil {
+		deleteErr = fmt.Errorf("failed to delete the security groups of cluster %s: %w", clusterName, deleteErr)
+	} else if err := networkService.Delete(clusterName, status); err != nil {
+		deleteErr = fmt.Errorf("failed to delete the network of cluster %s: %w", clusterName, err)
+	}
+	if err := r.updateStatus(ctx, configMap, status); err != nil {
+		return err
+	}
+	return deleteErr
+}
+
+// servicesFor returns the OpenStack services authenticated with the clouds
+// secret of the provider spec of the cluster.
+func (r *Reconciler) servicesFor(namespace string, spec *openstackconfigv1.OpenstackClusterProviderSpec) (*clients.NetworkService, *clients.SecGroupService, error) {
+	networkService, err := clients.NewNetworkServiceFromClusterSpec(r.KubeClient, namespace, spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	secGroupService, err := clients.NewSecGroupServiceFromClusterSpec(r.KubeClient, namespace, spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	return networkService, secGroupService, nil
+}
+
+// hasResources returns true if the status of the cluster records OpenStack
+// resources.
+func hasResources(status *openstackconfigv1.OpenstackClusterProviderStatus) bool {
+	return status.Network != nil || status.ControlPlaneSecurityGroup != nil || status.GlobalSecurityGroup != nil
+}
+
+// updateStatus writes the status of the cluster to its ConfigMap, if it
+// changed.
+func (r *Reconciler) updateStatus(ctx context.Context, configMap *corev1.ConfigMap, status *openstackconfigv1.OpenstackClusterProviderStatus) error {
+	status.APIVersion = openstackconfigv1.SchemeGroupVersion.String()
+	status.Kind = "OpenstackClusterProviderStatus"
+	rawStatus, err := openstackconfigv1.EncodeClusterStatus(status)
+	if err != nil {
+		return err
+	}
+	if configMap.Data[clients.ClusterStatusKey] == string(rawStatus.Raw) {
+		return nil
+	}
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[clients.ClusterStatusKey] = string(rawStatus.Raw)
+	return r.Client.Update(ctx, configMap)
+}
+
+// clusterName returns the infrastructure name of the cluster, which names and
+// tags its OpenStack resources.
+func (r *Reconciler) clusterName(ctx context.Context) (string, error) {
+	infra := &configv1.Infrastructure{}
+	if err := r.apiReader.Get(ctx, client.ObjectKey{Name: "cluster"}, infra); err != nil {
+		return "", fmt.Errorf("failed to retrieve cluster Infrastructure object: %w", err)
+	}
+	return infra.Status.InfrastructureName, nil
+}
+
+func (r *Reconciler) SetupWithManager(mgr ctrlRuntime.Manager, options controller.Options) error {
+	// The cluster ConfigMaps are watched through their own cache, the cache
+	// of the manager is shared with the other ConfigMap readers.
+	configMaps, err := cache.New(mgr.GetConfig(), cache.Options{
+		Scheme:    mgr.GetScheme(),
+		Mapper:    mgr.GetRESTMapper(),
+		Namespace: r.Namespace,
+		SelectorsByObject: cache.SelectorsByObject{
+			&corev1.ConfigMap{}: {Field: fields.OneTermEqualSelector("metadata.name", clients.ClusterConfigMapName)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create the cluster ConfigMap cache: %w", err)
+	}
+	if err := mgr.Add(configMaps); err != nil {
+		return fmt.Errorf("failed to add the cluster ConfigMap cache to the manager: %w", err)
+	}
+
+	options.Reconciler = r
+	c, err := controller.New("cluster", mgr, options)
+	if err != nil {
+		return fmt.Errorf("controller creation failed: %w", err)
+	}
+	if err := c.Watch(source.NewKindWithCache(&corev1.ConfigMap{}, configMaps), &handler.EnqueueRequestForObject{}); err != nil {
+		return fmt.Errorf("failed to watch the cluster ConfigMaps: %w", err)
+	}
+
+	r.Client = mgr.GetClient()
+	r.apiReader = mgr.GetAPIReader()
+	r.configMaps = configMaps
+	if r.KubeClient == nil {
+		r.KubeClient, err = kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			return fmt.Errorf("failed to create kube client: %w", err)
+		}
+	}
+	if r.getServices == nil {
+		r.getServices = r.servicesFor
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/go-logr/logr"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	. "github.com/onsi/gomega"
+	configv1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+	ctrlRuntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcile(t *testing.T) {
+	now := metav1.Now()
+
+	testCases := []struct {
+		name            string
+		configMap       *corev1.ConfigMap
+		expectErr       bool
+		expectFinalizer bool
+		expectDeleted   bool
+	}{
+		{
+			name:      "unmanaged network",
+			configMap: newConfigMap("tags:\n- ephemeral\n", ""),
+		},
+		{
+			name:            "managed network without clouds secret",
+			configMap:       newConfigMap("nodeCidr: 10.0.0.0/24\n", ""),
+			expectErr:       true,
+			expectFinalizer: true,
+		},
+		{
+			name:            "managed security groups without clouds secret",
+			configMap:       newConfigMap("managedSecurityGroups: true\n", ""),
+			expectErr:       true,
+			expectFinalizer: true,
+		},
+		{
+			name: "deleted with security groups and without clouds secret",
+			configMap: func() *corev1.ConfigMap {
+				configMap := newConfigMap("managedSecurityGroups: true\n", `{"globalSecurityGroup": {"id": "sg", "name": "k8s-cluster-ocp-secgroup-all"}}`)
+				configMap.DeletionTimestamp = &now
+				configMap.Finalizers = []string{Finalizer}
+				return configMap
+			}(),
+			expectErr:       true,
+			expectFinalizer: true,
+		},
+		{
+			name: "deleted without network",
+			configMap: func() *corev1.ConfigMap {
+				configMap := newConfigMap("nodeCidr: 10.0.0.0/24\n", "{}")
+				configMap.DeletionTimestamp = &now
+				configMap.Finalizers = []string{Finalizer}
+				return configMap
+			}(),
+			expectDeleted: true,
+		},
+		{
+			name: "deleted with network and without clouds secret",
+			configMap: func() *corev1.ConfigMap {
+				configMap := newConfigMap("nodeCidr: 10.0.0.0/24\n", `{"network": {"id": "net", "name": "k8s-cluster-ocp"}}`)
+				configMap.DeletionTimestamp = &now
+				configMap.Finalizers = []string{Finalizer}
+				return configMap
+			}(),
+			expectErr:       true,
+			expectFinalizer: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			g := NewWithT(tt)
+
+			scheme := runtime.NewScheme()
+			g.Expect(corev1.AddToScheme(scheme)).To(Succeed())
+			g.Expect(configv1.AddToScheme(scheme)).To(Succeed())
+
+			infra := &configv1.Infrastructure{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+				Status:     configv1.InfrastructureStatus{InfrastructureName: "ocp"},
+			}
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tc.configMap, infra).Build()
+
+			r := &Reconciler{
+				Client:     fakeClient,
+				Log:        logr.Discard(),
+				apiReader:  fakeClient,
+				configMaps: fakeClient,
+			}
+			r.getServices = r.servicesFor
+			result, err := r.Reconcile(context.TODO(), ctrlRuntime.Request{NamespacedName: types.NamespacedName{Namespace: "openshift-machine-api", Name: clients.ClusterConfigMapName}})
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(result.RequeueAfter).To(BeZero())
+			}
+
+			configMap := &corev1.ConfigMap{}
+			err = fakeClient.Get(context.TODO(), client.ObjectKeyFromObject(tc.configMap), configMap)
+			if tc.expectDeleted && apierrors.IsNotFound(err) {
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			if tc.expectFinalizer {
+				g.Expect(configMap.Finalizers).To(ConsistOf(Finalizer))
+			} else {
+				g.Expect(configMap.Finalizers).To(BeEmpty())
+			}
+		})
+	}
+}
+
+func TestReconcileNetwork(t *testing.T) {
+	g := NewWithT(t)
+
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/v2.0/networks", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		switch r.Method {
+		case "GET":
+			th.TestFormValues(t, r, map[string]string{"name": "k8s-cluster-ocp"})
+			fmt.Fprint(w, `{"networks": []}`)
+		case "POST":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"network": {"id": "net", "name": "k8s-cluster-ocp"}}`)
+		}
+	})
+	th.Mux.HandleFunc("/v2.0/subnets", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		switch r.Method {
+		case "GET":
+			fmt.Fprint(w, `{"subnets": []}`)
+		case "POST":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"subnet": {"id": "subnet", "name": "k8s-cluster-ocp", "network_id": "net", "cidr": "10.0.0.0/24"}}`)
+		}
+	})
+	for _, path := range []string{"/v2.0/networks/net/tags", "/v2.0/subnets/subnet/tags"} {
+		th.Mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			th.TestMethod(t, r, "PUT")
+			w.Header().Add("Content-Type", "application/json")
+			fmt.Fprint(w, `{"tags": ["cluster-api-provider-openstack", "ocp"]}`)
+		})
+	}
+
+	scheme := runtime.NewScheme()
+	g.Expect(corev1.AddToScheme(scheme)).To(Succeed())
+	g.Expect(configv1.AddToScheme(scheme)).To(Succeed())
+	infra := &configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+		Status:     configv1.InfrastructureStatus{InfrastructureName: "ocp"},
+	}
+	configMap := newConfigMap("nodeCidr: 10.0.0.0/24\n", "")
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(configMap, infra).Build()
+
+	r := &Reconciler{
+		Client:     fakeClient,
+		Log:        logr.Discard(),
+		apiReader:  fakeClient,
+		configMaps: fakeClient,
+		getServices: func(namespace string, spec *openstackconfigv1.OpenstackClusterProviderSpec) (*clients.NetworkService, *clients.SecGroupService, error) {
+			networkClient := fakeclient.ServiceClient()
+			networkClient.ResourceBase = networkClient.Endpoint + "v2.0/"
+			networkService, err := clients.NewNetworkService(networkClient)
+			if err != nil {
+				return nil, nil, err
+			}
+			secGroupService, err := clients.NewSecGroupService(networkClient)
+			if err != nil {
+				return nil, nil, err
+			}
+			return networkService, secGroupService, nil
+		},
+	}
+	result, err := r.Reconcile(context.TODO(), ctrlRuntime.Request{NamespacedName: client.ObjectKeyFromObject(configMap)})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(RequeueTime))
+
+	g.Expect(fakeClient.Get(context.TODO(), client.ObjectKeyFromObject(configMap), configMap)).To(Succeed())
+	g.Expect(configMap.Finalizers).To(ConsistOf(Finalizer))
+	_, status, err := clients.ClusterConfigFromConfigMap(configMap)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(status.Network).To(Equal(&openstackconfigv1.Network{
+		ID:     "net",
+		Name:   "k8s-cluster-ocp",
+		Subnet: &openstackconfigv1.Subnet{ID: "subnet", Name: "k8s-cluster-ocp", CIDR: "10.0.0.0/24"},
+	}))
+}
+
+func TestReconcileIgnoresOtherConfigMaps(t *testing.T) {
+	g := NewWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(corev1.AddToScheme(scheme)).To(Succeed())
+
+	configMap := newConfigMap("nodeCidr: 10.0.0.0/24\n", "")
+	configMap.Name = "other"
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(configMap).Build()
+
+	r := &Reconciler{
+		Client:     fakeClient,
+		Log:        logr.Discard(),
+		apiReader:  fakeClient,
+		configMaps: fakeClient,
+	}
+	_, err := r.Reconcile(context.TODO(), ctrlRuntime.Request{NamespacedName: types.NamespacedName{Namespace: "openshift-machine-api", Name: "other"}})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(fakeClient.Get(context.TODO(), client.ObjectKeyFromObject(configMap), configMap)).To(Succeed())
+	g.Expect(configMap.Finalizers).To(BeEmpty())
+}
+
+func newConfigMap(spec string, status string) *corev1.ConfigMap {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      clients.ClusterConfigMapName,
+			Namespace: "openshift-machine-api",
+		},
+		Data: map[string]string{clients.ClusterSpecKey: spec},
+	}
+	if status != "" {
+		configMap.Data[clients.ClusterStatusKey] = status
+	}
+	return configMap
+}
@@ -19,6 +19,7 @@ package machine
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -29,18 +30,13 @@ import (
 
 	machinev1 "github.com/openshift/api/machine/v1beta1"
 	apierrors "github.com/openshift/machine-api-operator/pkg/controller/machine"
-	"github.com/openshift/machine-api-operator/pkg/util"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	tokenapi "k8s.io/cluster-bootstrap/token/api"
-	tokenutil "k8s.io/cluster-bootstrap/token/util"
 	"k8s.io/klog/v2"
 	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
-	"sigs.k8s.io/cluster-api-provider-openstack/pkg/bootstrap"
 	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack"
 	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
-	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/options"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	clconfig "github.com/coreos/container-linux-config-transpiler/config"
@@ -57,6 +53,9 @@ const (
 	TimeoutInstanceDelete       = 5
 	RetryIntervalInstanceStatus = 10 * time.Second
 
+	// imageNotReadyRequeueTime is how long to wait for an image to be uploaded
+	imageNotReadyRequeueTime = 30 * time.Second
+
 	// MachineInstanceStateAnnotationName as annotation name for a machine instance state
 	MachineInstanceStateAnnotationName = "machine.openshift.io/instance-state"
 
@@ -140,6 +139,11 @@ func (oc *OpenstackClient) Create(ctx context.Context, machine *machinev1.Machin
 	}
 
 	if err = oc.validateMachine(machine); err != nil {
+		var notReady *clients.ImageNotReadyError
+		if errors.As(err, &notReady) {
+			klog.Infof("Waiting for the image of machine %s: %v", machine.Name, err)
+			return &apierrors.RequeueAfterError{RequeueAfter: imageNotReadyRequeueTime}
+		}
 		verr := apierrors.InvalidMachineConfiguration("Machine validation failed: %v", err)
 		return oc.handleMachineError(machine, verr, createEventAction)
 	}
@@ -156,11 +160,16 @@ func (oc *OpenstackClient) Create(ctx context.Context, machine *machinev1.Machin
 		return oc.handleMachineError(machine, verr, createEventAction)
 	}
 
+	if err = oc.checkQuota(machine, providerSpec, machineService); err != nil {
+		return err
+	}
+
 	// get machine startup script
 	var ok bool
 	var disableTemplating bool
 	var postprocessor string
 	var postprocess bool
+	var userDataSecretData map[string][]byte
 
 	userData := []byte{}
 	if providerSpec.UserDataSecret != nil {
@@ -178,6 +187,7 @@ func (oc *OpenstackClient) Create(ctx context.Context, machine *machinev1.Machin
 			return err
 		}
 
+		userDataSecretData = userDataSecret.Data
 		userData, ok = userDataSecret.Data[UserDataKey]
 		if !ok {
 			return fmt.Errorf("Machine's userdata secret %v in namespace %v did not contain key %v", providerSpec.UserDataSecret.Name, namespace, UserDataKey)
@@ -193,25 +203,16 @@ func (oc *OpenstackClient) Create(ctx context.Context, machine *machinev1.Machin
 
 	var userDataRendered string
 	if len(userData) > 0 && !disableTemplating {
-		// FIXME(mandre) Find the right way to check if machine is part of the control plane
-		if machine.ObjectMeta.Name != "" {
-			userDataRendered, err = masterStartupScript(machine, string(userData))
-			if err != nil {
-				return oc.handleMachineError(machine, apierrors.CreateMachine(
-					"error creating Openstack instance: %v", err), createEventAction)
-			}
+		params := oc.startupScriptParams(providerSpec, machineService)
+		if isControlPlane(machine, providerSpec) {
+			userDataRendered, err = masterStartupScript(machine, params, string(userData))
 		} else {
-			klog.Info("Creating bootstrap token")
-			token, err := oc.createBootstrapToken()
-			if err != nil {
-				return oc.handleMachineError(machine, apierrors.CreateMachine(
-					"error creating Openstack instance: %v", err), createEventAction)
-			}
-			userDataRendered, err = nodeStartupScript(machine, token, string(userData))
-			if err != nil {
-				return oc.handleMachineError(machine, apierrors.CreateMachine(
-					"error creating Openstack instance: %v", err), createEventAction)
-			}
+			userDataRendered, err = nodeStartupScript(machine, params, func() (string, error) {
+				return oc.bootstrapToken(machine, providerSpec)
+			}, string(userData))
+		}
+		if err != nil {
+			return oc.handleMachineError(machine, startupScriptError(err), createEventAction)
 		}
 	} else {
 		userDataRendered = string(userData)
@@ -220,8 +221,13 @@ func (oc *OpenstackClient) Create(ctx context.Context, machine *machinev1.Machin
 	//Read the cluster name from the `machine`.
 	clusterName := fmt.Sprintf("%s-%s", machine.Namespace, machine.Labels["machine.openshift.io/cluster-api-cluster"])
 
-	// TODO(egarcia): if we ever use the cluster object, this will benifit from reading from it
-	var clusterSpec openstackconfigv1.OpenstackClusterProviderSpec
+	clusterSpec, clusterStatus, err := oc.getClusterConfig(machine.Namespace)
+	if err != nil {
+		return oc.handleMachineError(machine, apierrors.CreateMachine(
+			"error getting the cluster config: %v", err), createEventAction)
+	}
+	useClusterNetwork(providerSpec, clusterStatus)
+	useManagedSecurityGroups(machine, providerSpec, clusterSpec, clusterStatus)
 
 	if postprocess {
 		switch postprocessor {
@@ -244,29 +250,28 @@ func (oc *OpenstackClient) Create(ctx context.Context, machine *machinev1.Machin
 
 			userDataRendered = string(ud)
 
+		// Merge the machine files into an Ignition config.
+		case "ignition":
+			userDataRendered, err = oc.ignitionUserData(machine, providerSpec, machineService, clusterInfraName, userDataRendered)
+			if err != nil {
+				return fmt.Errorf("Postprocessor error: %v", err)
+			}
+
+		// Compose the secret parts into a cloud-init multipart user data.
+		case "cloud-init":
+			userDataRendered, err = cloudInitUserData(userDataRendered, userDataSecretData)
+			if err != nil {
+				return fmt.Errorf("Postprocessor error: %v", err)
+			}
+
 		default:
 			return fmt.Errorf("Postprocessor error: unknown postprocessor: '%s'", postprocessor)
 		}
 	}
 
-	instance, err := machineService.InstanceCreate(clusterName, machine.Name, &clusterSpec, providerSpec, userDataRendered, providerSpec.KeyName, oc.params.ConfigClient)
-
+	instance, err := oc.createInstance(machine, providerSpec, machineService, clusterName, clusterInfraName, clusterSpec, userDataRendered)
 	if err != nil {
-		return oc.handleMachineError(machine, apierrors.CreateMachine(
-			"error creating Openstack instance: %v", err), createEventAction)
-	}
-	instanceCreateTimeout := getTimeout("CLUSTER_API_OPENSTACK_INSTANCE_CREATE_TIMEOUT", TimeoutInstanceCreate)
-	instanceCreateTimeout = instanceCreateTimeout * time.Minute
-	err = util.PollImmediate(RetryIntervalInstanceStatus, instanceCreateTimeout, func() (bool, error) {
-		instance, err = machineService.GetInstance(instance.ID)
-		if err != nil {
-			return false, nil
-		}
-		return instance.Status == "ACTIVE", nil
-	})
-	if err != nil {
-		return oc.handleMachineError(machine, apierrors.CreateMachine(
-			"error creating Openstack instance: %v", err), createEventAction)
+		return err
 	}
 
 	if providerSpec.FloatingIP != "" {
@@ -284,7 +289,8 @@ func (oc *OpenstackClient) Create(ctx context.Context, machine *machinev1.Machin
 	}
 
 	oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, "Created", "Created machine %v", machine.Name)
-	return oc.updateAnnotation(machine, instance, clusterInfraName)
+	// The FQDNs of the ports are reported once the machine is updated.
+	return oc.updateAnnotation(machine, instance, machineService, nil, clusterInfraName)
 }
 
 func (oc *OpenstackClient) Delete(ctx context.Context, machine *machinev1.Machine) error {
@@ -293,6 +299,16 @@ func (oc *OpenstackClient) Delete(ctx context.Context, machine *machinev1.Machin
 		return err
 	}
 
+	if err := deleteIgnitionObject(machine, machineService); err != nil {
+		return oc.handleMachineError(machine, apierrors.DeleteMachine(
+			"error deleting Ignition config: %v", err), deleteEventAction)
+	}
+
+	if err := oc.deleteBootstrapToken(machine); err != nil {
+		return oc.handleMachineError(machine, apierrors.DeleteMachine(
+			"error deleting bootstrap token: %v", err), deleteEventAction)
+	}
+
 	instance, err := oc.instanceExists(machine)
 	if err != nil {
 		return err
@@ -300,6 +316,10 @@ func (oc *OpenstackClient) Delete(ctx context.Context, machine *machinev1.Machin
 
 	if instance == nil {
 		klog.Infof("Skipped deleting %s that is already deleted.\n", machine.Name)
+		if err := oc.releaseIPs(machine); err != nil {
+			return oc.handleMachineError(machine, apierrors.DeleteMachine(
+				"error releasing IP addresses: %v", err), deleteEventAction)
+		}
 		return nil
 	}
 
@@ -310,6 +330,13 @@ func (oc *OpenstackClient) Delete(ctx context.Context, machine *machinev1.Machin
 			"error deleting Openstack instance: %v", err), deleteEventAction)
 	}
 
+	// The addresses are only released once the ports of the server are
+	// deleted, so that they are not allocated to another machine while in use.
+	if err := oc.releaseIPs(machine); err != nil {
+		return oc.handleMachineError(machine, apierrors.DeleteMachine(
+			"error releasing IP addresses: %v", err), deleteEventAction)
+	}
+
 	oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, "Deleted", "Deleted machine %v", machine.Name)
 	return nil
 }
@@ -323,8 +350,51 @@ func (oc *OpenstackClient) Update(ctx context.Context, machine *machinev1.Machin
 	if err != nil {
 		return fmt.Errorf("error fetching OpenStack server for machine %s: %w", machine.Name, err)
 	}
+	// The server was deleted since Exists was called. The machine is
+	// requeued and the next Exists call reports the missing server.
+	if instance == nil {
+		return fmt.Errorf("OpenStack server for machine %s no longer exists", machine.Name)
+	}
+
+	providerSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return oc.handleMachineError(machine, apierrors.InvalidMachineConfiguration(
+			"Cannot unmarshal providerSpec field: %v", err), updateEventAction)
+	}
+
+	if err := oc.reconcileInstanceState(machine, providerSpec, instance); err != nil {
+		return fmt.Errorf("error reconciling state of OpenStack server for machine %s: %w", machine.Name, err)
+	}
+
+	machineService, err := clients.NewInstanceServiceFromMachine(oc.params.KubeClient, machine)
+	if err != nil {
+		return err
+	}
+	clusterSpec, clusterStatus, err := oc.getClusterConfig(machine.Namespace)
+	if err != nil {
+		return fmt.Errorf("error getting the cluster config of machine %s: %w", machine.Name, err)
+	}
+
+	if err := oc.reconcilePorts(machine, providerSpec, instance, machineService, clusterInfraName, clusterSpec, clusterStatus); err != nil {
+		return fmt.Errorf("error reconciling ports of OpenStack server for machine %s: %w", machine.Name, err)
+	}
 
-	return oc.updateAnnotation(machine, instance, clusterInfraName)
+	// The ports of the server are listed once, for its managed security
+	// groups, its SR-IOV interfaces and the FQDNs of its ports.
+	instancePorts, err := machineService.GetInstancePorts(instance.ID)
+	if err != nil {
+		return fmt.Errorf("error fetching the ports of OpenStack server for machine %s: %w", machine.Name, err)
+	}
+
+	if err := reconcileManagedSecurityGroups(machine, providerSpec, machineService, instancePorts, clusterSpec, clusterStatus); err != nil {
+		return fmt.Errorf("error reconciling managed security groups of OpenStack server for machine %s: %w", machine.Name, err)
+	}
+
+	if err := oc.reconcileSRIOVInterfaces(machine, instance, instancePorts); err != nil {
+		return fmt.Errorf("error reconciling SR-IOV interfaces of OpenStack server for machine %s: %w", machine.Name, err)
+	}
+
+	return oc.updateAnnotation(machine, instance, machineService, instancePorts, clusterInfraName)
 }
 
 func (oc *OpenstackClient) Exists(ctx context.Context, machine *machinev1.Machine) (bool, error) {
@@ -335,6 +405,21 @@ func (oc *OpenstackClient) Exists(ctx context.Context, machine *machinev1.Machin
 	return instance != nil, err
 }
 
+// useClusterNetwork attaches machines without networks nor ports to the
+// network created for the cluster, if any.
+func useClusterNetwork(providerSpec *openstackconfigv1.OpenstackProviderSpec, clusterStatus *openstackconfigv1.OpenstackClusterProviderStatus) {
+	if len(providerSpec.Networks) > 0 || len(providerSpec.Ports) > 0 {
+		return
+	}
+	if clusterStatus.Network == nil || clusterStatus.Network.Subnet == nil {
+		return
+	}
+	providerSpec.Networks = []openstackconfigv1.NetworkParam{{
+		UUID:    clusterStatus.Network.ID,
+		Subnets: []openstackconfigv1.SubnetParam{{UUID: clusterStatus.Network.Subnet.ID}},
+	}}
+}
+
 func getIPsFromInstance(instance *clients.Instance) ([]corev1.NodeAddress, error) {
 	type networkInterface struct {
 		Address string  `json:"addr"`
@@ -407,16 +492,20 @@ func (oc *OpenstackClient) handleMachineError(machine *machinev1.Machine, err *a
 		}
 		machine.ObjectMeta.Annotations[MachineInstanceStateAnnotationName] = ErrorState
 
+		// The update of the machine discards its status, which is kept so
+		// that callers can still persist the failure with patchStatus.
+		statusCopy := *machine.Status.DeepCopy()
 		if err := oc.client.Update(context.TODO(), machine); err != nil {
 			return fmt.Errorf("unable to update machine status: %v", err)
 		}
+		machine.Status = statusCopy
 	}
 
 	klog.Errorf("Machine error %s: %v", machine.Name, err.Message)
 	return err
 }
 
-func (oc *OpenstackClient) updateAnnotation(machine *machinev1.Machine, instance *clients.Instance, clusterInfraName string) error {
+func (oc *OpenstackClient) updateAnnotation(machine *machinev1.Machine, instance *clients.Instance, machineService *clients.InstanceService, instancePorts []clients.InstancePort, clusterInfraName string) error {
 	providerID := fmt.Sprintf("openstack:///%s", instance.ID)
 
 	if machine.Spec.ProviderID != nil {
@@ -447,27 +536,27 @@ func (oc *OpenstackClient) updateAnnotation(machine *machinev1.Machine, instance
 		return err
 	}
 
-	nodeAddresses = append(nodeAddresses, corev1.NodeAddress{
-		Type:    corev1.NodeHostName,
-		Address: machine.Name,
-	})
-
-	nodeAddresses = append(nodeAddresses, corev1.NodeAddress{
-		Type:    corev1.NodeInternalDNS,
-		Address: machine.Name,
-	})
+	dnsAddresses, err := getDNSAddresses(machine, machineService, instancePorts, clusterInfraName)
+	if err != nil {
+		return err
+	}
+	nodeAddresses = append(nodeAddresses, dnsAddresses...)
 
+	// The update of the machine discards the status set during the
+	// reconcile, such as the InstanceReady condition, which is written along
+	// with the addresses.
 	machineCopy := machine.DeepCopy()
+	machineCopy.Status = statusCopy
 	machineCopy.Status.Addresses = nodeAddresses
 
-	if !equality.Semantic.DeepEqual(machine.Status.Addresses, machineCopy.Status.Addresses) {
-		if err := oc.client.Status().Update(context.TODO(), machineCopy); err != nil {
-			return err
-		}
+	if equality.Semantic.DeepEqual(machine.Status, machineCopy.Status) {
+		return nil
 	}
-
-	machine.Status = statusCopy
-	return oc.client.Update(context.TODO(), machine)
+	if err := oc.client.Status().Update(context.TODO(), machineCopy); err != nil {
+		return err
+	}
+	machineCopy.DeepCopyInto(machine)
+	return nil
 }
 
 func (oc *OpenstackClient) instanceExists(machine *machinev1.Machine) (instance *clients.Instance, err error) {
@@ -490,33 +579,18 @@ func (oc *OpenstackClient) instanceExists(machine *machinev1.Machine) (instance
 	if err != nil {
 		return nil, fmt.Errorf("\nError listing the instances: %v", err)
 	}
-	if len(instanceList) == 0 {
-		return nil, nil
-	}
-	return instanceList[0], nil
-}
 
-func (oc *OpenstackClient) createBootstrapToken() (string, error) {
-	token, err := tokenutil.GenerateBootstrapToken()
-	if err != nil {
-		return "", err
+	// Servers replaced by a later creation attempt may not be gone yet
+	var replaced map[string]bool
+	if providerStatus, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus); err == nil {
+		replaced = replacedInstanceIDs(providerStatus.CreationAttempts)
 	}
-
-	expiration := time.Now().UTC().Add(options.TokenTTL)
-	tokenSecret, err := bootstrap.GenerateTokenSecret(token, expiration)
-	if err != nil {
-		panic(fmt.Sprintf("unable to create token. there might be a bug somwhere: %v", err))
-	}
-
-	err = oc.client.Create(context.TODO(), tokenSecret)
-	if err != nil {
-		return "", err
+	for _, instance := range instanceList {
+		if !replaced[instance.ID] {
+			return instance, nil
+		}
 	}
-
-	return tokenutil.TokenFromIDAndSecret(
-		string(tokenSecret.Data[tokenapi.BootstrapTokenIDKey]),
-		string(tokenSecret.Data[tokenapi.BootstrapTokenSecretKey]),
-	), nil
+	return nil, nil
 }
 
 func (oc *OpenstackClient) validateMachine(machine *machinev1.Machine) error {
@@ -532,8 +606,36 @@ func (oc *OpenstackClient) validateMachine(machine *machinev1.Machine) error {
 
 	// TODO(mfedosin): add more validations here
 
+	if machineSpec.ImageFilter != nil && machineSpec.Image != "" {
+		return fmt.Errorf("image and imageFilter cannot both be set")
+	}
+
+	if err := clients.ValidateVNICTypes(machineSpec); err != nil {
+		return err
+	}
+
+	if err := clients.ValidateSubports(machineSpec); err != nil {
+		return err
+	}
+
+	if machineSpec.Hostname != "" {
+		clusterInfraName, err := oc.getClusterInfraName()
+		if err != nil {
+			return err
+		}
+		if _, err := clients.Hostname(machineSpec, clusterInfraName, machine.Name); err != nil {
+			return err
+		}
+	}
+
+	for _, network := range machineSpec.Networks {
+		if network.IPPool != nil && network.FixedIp != "" {
+			return fmt.Errorf("fixedIp and ipPool cannot both be set on network %q", network.UUID)
+		}
+	}
+
 	// Validate that image exists when not booting from volume
-	if machineSpec.RootVolume == nil {
+	if machineSpec.RootVolume == nil && machineSpec.ImageFilter == nil {
 		err = machineService.DoesImageExist(machineSpec.Image)
 		if err != nil {
 			return err
@@ -546,11 +648,22 @@ func (oc *OpenstackClient) validateMachine(machine *machinev1.Machine) error {
 		return err
 	}
 
+	// Validate that the image is active and can be booted with the flavor
+	err = machineService.ValidateImage(machineSpec)
+	if err != nil {
+		return err
+	}
+
 	// Validate that Availability Zone exists
 	err = machineService.DoesAvailabilityZoneExist(machineSpec.AvailabilityZone)
 	if err != nil {
 		return err
 	}
+	for _, zone := range machineSpec.FallbackAvailabilityZones {
+		if err := machineService.DoesAvailabilityZoneExist(zone); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
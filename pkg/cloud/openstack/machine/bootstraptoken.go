@@ -0,0 +1,136 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	tokenapi "k8s.io/cluster-bootstrap/token/api"
+	tokenutil "k8s.io/cluster-bootstrap/token/util"
+	"k8s.io/klog/v2"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/bootstrap"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/options"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// MachineRoleLabel is the label holding the role of a machine.
+	MachineRoleLabel = "machine.openshift.io/cluster-api-machine-role"
+
+	// BootstrapTokenAnnotationKey records the name of the bootstrap token
+	// secret of a worker machine.
+	BootstrapTokenAnnotationKey = bootstrap.MachineTokenAnnotation
+)
+
+// isControlPlane returns true if the user data of the machine is rendered
+// for a control plane machine, which joins without a bootstrap token.
+func isControlPlane(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec) bool {
+	role := providerSpec.Role
+	if role == "" {
+		role = machine.Labels[MachineRoleLabel]
+	}
+	return role == "master" || role == "control-plane"
+}
+
+// bootstrapToken returns the bootstrap token of a worker machine, creating it
+// unless the machine already has one. The token secret is recorded on the
+// machine right away, so that a later attempt to create the server reuses it.
+// Tokens are deleted by the bootstrap token controller once the node of the
+// machine is ready.
+func (oc *OpenstackClient) bootstrapToken(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec) (string, error) {
+	if secretName, ok := machine.Annotations[BootstrapTokenAnnotationKey]; ok {
+		tokenSecret, err := oc.params.KubeClient.CoreV1().Secrets(metav1.NamespaceSystem).Get(context.TODO(), secretName, metav1.GetOptions{})
+		if err == nil {
+			return tokenutil.TokenFromIDAndSecret(
+				string(tokenSecret.Data[tokenapi.BootstrapTokenIDKey]),
+				string(tokenSecret.Data[tokenapi.BootstrapTokenSecretKey]),
+			), nil
+		}
+		if !kerrors.IsNotFound(err) {
+			return "", err
+		}
+	}
+
+	klog.Infof("Creating bootstrap token for machine %s", machine.Name)
+	token, err := tokenutil.GenerateBootstrapToken()
+	if err != nil {
+		return "", err
+	}
+
+	tokenOptions := bootstrap.TokenOptionsForMachine(machine.Namespace, machine.Name)
+	tokenOptions.Expiration = time.Now().UTC().Add(options.TokenTTL)
+	if providerSpec.BootstrapToken != nil {
+		tokenOptions.Usages = providerSpec.BootstrapToken.Usages
+		tokenOptions.Groups = providerSpec.BootstrapToken.Groups
+		if providerSpec.BootstrapToken.TTL != nil {
+			tokenOptions.Expiration = time.Now().UTC().Add(providerSpec.BootstrapToken.TTL.Duration)
+		}
+	}
+	tokenSecret, err := bootstrap.GenerateTokenSecret(token, tokenOptions)
+	if err != nil {
+		return "", fmt.Errorf("unable to create bootstrap token: %v", err)
+	}
+
+	err = oc.client.Create(context.TODO(), tokenSecret)
+	if err != nil {
+		return "", err
+	}
+
+	// Only the annotation is patched, the machine is being created and its
+	// other changes are written when the creation completes.
+	base := machine.DeepCopy()
+	if machine.Annotations == nil {
+		machine.Annotations = make(map[string]string)
+	}
+	machine.Annotations[BootstrapTokenAnnotationKey] = tokenSecret.Name
+	if err := oc.client.Patch(context.TODO(), machine, client.MergeFrom(base)); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// deleteBootstrapToken deletes the bootstrap token secret of the machine, if
+// it has one, and removes it from the annotations of the machine. The machine
+// itself is not updated.
+func (oc *OpenstackClient) deleteBootstrapToken(machine *machinev1.Machine) error {
+	secretName, ok := machine.Annotations[BootstrapTokenAnnotationKey]
+	if !ok {
+		return nil
+	}
+
+	tokenSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: metav1.NamespaceSystem,
+		},
+	}
+	if err := oc.client.Delete(context.TODO(), tokenSecret); err != nil && !kerrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete bootstrap token secret %s: %v", secretName, err)
+	}
+
+	klog.Infof("Deleted bootstrap token of machine %s", machine.Name)
+	delete(machine.Annotations, BootstrapTokenAnnotationKey)
+	return nil
+}
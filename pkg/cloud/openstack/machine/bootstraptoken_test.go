@@ -0,0 +1,60 @@
+package machine
+
+import (
+	"context"
+	"testing"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestBootstrapTokenPatchesAnnotation(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := machinev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	machine := &machinev1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "openshift-machine-api"}}
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(machine).Build()
+	oc := &OpenstackClient{client: fakeClient}
+
+	// The stored machine changes while it is being created, so that an update
+	// of the stale copy would conflict.
+	stored := &machinev1.Machine{}
+	if err := fakeClient.Get(context.TODO(), client.ObjectKeyFromObject(machine), stored); err != nil {
+		t.Fatal(err)
+	}
+	stored.Labels = map[string]string{"team": "a"}
+	if err := fakeClient.Update(context.TODO(), stored); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := oc.bootstrapToken(machine, &openstackconfigv1.OpenstackProviderSpec{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if token == "" {
+		t.Errorf("Expected a token")
+	}
+
+	if err := fakeClient.Get(context.TODO(), client.ObjectKeyFromObject(machine), stored); err != nil {
+		t.Fatal(err)
+	}
+	secretName := stored.Annotations[BootstrapTokenAnnotationKey]
+	if secretName == "" {
+		t.Fatalf("Expected the token annotation to be stored, got %v", stored.Annotations)
+	}
+	if stored.Labels["team"] != "a" {
+		t.Errorf("Expected the other changes of the machine to be kept, got labels %v", stored.Labels)
+	}
+	if err := fakeClient.Get(context.TODO(), client.ObjectKey{Namespace: metav1.NamespaceSystem, Name: secretName}, &corev1.Secret{}); err != nil {
+		t.Errorf("Expected the token secret to be created: %v", err)
+	}
+}
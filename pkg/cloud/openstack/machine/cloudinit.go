@@ -0,0 +1,152 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// CloudInitPartPrefix prefixes the keys of the user data secret which are
+	// added as parts of the cloud-init user data, in the order of their names.
+	CloudInitPartPrefix = "part-"
+
+	// CompressKey in the user data secret gzips the cloud-init user data.
+	CompressKey = "compress"
+)
+
+// cloudInitPartTypes maps the first line of a cloud-init part to its MIME
+// type, as detected by cloud-init itself.
+var cloudInitPartTypes = []struct {
+	prefix      string
+	contentType string
+}{
+	{"#cloud-config", "text/cloud-config"},
+	{"#cloud-boothook", "text/cloud-boothook"},
+	{"#include", "text/x-include-url"},
+	{"## template: jinja", "text/jinja2"},
+	{"#!", "text/x-shellscript"},
+}
+
+// cloudInitPart is a part of a cloud-init multipart user data.
+type cloudInitPart struct {
+	name    string
+	content []byte
+}
+
+// cloudInitUserData composes the rendered user data and the parts of the user
+// data secret into a multipart MIME user data, gzipped if requested.
+func cloudInitUserData(userData string, secretData map[string][]byte) (string, error) {
+	parts := []cloudInitPart{{name: UserDataKey, content: []byte(userData)}}
+
+	var keys []string
+	for key := range secretData {
+		if strings.HasPrefix(key, CloudInitPartPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts = append(parts, cloudInitPart{name: strings.TrimPrefix(key, CloudInitPartPrefix), content: secretData[key]})
+	}
+
+	multipartUserData, err := composeMultipart(parts)
+	if err != nil {
+		return "", err
+	}
+
+	if _, compress := secretData[CompressKey]; compress {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(multipartUserData); err != nil {
+			return "", err
+		}
+		if err := w.Close(); err != nil {
+			return "", err
+		}
+		multipartUserData = buf.Bytes()
+	}
+
+	if base64.StdEncoding.EncodedLen(len(multipartUserData)) > maxUserDataSize {
+		return "", fmt.Errorf("cloud-init user data is %d bytes, more than Nova accepts", len(multipartUserData))
+	}
+	return string(multipartUserData), nil
+}
+
+// composeMultipart validates the parts and writes them as a multipart MIME
+// message. Empty parts are skipped.
+func composeMultipart(parts []cloudInitPart) ([]byte, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	for _, part := range parts {
+		if len(bytes.TrimSpace(part.content)) == 0 {
+			continue
+		}
+		contentType, err := cloudInitPartType(part)
+		if err != nil {
+			return nil, err
+		}
+
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", contentType+`; charset="utf-8"`)
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", part.name))
+		partWriter, err := w.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := partWriter.Write(part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "Content-Type: multipart/mixed; boundary=%q\r\n", w.Boundary())
+	message.WriteString("MIME-Version: 1.0\r\n\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+// cloudInitPartType returns the MIME type of a part from its first line, and
+// checks that cloud-config parts are valid YAML mappings.
+func cloudInitPartType(part cloudInitPart) (string, error) {
+	for _, partType := range cloudInitPartTypes {
+		if !bytes.HasPrefix(part.content, []byte(partType.prefix)) {
+			continue
+		}
+		if partType.contentType == "text/cloud-config" {
+			var config map[string]interface{}
+			if err := yaml.Unmarshal(part.content, &config); err != nil {
+				return "", fmt.Errorf("invalid cloud-config in part %s: %v", part.name, err)
+			}
+		}
+		return partType.contentType, nil
+	}
+	return "", fmt.Errorf("unknown cloud-init part type in part %s, it must start with #cloud-config, #cloud-boothook, #include, ## template: jinja or #!", part.name)
+}
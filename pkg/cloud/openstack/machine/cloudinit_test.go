@@ -0,0 +1,122 @@
+package machine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestCloudInitUserData(t *testing.T) {
+	testCases := []struct {
+		name          string
+		userData      string
+		secretData    map[string][]byte
+		expectedParts []string
+		expectedErr   string
+	}{
+		{
+			name:     "config and scripts in key order",
+			userData: "#cloud-config\npackages: [git]\n",
+			secretData: map[string][]byte{
+				"part-20-setup.sh": []byte("#!/bin/sh\necho setup\n"),
+				"part-10-boothook": []byte("#cloud-boothook\necho boot\n"),
+				"other":            []byte("not a part"),
+			},
+			expectedParts: []string{
+				`text/cloud-config; charset="utf-8"` + " userData",
+				`text/cloud-boothook; charset="utf-8"` + " 10-boothook",
+				`text/x-shellscript; charset="utf-8"` + " 20-setup.sh",
+			},
+		},
+		{
+			name:     "jinja template and compression",
+			userData: "## template: jinja\n#cloud-config\nhostname: {{ v1.local_hostname }}\n",
+			secretData: map[string][]byte{
+				"compress": nil,
+			},
+			expectedParts: []string{
+				`text/jinja2; charset="utf-8"` + " userData",
+			},
+		},
+		{
+			name:     "empty user data is skipped",
+			userData: "",
+			secretData: map[string][]byte{
+				"part-script": []byte("#!/bin/bash\ntrue\n"),
+			},
+			expectedParts: []string{
+				`text/x-shellscript; charset="utf-8"` + " script",
+			},
+		},
+		{
+			name:        "invalid cloud-config",
+			userData:    "#cloud-config\npackages: [git\n",
+			expectedErr: "invalid cloud-config in part userData",
+		},
+		{
+			name:     "unknown part type",
+			userData: "#cloud-config\n{}\n",
+			secretData: map[string][]byte{
+				"part-notes": []byte("some notes"),
+			},
+			expectedErr: "unknown cloud-init part type in part notes",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			userData, err := cloudInitUserData(tc.userData, tc.secretData)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var reader io.Reader = strings.NewReader(userData)
+			if _, compress := tc.secretData[CompressKey]; compress {
+				reader, err = gzip.NewReader(reader)
+				if err != nil {
+					t.Fatalf("user data is not gzipped: %v", err)
+				}
+			}
+			message, err := mail.ReadMessage(reader)
+			if err != nil {
+				t.Fatalf("invalid MIME message: %v", err)
+			}
+			mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+			if err != nil || mediaType != "multipart/mixed" {
+				t.Fatalf("expected multipart/mixed, got %q: %v", mediaType, err)
+			}
+
+			var parts []string
+			partReader := multipart.NewReader(message.Body, params["boundary"])
+			for {
+				part, err := partReader.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("invalid part: %v", err)
+				}
+				content, err := ioutil.ReadAll(part)
+				if err != nil || !bytes.HasPrefix(content, []byte("#")) {
+					t.Errorf("unexpected content of part %s: %q", part.FileName(), content)
+				}
+				parts = append(parts, part.Header.Get("Content-Type")+" "+part.FileName())
+			}
+			if strings.Join(parts, "\n") != strings.Join(tc.expectedParts, "\n") {
+				t.Errorf("expected parts %v, got %v", tc.expectedParts, parts)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	apierrors "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/klog/v2"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Classified reasons for a server which went to ERROR while being built
+const (
+	FaultReasonNoValidHost   = "NoValidHost"
+	FaultReasonQuotaExceeded = "QuotaExceeded"
+	FaultReasonImage         = "ImageError"
+	FaultReasonVolume        = "VolumeError"
+	FaultReasonUnknown       = "InstanceBuildFailed"
+
+	// FaultReasonPortBindingFailed is not a Nova fault, the server is ACTIVE
+	// but Neutron could not bind some of its ports on its host.
+	FaultReasonPortBindingFailed = "PortBindingFailed"
+)
+
+// errInstanceError is returned by the create poll loop when the server goes to ERROR.
+var errInstanceError = errors.New("instance went to ERROR state")
+
+// classifyFault maps the fault reported by Nova to one of the FaultReason constants.
+func classifyFault(fault servers.Fault) string {
+	text := strings.ToLower(fault.Message + " " + fault.Details)
+
+	switch {
+	case strings.Contains(text, "no valid host"):
+		return FaultReasonNoValidHost
+	case strings.Contains(text, "quota"):
+		return FaultReasonQuotaExceeded
+	case strings.Contains(text, "volume"), strings.Contains(text, "block device"):
+		return FaultReasonVolume
+	case strings.Contains(text, "image"):
+		return FaultReasonImage
+	}
+	return FaultReasonUnknown
+}
+
+// faultMessage returns a human readable description of a Nova fault.
+func faultMessage(fault servers.Fault) string {
+	if fault.Message == "" {
+		return "no fault reported by Nova"
+	}
+	message := fmt.Sprintf("%s (code %d)", fault.Message, fault.Code)
+	if fault.Details != "" {
+		// Details usually hold a full traceback, only keep the last line.
+		details := strings.Split(strings.TrimSpace(fault.Details), "\n")
+		message = fmt.Sprintf("%s: %s", message, strings.TrimSpace(details[len(details)-1]))
+	}
+	return message
+}
+
+// faultMachineError builds the machine error matching a classified fault.
+func faultMachineError(reason string, message string) *apierrors.MachineError {
+	switch reason {
+	case FaultReasonNoValidHost, FaultReasonQuotaExceeded:
+		return &apierrors.MachineError{
+			Reason:  machinev1.InsufficientResourcesMachineError,
+			Message: message,
+		}
+	}
+	return apierrors.CreateMachine("%s", message)
+}
+
+// handleInstanceFault reports a server which went to ERROR during creation on
+// the machine, and deletes the server if the provider spec asks for it. The
+// failure is set on the status of the machine, which the caller persists.
+func (oc *OpenstackClient) handleInstanceFault(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService, instance *clients.Instance) error {
+	reason := classifyFault(instance.Fault)
+	message := fmt.Sprintf("error creating Openstack instance %s: %s: %s", instance.ID, reason, faultMessage(instance.Fault))
+	machineErr := faultMachineError(reason, message)
+
+	oc.eventRecorder.Eventf(machine, corev1.EventTypeWarning, reason, "%s", message)
+
+	if providerSpec.DeleteFailedInstance {
+		if err := machineService.InstanceDelete(instance.ID); err != nil {
+			klog.Errorf("Failed to delete instance %s of machine %s after create failure: %v", instance.ID, machine.Name, err)
+		} else {
+			oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, "DeletedFailedInstance", "Deleted instance %s so that it can be created again", instance.ID)
+		}
+	}
+
+	setCreateFailure(machine, reason, machineErr)
+	return oc.handleMachineError(machine, machineErr, createEventAction)
+}
+
+// setCreateFailure sets a create failure on the machine status.
+func setCreateFailure(machine *machinev1.Machine, reason string, machineErr *apierrors.MachineError) {
+	conditions.Set(machine, conditions.FalseCondition(machinev1.MachineCreated, reason, machinev1.ConditionSeverityError, "%s", machineErr.Message))
+	machine.Status.ErrorReason = &machineErr.Reason
+	machine.Status.ErrorMessage = &machineErr.Message
+}
+
+// patchStatus persists the changes made to the machine status since base in
+// a single patch, as the machine controller does not update the status when
+// Create fails. Failures are only logged as they must not fail the creation.
+func (oc *OpenstackClient) patchStatus(machine *machinev1.Machine, base *machinev1.Machine) {
+	if oc.client == nil || equality.Semantic.DeepEqual(machine.Status, base.Status) {
+		return
+	}
+	if err := oc.client.Status().Patch(context.TODO(), machine, client.MergeFrom(base)); err != nil {
+		klog.Errorf("Failed to update status of machine %s: %v", machine.Name, err)
+	}
+}
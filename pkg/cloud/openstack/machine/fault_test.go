@@ -0,0 +1,168 @@
+package machine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestClassifyFault(t *testing.T) {
+	testCases := []struct {
+		name     string
+		fault    servers.Fault
+		expected string
+	}{
+		{
+			name:     "no valid host",
+			fault:    servers.Fault{Code: 500, Message: "No valid host was found. There are not enough hosts available."},
+			expected: FaultReasonNoValidHost,
+		},
+		{
+			name:     "quota exceeded",
+			fault:    servers.Fault{Code: 403, Message: "Quota exceeded for cores: Requested 8, but already used 96 of 100 cores"},
+			expected: FaultReasonQuotaExceeded,
+		},
+		{
+			name:     "volume failure",
+			fault:    servers.Fault{Code: 500, Message: "Build of instance abc aborted: Volume def did not finish being created even after we waited 3 seconds"},
+			expected: FaultReasonVolume,
+		},
+		{
+			name:     "image failure in details",
+			fault:    servers.Fault{Code: 500, Message: "Build of instance abc aborted", Details: "Traceback:\nImageNotFound: Image 123 could not be found."},
+			expected: FaultReasonImage,
+		},
+		{
+			name:     "unknown fault",
+			fault:    servers.Fault{Code: 500, Message: "Unexpected error while running command."},
+			expected: FaultReasonUnknown,
+		},
+		{
+			name:     "no fault",
+			expected: FaultReasonUnknown,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := classifyFault(tc.fault); got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestFaultMessage(t *testing.T) {
+	fault := servers.Fault{Code: 500, Message: "Build of instance abc aborted", Details: "Traceback:\n  File foo\nImageNotFound: Image 123 could not be found.\n"}
+	expected := "Build of instance abc aborted (code 500): ImageNotFound: Image 123 could not be found."
+	if got := faultMessage(fault); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestFaultMachineError(t *testing.T) {
+	if reason := faultMachineError(FaultReasonNoValidHost, "msg").Reason; reason != machinev1.InsufficientResourcesMachineError {
+		t.Errorf("expected %q, got %q", machinev1.InsufficientResourcesMachineError, reason)
+	}
+	if reason := faultMachineError(FaultReasonImage, "msg").Reason; reason != machinev1.CreateMachineError {
+		t.Errorf("expected %q, got %q", machinev1.CreateMachineError, reason)
+	}
+}
+
+func TestPatchCreateFailureStatus(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := machinev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	machine := &machinev1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "openshift-machine-api"}}
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(machine).Build()
+	oc := &OpenstackClient{client: fakeClient}
+
+	base := machine.DeepCopy()
+	providerStatus := &openstackconfigv1.OpenstackMachineProviderStatus{}
+	oc.recordCreationAttempt(machine, providerStatus, openstackconfigv1.CreationAttempt{AvailabilityZone: "az1", Reason: FaultReasonNoValidHost})
+	setCreateFailure(machine, FaultReasonNoValidHost, faultMachineError(FaultReasonNoValidHost, "msg"))
+	oc.patchStatus(machine, base)
+
+	stored := &machinev1.Machine{}
+	if err := fakeClient.Get(context.TODO(), client.ObjectKeyFromObject(machine), stored); err != nil {
+		t.Fatal(err)
+	}
+	storedStatus, err := openstackconfigv1.MachineStatusFromProviderStatus(stored.Status.ProviderStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(storedStatus.CreationAttempts) != 1 || storedStatus.CreationAttempts[0].AvailabilityZone != "az1" {
+		t.Errorf("expected the creation attempt to be stored, got %+v", storedStatus.CreationAttempts)
+	}
+	if condition := conditions.Get(stored, machinev1.MachineCreated); condition == nil || condition.Reason != FaultReasonNoValidHost {
+		t.Errorf("expected the MachineCreated condition to be stored, got %+v", condition)
+	}
+	if stored.Status.ErrorMessage == nil || *stored.Status.ErrorMessage != "msg" {
+		t.Errorf("expected the error message to be stored, got %v", stored.Status.ErrorMessage)
+	}
+}
+
+// statusSubresourceClient emulates the status subresource of machines, whose
+// updates keep the stored status and return it.
+type statusSubresourceClient struct {
+	client.Client
+}
+
+func (c statusSubresourceClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	machine, ok := obj.(*machinev1.Machine)
+	if !ok {
+		return c.Client.Update(ctx, obj, opts...)
+	}
+	stored := &machinev1.Machine{}
+	if err := c.Client.Get(ctx, client.ObjectKeyFromObject(machine), stored); err != nil {
+		return err
+	}
+	stored.Status.DeepCopyInto(&machine.Status)
+	return c.Client.Update(ctx, machine, opts...)
+}
+
+func TestHandleInstanceFaultStatus(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := machinev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	machine := &machinev1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "openshift-machine-api"}}
+	fakeClient := statusSubresourceClient{fake.NewClientBuilder().WithScheme(scheme).WithObjects(machine).Build()}
+	oc := &OpenstackClient{client: fakeClient, eventRecorder: record.NewFakeRecorder(10)}
+
+	instance := &clients.Instance{Server: servers.Server{
+		ID:    "instance",
+		Fault: servers.Fault{Code: 500, Message: "No valid host was found."},
+	}}
+
+	base := machine.DeepCopy()
+	if err := oc.handleInstanceFault(machine, &openstackconfigv1.OpenstackProviderSpec{}, nil, instance); err == nil {
+		t.Fatal("expected an error")
+	}
+	oc.patchStatus(machine, base)
+
+	stored := &machinev1.Machine{}
+	if err := fakeClient.Get(context.TODO(), client.ObjectKeyFromObject(machine), stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Annotations[MachineInstanceStateAnnotationName] != ErrorState {
+		t.Errorf("expected the machine to be annotated as failed, got %v", stored.Annotations)
+	}
+	if condition := conditions.Get(stored, machinev1.MachineCreated); condition == nil || condition.Reason != FaultReasonNoValidHost {
+		t.Errorf("expected the MachineCreated condition to be stored, got %+v", condition)
+	}
+	if stored.Status.ErrorReason == nil || *stored.Status.ErrorReason != machinev1.InsufficientResourcesMachineError {
+		t.Errorf("expected the error reason to be stored, got %v", stored.Status.ErrorReason)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+// getDNSAddresses returns the hostname and DNS addresses of the machine, from
+// its hostname template and the DNS assignments of the ports of its server.
+// The hostname template and the ports are only used if Neutron has the
+// dns-integration extension, otherwise the hostname is the machine name.
+func getDNSAddresses(machine *machinev1.Machine, machineService *clients.InstanceService, instancePorts []clients.InstancePort, clusterInfraName string) ([]corev1.NodeAddress, error) {
+	machineSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+	dnsEnabled, err := machineService.PortDNSEnabled(machineSpec)
+	if err != nil {
+		return nil, err
+	}
+	if !dnsEnabled {
+		return dnsAddresses(machine.Name, machine.Name, nil), nil
+	}
+
+	hostname, err := clients.Hostname(machineSpec, clusterInfraName, machine.Name)
+	if err != nil {
+		return nil, err
+	}
+	return dnsAddresses(machine.Name, hostname, instancePorts), nil
+}
+
+// dnsAddresses returns the hostname of the machine, and its DNS names: the
+// FQDNs assigned by Neutron to its ports, its hostname, and its name, which
+// is the name of its node unless the node takes its hostname from DHCP.
+func dnsAddresses(name string, hostname string, instancePorts []clients.InstancePort) []corev1.NodeAddress {
+	addresses := []corev1.NodeAddress{{
+		Type:    corev1.NodeHostName,
+		Address: hostname,
+	}}
+
+	var dnsNames []string
+	for _, port := range instancePorts {
+		dnsNames = append(dnsNames, port.DNSNames()...)
+	}
+	dnsNames = append(dnsNames, hostname, name)
+
+	seen := map[string]bool{}
+	for _, dnsName := range dnsNames {
+		if seen[dnsName] {
+			continue
+		}
+		seen[dnsName] = true
+		addresses = append(addresses, corev1.NodeAddress{
+			Type:    corev1.NodeInternalDNS,
+			Address: dnsName,
+		})
+	}
+	return addresses
+}
@@ -0,0 +1,74 @@
+package machine
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+func TestDNSAddresses(t *testing.T) {
+	var instancePorts []clients.InstancePort
+	if err := json.Unmarshal([]byte(`[
+		{"id": "port1", "dns_assignment": [
+			{"ip_address": "10.0.0.10", "hostname": "node-0", "fqdn": "node-0.example.com."},
+			{"ip_address": "fd00::10", "hostname": "node-0", "fqdn": "node-0.example.com."}
+		]},
+		{"id": "port2", "dns_assignment": [
+			{"ip_address": "192.168.0.10", "hostname": "storage-0", "fqdn": "storage-0.storage.example.com."}
+		]},
+		{"id": "port3"}
+	]`), &instancePorts); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []corev1.NodeAddress{
+		{Type: corev1.NodeHostName, Address: "node-0"},
+		{Type: corev1.NodeInternalDNS, Address: "node-0.example.com"},
+		{Type: corev1.NodeInternalDNS, Address: "storage-0.storage.example.com"},
+		{Type: corev1.NodeInternalDNS, Address: "node-0"},
+		{Type: corev1.NodeInternalDNS, Address: "worker-0"},
+	}
+	if addresses := dnsAddresses("worker-0", "node-0", instancePorts); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected %v, got %v", expected, addresses)
+	}
+
+	expected = []corev1.NodeAddress{
+		{Type: corev1.NodeHostName, Address: "worker-0"},
+		{Type: corev1.NodeInternalDNS, Address: "worker-0"},
+	}
+	if addresses := dnsAddresses("worker-0", "worker-0", nil); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected %v, got %v", expected, addresses)
+	}
+}
+
+func TestGetDNSAddressesWithoutDNSSettings(t *testing.T) {
+	machine := &machinev1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-0"},
+		Spec: machinev1.MachineSpec{ProviderSpec: machinev1.ProviderSpec{
+			Value: &runtime.RawExtension{Raw: []byte(`{"flavor": "m1.large"}`)},
+		}},
+	}
+	instancePorts := []clients.InstancePort{{PortDNSExt: clients.PortDNSExt{
+		DNSAssignment: []map[string]string{{"fqdn": "host-10-0-0-10.openstacklocal."}},
+	}}}
+
+	// Neutron is not queried without DNS settings, and the FQDNs of the
+	// ports are ignored.
+	addresses, err := getDNSAddresses(machine, nil, instancePorts, "ocp")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []corev1.NodeAddress{
+		{Type: corev1.NodeHostName, Address: "worker-0"},
+		{Type: corev1.NodeInternalDNS, Address: "worker-0"},
+	}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected %v, got %v", expected, addresses)
+	}
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+const (
+	// IgnitionObjectAnnotationKey records the Swift container and object
+	// holding the Ignition config of a machine, when it was too large to be
+	// passed as user data.
+	IgnitionObjectAnnotationKey = "openstack-ignition-object"
+
+	// maxUserDataSize is the largest base64 encoded user data accepted by Nova.
+	maxUserDataSize = 65535
+
+	// ignitionURLTTL is how long an uploaded Ignition config can be downloaded.
+	ignitionURLTTL = 24 * time.Hour
+
+	// machineNameIgnitionPath is the file holding the name of the machine, so
+	// that it is known on the node even once its hostname is changed.
+	machineNameIgnitionPath = "/etc/openshift/machine-name"
+
+	defaultIgnitionFileMode = 0644
+)
+
+// ignitionFile is a file merged into an Ignition config.
+type ignitionFile struct {
+	path     string
+	mode     int
+	contents []byte
+}
+
+// ignitionUserData merges the hostname, the machine name and the files of the
+// provider spec into the Ignition config of the user data. The provider ID is
+// not known yet, Nova only assigns the server ID once the server is created.
+// A config too large to be passed as user data is uploaded to Swift and
+// replaced by a config pointing to it.
+func (oc *OpenstackClient) ignitionUserData(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService, clusterInfraName string, userData string) (string, error) {
+	files := []ignitionFile{
+		{
+			path:     "/etc/hostname",
+			mode:     defaultIgnitionFileMode,
+			contents: []byte(machine.Name + "\n"),
+		},
+		{
+			path:     machineNameIgnitionPath,
+			mode:     defaultIgnitionFileMode,
+			contents: []byte(machine.Name + "\n"),
+		},
+	}
+	for _, file := range providerSpec.IgnitionFiles {
+		secret, err := oc.params.KubeClient.CoreV1().Secrets(machine.Namespace).Get(context.TODO(), file.SecretKeyRef.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("could not get secret %s of file %s: %v", file.SecretKeyRef.Name, file.Path, err)
+		}
+		contents, ok := secret.Data[file.SecretKeyRef.Key]
+		if !ok {
+			if file.SecretKeyRef.Optional != nil && *file.SecretKeyRef.Optional {
+				continue
+			}
+			return "", fmt.Errorf("secret %s of file %s did not contain key %s", file.SecretKeyRef.Name, file.Path, file.SecretKeyRef.Key)
+		}
+
+		mode := defaultIgnitionFileMode
+		if file.Mode != nil {
+			mode = *file.Mode
+		}
+		files = append(files, ignitionFile{path: file.Path, mode: mode, contents: contents})
+	}
+
+	config, version, err := mergeIgnitionFiles([]byte(userData), files)
+	if err != nil {
+		return "", err
+	}
+	if base64.StdEncoding.EncodedLen(len(config)) <= maxUserDataSize {
+		return string(config), nil
+	}
+
+	containerName := clusterInfraName + "-ignition"
+	klog.Infof("Ignition config of machine %s is %d bytes, uploading it to container %s", machine.Name, len(config), containerName)
+	url, err := machineService.UploadUserData(containerName, machine.Name, config, ignitionURLTTL)
+	if err != nil {
+		return "", err
+	}
+
+	object := containerName + "/" + machine.Name
+	if machine.Annotations[IgnitionObjectAnnotationKey] != object {
+		if machine.Annotations == nil {
+			machine.Annotations = make(map[string]string)
+		}
+		machine.Annotations[IgnitionObjectAnnotationKey] = object
+		// Record the object right away, so that it is deleted with the
+		// machine even if the instance is never created.
+		if err := oc.client.Update(context.TODO(), machine); err != nil {
+			return "", err
+		}
+	}
+
+	pointer, err := ignitionPointerConfig(config, version, url)
+	if err != nil {
+		return "", err
+	}
+	return string(pointer), nil
+}
+
+// deleteIgnitionObject deletes the Swift object holding the Ignition config
+// of the machine, if it had to be uploaded.
+func deleteIgnitionObject(machine *machinev1.Machine, machineService *clients.InstanceService) error {
+	object, ok := machine.Annotations[IgnitionObjectAnnotationKey]
+	if !ok {
+		return nil
+	}
+	parts := strings.SplitN(object, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid %s annotation %q", IgnitionObjectAnnotationKey, object)
+	}
+	return machineService.DeleteUserData(parts[0], parts[1])
+}
+
+// mergeIgnitionFiles adds files to an Ignition v2 or v3 config, replacing the
+// files of the config with the same path. It returns the merged config and
+// its version.
+func mergeIgnitionFiles(userData []byte, files []ignitionFile) ([]byte, string, error) {
+	var config map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(userData))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, "", fmt.Errorf("user data is not an Ignition config: %v", err)
+	}
+
+	ignition, _ := config["ignition"].(map[string]interface{})
+	version, _ := ignition["version"].(string)
+	if !strings.HasPrefix(version, "2.") && !strings.HasPrefix(version, "3.") {
+		return nil, "", fmt.Errorf("unsupported Ignition config version %q", version)
+	}
+	v2 := strings.HasPrefix(version, "2.")
+
+	storage, _ := config["storage"].(map[string]interface{})
+	if storage == nil {
+		storage = map[string]interface{}{}
+		config["storage"] = storage
+	}
+
+	replaced := map[string]bool{}
+	for _, file := range files {
+		replaced[file.path] = true
+	}
+	existing, _ := storage["files"].([]interface{})
+	merged := make([]interface{}, 0, len(existing)+len(files))
+	for _, entry := range existing {
+		if file, ok := entry.(map[string]interface{}); ok {
+			if path, _ := file["path"].(string); replaced[path] {
+				continue
+			}
+		}
+		merged = append(merged, entry)
+	}
+
+	for _, file := range files {
+		entry := map[string]interface{}{
+			"path": file.path,
+			"mode": file.mode,
+			"contents": map[string]interface{}{
+				"source": "data:;base64," + base64.StdEncoding.EncodeToString(file.contents),
+			},
+		}
+		if v2 {
+			entry["filesystem"] = "root"
+		} else {
+			entry["overwrite"] = true
+		}
+		merged = append(merged, entry)
+	}
+	storage["files"] = merged
+
+	out, err := json.Marshal(config)
+	if err != nil {
+		return nil, "", err
+	}
+	return out, version, nil
+}
+
+// ignitionPointerConfig returns a config replaced by the one at the given URL.
+// The certificate authorities of the original config are kept to download it.
+func ignitionPointerConfig(config []byte, version string, url string) ([]byte, error) {
+	var original struct {
+		Ignition struct {
+			Security json.RawMessage `json:"security,omitempty"`
+		} `json:"ignition"`
+	}
+	if err := json.Unmarshal(config, &original); err != nil {
+		return nil, err
+	}
+
+	ignition := map[string]interface{}{
+		"version": version,
+		"config": map[string]interface{}{
+			"replace": map[string]interface{}{
+				"source": url,
+			},
+		},
+	}
+	if len(original.Ignition.Security) > 0 {
+		ignition["security"] = original.Ignition.Security
+	}
+	return json.Marshal(map[string]interface{}{"ignition": ignition})
+}
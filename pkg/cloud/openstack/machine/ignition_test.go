@@ -0,0 +1,102 @@
+package machine
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestMergeIgnitionFiles(t *testing.T) {
+	files := []ignitionFile{
+		{path: "/etc/hostname", mode: 420, contents: []byte("worker-0\n")},
+		{path: "/etc/chrony.conf", mode: 384, contents: []byte("server ntp.example.com\n")},
+	}
+
+	testCases := []struct {
+		name          string
+		userData      string
+		expectedFiles string
+		expectedErr   string
+	}{
+		{
+			name:          "v3 pointer config",
+			userData:      `{"ignition":{"version":"3.2.0","config":{"merge":[{"source":"https://api-int.example.com:22623/config/worker"}]}}}`,
+			expectedFiles: `[{"contents":{"source":"data:;base64,d29ya2VyLTAK"},"mode":420,"overwrite":true,"path":"/etc/hostname"},{"contents":{"source":"data:;base64,c2VydmVyIG50cC5leGFtcGxlLmNvbQo="},"mode":384,"overwrite":true,"path":"/etc/chrony.conf"}]`,
+		},
+		{
+			name:          "v2 config with files",
+			userData:      `{"ignition":{"version":"2.2.0"},"storage":{"files":[{"filesystem":"root","path":"/etc/hostname","mode":420},{"filesystem":"root","path":"/etc/motd","mode":420}]}}`,
+			expectedFiles: `[{"filesystem":"root","mode":420,"path":"/etc/motd"},{"contents":{"source":"data:;base64,d29ya2VyLTAK"},"filesystem":"root","mode":420,"path":"/etc/hostname"},{"contents":{"source":"data:;base64,c2VydmVyIG50cC5leGFtcGxlLmNvbQo="},"filesystem":"root","mode":384,"path":"/etc/chrony.conf"}]`,
+		},
+		{
+			name:        "unsupported version",
+			userData:    `{"ignition":{"version":"1.0.0"}}`,
+			expectedErr: `unsupported Ignition config version "1.0.0"`,
+		},
+		{
+			name:        "not json",
+			userData:    "#cloud-config\n",
+			expectedErr: "user data is not an Ignition config",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config, _, err := mergeIgnitionFiles([]byte(tc.userData), files)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var merged struct {
+				Storage struct {
+					Files json.RawMessage `json:"files"`
+				} `json:"storage"`
+			}
+			if err := json.Unmarshal(config, &merged); err != nil {
+				t.Fatalf("invalid merged config: %v", err)
+			}
+			if string(merged.Storage.Files) != tc.expectedFiles {
+				t.Errorf("expected files %s, got %s", tc.expectedFiles, merged.Storage.Files)
+			}
+		})
+	}
+}
+
+func TestIgnitionPointerConfig(t *testing.T) {
+	config := `{"ignition":{"version":"3.1.0","security":{"tls":{"certificateAuthorities":[{"source":"data:,ca"}]}}},"storage":{}}`
+
+	pointer, err := ignitionPointerConfig([]byte(config), "3.1.0", "https://swift.example.com/v1/AUTH_p/c/o?temp_url_sig=s")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `{"ignition":{"config":{"replace":{"source":"https://swift.example.com/v1/AUTH_p/c/o?temp_url_sig=s"}},"security":{"tls":{"certificateAuthorities":[{"source":"data:,ca"}]}},"version":"3.1.0"}}`
+	if string(pointer) != expected {
+		t.Errorf("expected %s, got %s", expected, pointer)
+	}
+}
+
+func TestIgnitionUserDataMachineFiles(t *testing.T) {
+	oc := &OpenstackClient{}
+	machine := &machinev1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "worker-0"}}
+
+	userData, err := oc.ignitionUserData(machine, &openstackconfigv1.OpenstackProviderSpec{}, nil, "cluster", `{"ignition":{"version":"3.2.0"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/etc/hostname", machineNameIgnitionPath} {
+		if !strings.Contains(userData, `"path":"`+path+`"`) {
+			t.Errorf("expected %s in %s", path, userData)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	apierrors "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/openshift/machine-api-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MaxRecordedCreationAttempts is the number of creation attempts kept in the
+// machine provider status.
+const MaxRecordedCreationAttempts = 10
+
+// maxInjectedFilePathLength is the default limit of Nova on the paths of
+// injected files.
+const maxInjectedFilePathLength = 255
+
+// creationCandidate is an availability zone and flavor combination to build a server with.
+type creationCandidate struct {
+	availabilityZone string
+	flavor           string
+}
+
+// creationCandidates returns the combinations to try in order, starting with
+// the availability zone and flavor of the provider spec.
+func creationCandidates(providerSpec *openstackconfigv1.OpenstackProviderSpec) []creationCandidate {
+	zones := append([]string{providerSpec.AvailabilityZone}, providerSpec.FallbackAvailabilityZones...)
+	flavors := append([]string{providerSpec.Flavor}, providerSpec.FallbackFlavors...)
+
+	candidates := make([]creationCandidate, 0, len(zones)*len(flavors))
+	for _, flavor := range flavors {
+		for _, zone := range zones {
+			candidates = append(candidates, creationCandidate{availabilityZone: zone, flavor: flavor})
+		}
+	}
+	return candidates
+}
+
+// candidateSpec returns the provider spec to build a server with for the
+// candidate. A root volume in the availability zone of the server follows the
+// server to the availability zone of the candidate.
+func candidateSpec(providerSpec *openstackconfigv1.OpenstackProviderSpec, candidate creationCandidate) *openstackconfigv1.OpenstackProviderSpec {
+	spec := *providerSpec
+	spec.AvailabilityZone = candidate.availabilityZone
+	spec.Flavor = candidate.flavor
+	if spec.RootVolume != nil && spec.RootVolume.Zone != "" && spec.RootVolume.Zone == providerSpec.AvailabilityZone {
+		rootVolume := *spec.RootVolume
+		rootVolume.Zone = candidate.availabilityZone
+		spec.RootVolume = &rootVolume
+	}
+	return &spec
+}
+
+// replacedInstanceIDs returns the IDs of the servers of the failed creation
+// attempts which were followed by another attempt. These servers are deleted
+// without waiting for them to be gone, so they may still be listed along with
+// the server of the machine.
+func replacedInstanceIDs(attempts []openstackconfigv1.CreationAttempt) map[string]bool {
+	ids := make(map[string]bool)
+	for i, attempt := range attempts {
+		if i < len(attempts)-1 && attempt.Reason != "" && attempt.InstanceID != "" {
+			ids[attempt.InstanceID] = true
+		}
+	}
+	return ids
+}
+
+// isCapacityFault returns true for faults which may not happen in another
+// availability zone or with another flavor. Ports may fail to bind when the
+// host of the server has no free SR-IOV virtual function, or is not connected
+// to their physical network.
+func isCapacityFault(reason string) bool {
+	return reason == FaultReasonNoValidHost || reason == FaultReasonPortBindingFailed
+}
+
+// createInstance builds the server of the machine, retrying with the fallback
+// availability zones and flavors of the provider spec on capacity failures.
+// Errors are already reported on the machine when they are returned. The
+// creation attempts are persisted before each retry, so that the servers they
+// replace are known if the controller restarts, and along with the failure
+// once createInstance returns.
+func (oc *OpenstackClient) createInstance(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService, clusterName string, clusterInfraName string, clusterSpec *openstackconfigv1.OpenstackClusterProviderSpec, userData string) (*clients.Instance, error) {
+	statusBase := machine.DeepCopy()
+	persistStatus := func() {
+		oc.patchStatus(machine, statusBase)
+		statusBase = machine.DeepCopy()
+	}
+	defer persistStatus()
+
+	providerStatus, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return nil, oc.handleMachineError(machine, apierrors.CreateMachine(
+			"Cannot unmarshal providerStatus field: %v", err), createEventAction)
+	}
+
+	personality, err := oc.injectedFiles(machine, providerSpec)
+	if err != nil {
+		return nil, oc.handleMachineError(machine, apierrors.InvalidMachineConfiguration(
+			"Cannot inject files: %v", err), createEventAction)
+	}
+
+	providerSpec, err = oc.allocateIPs(machine, providerSpec)
+	if err != nil {
+		return nil, oc.handleMachineError(machine, apierrors.CreateMachine(
+			"Cannot allocate IP addresses: %v", err), createEventAction)
+	}
+
+	candidates := creationCandidates(providerSpec)
+	for i, candidate := range candidates {
+		spec := candidateSpec(providerSpec, candidate)
+
+		attempt := openstackconfigv1.CreationAttempt{
+			Time:             metav1.Now(),
+			AvailabilityZone: candidate.availabilityZone,
+			Flavor:           candidate.flavor,
+		}
+
+		instance, err := machineService.InstanceCreate(clusterName, clusterInfraName, machine.Name, clusterSpec, spec, userData, spec.KeyName, personality, oc.params.ConfigClient)
+		if err != nil {
+			attempt.Reason = string(machinev1.CreateMachineError)
+			attempt.Message = err.Error()
+			oc.recordCreationAttempt(machine, providerStatus, attempt)
+			return nil, oc.handleMachineError(machine, apierrors.CreateMachine(
+				"error creating Openstack instance: %v", err), createEventAction)
+		}
+		attempt.InstanceID = instance.ID
+
+		instance, err = oc.waitForInstance(machineService, instance)
+		if err == errInstanceError {
+			attempt.Reason = classifyFault(instance.Fault)
+			attempt.Message = faultMessage(instance.Fault)
+			oc.recordCreationAttempt(machine, providerStatus, attempt)
+
+			if !isCapacityFault(attempt.Reason) || i == len(candidates)-1 {
+				return nil, oc.handleInstanceFault(machine, spec, machineService, instance)
+			}
+
+			next := candidates[i+1]
+			oc.eventRecorder.Eventf(machine, corev1.EventTypeWarning, "RetryingCreate",
+				"Instance %s failed to build in availability zone %q with flavor %q: %s. Retrying in availability zone %q with flavor %q",
+				instance.ID, candidate.availabilityZone, candidate.flavor, attempt.Reason, next.availabilityZone, next.flavor)
+			persistStatus()
+			if err := oc.deleteFailedInstance(machineService, instance.ID); err != nil {
+				return nil, oc.handleMachineError(machine, apierrors.CreateMachine(
+					"error deleting failed Openstack instance %s: %v", instance.ID, err), createEventAction)
+			}
+			continue
+		}
+		if err != nil {
+			attempt.Reason = string(machinev1.CreateMachineError)
+			attempt.Message = err.Error()
+			oc.recordCreationAttempt(machine, providerStatus, attempt)
+			return nil, oc.handleMachineError(machine, apierrors.CreateMachine(
+				"error creating Openstack instance: %v", err), createEventAction)
+		}
+
+		if err := checkPortBindings(machineService, instance.ID); err != nil {
+			attempt.Reason = FaultReasonPortBindingFailed
+			attempt.Message = err.Error()
+			oc.recordCreationAttempt(machine, providerStatus, attempt)
+			persistStatus()
+			if err := oc.deleteFailedInstance(machineService, instance.ID); err != nil {
+				return nil, oc.handleMachineError(machine, apierrors.CreateMachine(
+					"error deleting failed Openstack instance %s: %v", instance.ID, err), createEventAction)
+			}
+			if i == len(candidates)-1 {
+				return nil, oc.handleMachineError(machine, apierrors.CreateMachine(
+					"Instance %s was deleted: %v", instance.ID, err), createEventAction)
+			}
+
+			next := candidates[i+1]
+			oc.eventRecorder.Eventf(machine, corev1.EventTypeWarning, "RetryingCreate",
+				"Instance %s failed to bind its ports in availability zone %q with flavor %q: %v. Retrying in availability zone %q with flavor %q",
+				instance.ID, candidate.availabilityZone, candidate.flavor, err, next.availabilityZone, next.flavor)
+			continue
+		}
+
+		oc.recordCreationAttempt(machine, providerStatus, attempt)
+		return instance, nil
+	}
+
+	// Not reachable, creationCandidates always returns at least one candidate
+	return nil, fmt.Errorf("no availability zone and flavor to create the instance with")
+}
+
+// waitForInstance waits for a new server to become ACTIVE. It returns
+// errInstanceError along with the server if the server goes to ERROR.
+func (oc *OpenstackClient) waitForInstance(machineService *clients.InstanceService, instance *clients.Instance) (*clients.Instance, error) {
+	instanceCreateTimeout := getTimeout("CLUSTER_API_OPENSTACK_INSTANCE_CREATE_TIMEOUT", TimeoutInstanceCreate)
+	instanceCreateTimeout = instanceCreateTimeout * time.Minute
+
+	var lastErr error
+	err := util.PollImmediate(RetryIntervalInstanceStatus, instanceCreateTimeout, func() (bool, error) {
+		current, err := machineService.GetInstance(instance.ID)
+		if err != nil {
+			lastErr = err
+			return false, nil
+		}
+		instance, lastErr = current, nil
+		if instance.Status == instanceStatusError {
+			return false, errInstanceError
+		}
+		return instance.Status == instanceStatusActive, nil
+	})
+	if err == errInstanceError {
+		return instance, err
+	}
+	if err != nil {
+		if lastErr != nil {
+			return instance, fmt.Errorf("%v, last error: %v", err, lastErr)
+		}
+		return instance, fmt.Errorf("%v, instance %s is in %s state", err, instance.ID, instance.Status)
+	}
+	return instance, nil
+}
+
+// deleteFailedInstance deletes a server before another one is created for the
+// machine. It does not wait for the server to be gone: instanceExists tells
+// the servers apart with the creation attempts of the provider status.
+func (oc *OpenstackClient) deleteFailedInstance(machineService *clients.InstanceService, instanceID string) error {
+	return machineService.InstanceDelete(instanceID)
+}
+
+// recordCreationAttempt adds an attempt to the machine provider status.
+// Failures are only logged as they must not fail the creation.
+func (oc *OpenstackClient) recordCreationAttempt(machine *machinev1.Machine, providerStatus *openstackconfigv1.OpenstackMachineProviderStatus, attempt openstackconfigv1.CreationAttempt) {
+	providerStatus.CreationAttempts = append(providerStatus.CreationAttempts, attempt)
+	if len(providerStatus.CreationAttempts) > MaxRecordedCreationAttempts {
+		providerStatus.CreationAttempts = providerStatus.CreationAttempts[len(providerStatus.CreationAttempts)-MaxRecordedCreationAttempts:]
+	}
+
+	rawStatus, err := openstackconfigv1.EncodeMachineStatus(providerStatus)
+	if err != nil {
+		klog.Errorf("Failed to encode provider status of machine %s: %v", machine.Name, err)
+		return
+	}
+	machine.Status.ProviderStatus = rawStatus
+}
+
+// patchProviderStatus sets the provider status of the machine.
+func (oc *OpenstackClient) patchProviderStatus(machine *machinev1.Machine, providerStatus *openstackconfigv1.OpenstackMachineProviderStatus) error {
+	rawStatus, err := openstackconfigv1.EncodeMachineStatus(providerStatus)
+	if err != nil {
+		return err
+	}
+
+	statusPatch := client.MergeFrom(machine.DeepCopy())
+	machine.Status.ProviderStatus = rawStatus
+	return oc.client.Status().Patch(context.TODO(), machine, statusPatch)
+}
+
+// injectedFiles returns the files of the provider spec injected in the server
+// by Nova.
+func (oc *OpenstackClient) injectedFiles(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec) (servers.Personality, error) {
+	var personality servers.Personality
+	for _, file := range providerSpec.InjectedFiles {
+		if !strings.HasPrefix(file.Path, "/") || len(file.Path) > maxInjectedFilePathLength {
+			return nil, fmt.Errorf("path %q of injected file must be absolute and at most %d characters long", file.Path, maxInjectedFilePathLength)
+		}
+
+		secret, err := oc.params.KubeClient.CoreV1().Secrets(machine.Namespace).Get(context.TODO(), file.SecretKeyRef.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not get secret %s of file %s: %v", file.SecretKeyRef.Name, file.Path, err)
+		}
+		contents, ok := secret.Data[file.SecretKeyRef.Key]
+		if !ok {
+			if file.SecretKeyRef.Optional != nil && *file.SecretKeyRef.Optional {
+				continue
+			}
+			return nil, fmt.Errorf("secret %s of file %s did not contain key %s", file.SecretKeyRef.Name, file.Path, file.SecretKeyRef.Key)
+		}
+		personality = append(personality, &servers.File{Path: file.Path, Contents: contents})
+	}
+	return personality, nil
+}
@@ -0,0 +1,162 @@
+package machine
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	apierrors "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCreationCandidates(t *testing.T) {
+	testCases := []struct {
+		name     string
+		spec     openstackconfigv1.OpenstackProviderSpec
+		expected []creationCandidate
+	}{
+		{
+			name:     "no fallbacks",
+			spec:     openstackconfigv1.OpenstackProviderSpec{AvailabilityZone: "az1", Flavor: "m1"},
+			expected: []creationCandidate{{"az1", "m1"}},
+		},
+		{
+			name: "fallback zones are tried before fallback flavors",
+			spec: openstackconfigv1.OpenstackProviderSpec{
+				AvailabilityZone:          "az1",
+				Flavor:                    "m1",
+				FallbackAvailabilityZones: []string{"az2"},
+				FallbackFlavors:           []string{"m2"},
+			},
+			expected: []creationCandidate{{"az1", "m1"}, {"az2", "m1"}, {"az1", "m2"}, {"az2", "m2"}},
+		},
+		{
+			name: "fallback flavors only",
+			spec: openstackconfigv1.OpenstackProviderSpec{
+				Flavor:          "m1",
+				FallbackFlavors: []string{"m2", "m3"},
+			},
+			expected: []creationCandidate{{"", "m1"}, {"", "m2"}, {"", "m3"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := creationCandidates(&tc.spec); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestCandidateSpec(t *testing.T) {
+	testCases := []struct {
+		name         string
+		rootVolume   *openstackconfigv1.RootVolume
+		expectedZone string
+	}{
+		{name: "no root volume"},
+		{name: "root volume in the zone of the server", rootVolume: &openstackconfigv1.RootVolume{Zone: "az1"}, expectedZone: "az2"},
+		{name: "root volume in another zone", rootVolume: &openstackconfigv1.RootVolume{Zone: "nova"}, expectedZone: "nova"},
+		{name: "root volume in the default zone", rootVolume: &openstackconfigv1.RootVolume{}, expectedZone: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			providerSpec := &openstackconfigv1.OpenstackProviderSpec{AvailabilityZone: "az1", Flavor: "m1", RootVolume: tc.rootVolume}
+			spec := candidateSpec(providerSpec, creationCandidate{availabilityZone: "az2", flavor: "m2"})
+			if spec.AvailabilityZone != "az2" || spec.Flavor != "m2" {
+				t.Errorf("expected availability zone az2 and flavor m2, got %q and %q", spec.AvailabilityZone, spec.Flavor)
+			}
+			if tc.rootVolume == nil {
+				if spec.RootVolume != nil {
+					t.Errorf("expected no root volume, got %+v", spec.RootVolume)
+				}
+				return
+			}
+			if spec.RootVolume.Zone != tc.expectedZone {
+				t.Errorf("expected root volume zone %q, got %q", tc.expectedZone, spec.RootVolume.Zone)
+			}
+			if providerSpec.RootVolume.Zone != tc.rootVolume.Zone {
+				t.Errorf("expected the provider spec to be left alone")
+			}
+		})
+	}
+}
+
+func TestReplacedInstanceIDs(t *testing.T) {
+	attempts := []openstackconfigv1.CreationAttempt{
+		{InstanceID: "first", Reason: FaultReasonNoValidHost},
+		{Reason: string(machinev1.CreateMachineError)},
+		{InstanceID: "second", Reason: FaultReasonPortBindingFailed},
+		{InstanceID: "last", Reason: FaultReasonNoValidHost},
+	}
+	expected := map[string]bool{"first": true, "second": true}
+	if got := replacedInstanceIDs(attempts); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestCreationAttemptsPersistedOnFailure(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := machinev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	machine := &machinev1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "openshift-machine-api"}}
+	fakeClient := statusSubresourceClient{fake.NewClientBuilder().WithScheme(scheme).WithObjects(machine).Build()}
+	oc := &OpenstackClient{client: fakeClient, eventRecorder: record.NewFakeRecorder(10)}
+
+	base := machine.DeepCopy()
+	providerStatus := &openstackconfigv1.OpenstackMachineProviderStatus{}
+	oc.recordCreationAttempt(machine, providerStatus, openstackconfigv1.CreationAttempt{InstanceID: "first", Reason: FaultReasonNoValidHost})
+	oc.recordCreationAttempt(machine, providerStatus, openstackconfigv1.CreationAttempt{Reason: string(machinev1.CreateMachineError)})
+	if err := oc.handleMachineError(machine, apierrors.CreateMachine("error creating Openstack instance"), createEventAction); err == nil {
+		t.Fatal("expected an error")
+	}
+	oc.patchStatus(machine, base)
+
+	stored := &machinev1.Machine{}
+	if err := fakeClient.Get(context.TODO(), client.ObjectKeyFromObject(machine), stored); err != nil {
+		t.Fatal(err)
+	}
+	storedStatus, err := openstackconfigv1.MachineStatusFromProviderStatus(stored.Status.ProviderStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{"first": true}
+	if got := replacedInstanceIDs(storedStatus.CreationAttempts); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestIsCapacityFault(t *testing.T) {
+	for reason, expected := range map[string]bool{
+		FaultReasonNoValidHost:   true,
+		FaultReasonQuotaExceeded: false,
+		FaultReasonImage:         false,
+		FaultReasonUnknown:       false,
+	} {
+		if got := isCapacityFault(reason); got != expected {
+			t.Errorf("isCapacityFault(%q): expected %v, got %v", reason, expected, got)
+		}
+	}
+}
+
+func TestInjectedFilesInvalidPath(t *testing.T) {
+	oc := &OpenstackClient{}
+	for _, path := range []string{"etc/motd", "/" + strings.Repeat("a", 255)} {
+		providerSpec := &openstackconfigv1.OpenstackProviderSpec{
+			InjectedFiles: []openstackconfigv1.InjectedFile{{Path: path}},
+		}
+		if _, err := oc.injectedFiles(&machinev1.Machine{}, providerSpec); err == nil {
+			t.Errorf("Expected an error for path %q", path)
+		}
+	}
+}
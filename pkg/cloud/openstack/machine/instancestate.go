@@ -0,0 +1,201 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+const (
+	// InstanceReadyCondition reports whether the server of a machine is ACTIVE
+	InstanceReadyCondition machinev1.ConditionType = "InstanceReady"
+
+	// InstanceRemediationAttemptsAnnotationName as annotation name for the number of
+	// remediation attempts made since the server left the ACTIVE state
+	InstanceRemediationAttemptsAnnotationName = "machine.openshift.io/instance-remediation-attempts"
+
+	// InstanceLastRemediationAnnotationName as annotation name for the time of the
+	// last remediation attempt
+	InstanceLastRemediationAnnotationName = "machine.openshift.io/instance-last-remediation"
+
+	DefaultRemediationMaxAttempts = 3
+	DefaultRemediationBackoff     = 60 * time.Second
+)
+
+// Nova server statuses
+const (
+	instanceStatusActive           = "ACTIVE"
+	instanceStatusError            = "ERROR"
+	instanceStatusShutoff          = "SHUTOFF"
+	instanceStatusPaused           = "PAUSED"
+	instanceStatusSuspended        = "SUSPENDED"
+	instanceStatusShelved          = "SHELVED"
+	instanceStatusShelvedOffloaded = "SHELVED_OFFLOADED"
+	instanceStatusMigrating        = "MIGRATING"
+)
+
+type instanceState struct {
+	reason   string
+	severity machinev1.ConditionSeverity
+}
+
+// instanceStates lists the non-ACTIVE server statuses reported on the machine.
+// Transitional statuses such as BUILD or REBOOT are left out on purpose.
+var instanceStates = map[string]instanceState{
+	instanceStatusError:            {reason: "InstanceError", severity: machinev1.ConditionSeverityError},
+	instanceStatusShutoff:          {reason: "InstanceStopped", severity: machinev1.ConditionSeverityWarning},
+	instanceStatusPaused:           {reason: "InstancePaused", severity: machinev1.ConditionSeverityWarning},
+	instanceStatusSuspended:        {reason: "InstanceSuspended", severity: machinev1.ConditionSeverityWarning},
+	instanceStatusShelved:          {reason: "InstanceShelved", severity: machinev1.ConditionSeverityWarning},
+	instanceStatusShelvedOffloaded: {reason: "InstanceShelved", severity: machinev1.ConditionSeverityWarning},
+	instanceStatusMigrating:        {reason: "InstanceMigrating", severity: machinev1.ConditionSeverityInfo},
+}
+
+// instanceStateCondition maps the status of a server to the InstanceReady condition.
+func instanceStateCondition(instance *clients.Instance) *machinev1.Condition {
+	if instance.Status == instanceStatusActive {
+		return conditions.TrueCondition(InstanceReadyCondition)
+	}
+
+	state, ok := instanceStates[instance.Status]
+	if !ok {
+		return conditions.UnknownCondition(InstanceReadyCondition, "InstanceNotReady", "%s", instanceStateMessage(instance))
+	}
+	return conditions.FalseCondition(InstanceReadyCondition, state.reason, state.severity, "%s", instanceStateMessage(instance))
+}
+
+// instanceStateMessage describes the server status, including the Nova fault if there is one.
+func instanceStateMessage(instance *clients.Instance) string {
+	message := fmt.Sprintf("Instance %s is in %s state", instance.ID, instance.Status)
+	if instance.Fault.Message != "" {
+		message = fmt.Sprintf("%s: %s (code %d)", message, instance.Fault.Message, instance.Fault.Code)
+	}
+	return message
+}
+
+// remediationFor returns the configured remediation if it applies to the
+// given server status, and RemediationActionNone otherwise.
+func remediationFor(status string, action openstackconfigv1.RemediationAction) openstackconfigv1.RemediationAction {
+	switch action {
+	case openstackconfigv1.RemediationActionHardReboot:
+		if status == instanceStatusError || status == instanceStatusShutoff {
+			return action
+		}
+	case openstackconfigv1.RemediationActionStart:
+		switch status {
+		case instanceStatusShutoff, instanceStatusPaused, instanceStatusSuspended, instanceStatusShelved, instanceStatusShelvedOffloaded:
+			return action
+		}
+	}
+	return openstackconfigv1.RemediationActionNone
+}
+
+// remediationBackoff returns how long to wait after the given number of attempts
+// before remediating again.
+func remediationBackoff(base time.Duration, attempts int) time.Duration {
+	if attempts <= 0 {
+		return 0
+	}
+	return base * time.Duration(1<<uint(attempts-1))
+}
+
+// reconcileInstanceState reports the server status on the machine and, if the
+// provider spec asks for it, tries to bring the server back to ACTIVE. The
+// condition is persisted with the rest of the status by updateAnnotation, an
+// event is only emitted when it differs from the stored condition.
+func (oc *OpenstackClient) reconcileInstanceState(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, instance *clients.Instance) error {
+	condition := instanceStateCondition(instance)
+	previous := conditions.Get(machine, InstanceReadyCondition)
+	conditions.Set(machine, condition)
+
+	if instance.Status == instanceStatusActive {
+		delete(machine.ObjectMeta.Annotations, InstanceRemediationAttemptsAnnotationName)
+		delete(machine.ObjectMeta.Annotations, InstanceLastRemediationAnnotationName)
+		return nil
+	}
+
+	if _, ok := instanceStates[instance.Status]; !ok {
+		return nil
+	}
+
+	if previous == nil || previous.Reason != condition.Reason || previous.Message != condition.Message {
+		oc.eventRecorder.Eventf(machine, corev1.EventTypeWarning, condition.Reason, "%s", condition.Message)
+	}
+
+	if providerSpec.Remediation == nil {
+		return nil
+	}
+	action := remediationFor(instance.Status, providerSpec.Remediation.Action)
+	if action == openstackconfigv1.RemediationActionNone {
+		return nil
+	}
+
+	maxAttempts := providerSpec.Remediation.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = DefaultRemediationMaxAttempts
+	}
+	backoff := DefaultRemediationBackoff
+	if providerSpec.Remediation.BackoffSeconds != 0 {
+		backoff = time.Duration(providerSpec.Remediation.BackoffSeconds) * time.Second
+	}
+
+	if machine.ObjectMeta.Annotations == nil {
+		machine.ObjectMeta.Annotations = make(map[string]string)
+	}
+	attempts, _ := strconv.Atoi(machine.ObjectMeta.Annotations[InstanceRemediationAttemptsAnnotationName])
+	if attempts >= maxAttempts {
+		klog.V(3).Infof("Not remediating instance %s of machine %s: %d attempts already made", instance.ID, machine.Name, attempts)
+		return nil
+	}
+	if last, err := time.Parse(time.RFC3339, machine.ObjectMeta.Annotations[InstanceLastRemediationAnnotationName]); err == nil {
+		if time.Since(last) < remediationBackoff(backoff, attempts) {
+			return nil
+		}
+	}
+
+	machineService, err := clients.NewInstanceServiceFromMachine(oc.params.KubeClient, machine)
+	if err != nil {
+		return err
+	}
+
+	attempts++
+	machine.ObjectMeta.Annotations[InstanceRemediationAttemptsAnnotationName] = strconv.Itoa(attempts)
+	machine.ObjectMeta.Annotations[InstanceLastRemediationAnnotationName] = time.Now().UTC().Format(time.RFC3339)
+
+	oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, "Remediating", "Attempting %s of instance %s in %s state (attempt %d/%d)", action, instance.ID, instance.Status, attempts, maxAttempts)
+	switch action {
+	case openstackconfigv1.RemediationActionHardReboot:
+		err = machineService.RebootInstance(instance.ID)
+	case openstackconfigv1.RemediationActionStart:
+		err = machineService.StartInstance(instance.ID, instance.Status)
+	}
+	if err != nil {
+		oc.eventRecorder.Eventf(machine, corev1.EventTypeWarning, "RemediationFailed", "%s of instance %s failed: %v", action, instance.ID, err)
+		klog.Errorf("Remediation of instance %s for machine %s failed: %v", instance.ID, machine.Name, err)
+	}
+
+	return nil
+}
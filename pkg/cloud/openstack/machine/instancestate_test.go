@@ -0,0 +1,117 @@
+package machine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+func TestInstanceStateCondition(t *testing.T) {
+	testCases := []struct {
+		name           string
+		server         servers.Server
+		expectedStatus corev1.ConditionStatus
+		expectedReason string
+		expectedSev    machinev1.ConditionSeverity
+		expectedMsg    string
+	}{
+		{
+			name:           "active server",
+			server:         servers.Server{ID: "id", Status: "ACTIVE"},
+			expectedStatus: corev1.ConditionTrue,
+		},
+		{
+			name: "server in error with a fault",
+			server: servers.Server{ID: "id", Status: "ERROR", Fault: servers.Fault{
+				Code:    500,
+				Message: "No valid host was found.",
+			}},
+			expectedStatus: corev1.ConditionFalse,
+			expectedReason: "InstanceError",
+			expectedSev:    machinev1.ConditionSeverityError,
+			expectedMsg:    "Instance id is in ERROR state: No valid host was found. (code 500)",
+		},
+		{
+			name:           "stopped server",
+			server:         servers.Server{ID: "id", Status: "SHUTOFF"},
+			expectedStatus: corev1.ConditionFalse,
+			expectedReason: "InstanceStopped",
+			expectedSev:    machinev1.ConditionSeverityWarning,
+			expectedMsg:    "Instance id is in SHUTOFF state",
+		},
+		{
+			name:           "shelved server",
+			server:         servers.Server{ID: "id", Status: "SHELVED"},
+			expectedStatus: corev1.ConditionFalse,
+			expectedReason: "InstanceShelved",
+			expectedSev:    machinev1.ConditionSeverityWarning,
+			expectedMsg:    "Instance id is in SHELVED state",
+		},
+		{
+			name:           "transitional state",
+			server:         servers.Server{ID: "id", Status: "REBOOT"},
+			expectedStatus: corev1.ConditionUnknown,
+			expectedReason: "InstanceNotReady",
+			expectedMsg:    "Instance id is in REBOOT state",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			condition := instanceStateCondition(&clients.Instance{Server: tc.server})
+			if condition.Type != InstanceReadyCondition {
+				t.Errorf("expected condition type %s, got %s", InstanceReadyCondition, condition.Type)
+			}
+			if condition.Status != tc.expectedStatus {
+				t.Errorf("expected status %s, got %s", tc.expectedStatus, condition.Status)
+			}
+			if condition.Reason != tc.expectedReason {
+				t.Errorf("expected reason %q, got %q", tc.expectedReason, condition.Reason)
+			}
+			if condition.Severity != tc.expectedSev {
+				t.Errorf("expected severity %q, got %q", tc.expectedSev, condition.Severity)
+			}
+			if condition.Message != tc.expectedMsg {
+				t.Errorf("expected message %q, got %q", tc.expectedMsg, condition.Message)
+			}
+		})
+	}
+}
+
+func TestRemediationFor(t *testing.T) {
+	testCases := []struct {
+		status   string
+		action   openstackconfigv1.RemediationAction
+		expected openstackconfigv1.RemediationAction
+	}{
+		{"ERROR", openstackconfigv1.RemediationActionHardReboot, openstackconfigv1.RemediationActionHardReboot},
+		{"SHUTOFF", openstackconfigv1.RemediationActionHardReboot, openstackconfigv1.RemediationActionHardReboot},
+		{"PAUSED", openstackconfigv1.RemediationActionHardReboot, openstackconfigv1.RemediationActionNone},
+		{"ERROR", openstackconfigv1.RemediationActionStart, openstackconfigv1.RemediationActionNone},
+		{"SHELVED", openstackconfigv1.RemediationActionStart, openstackconfigv1.RemediationActionStart},
+		{"SHELVED_OFFLOADED", openstackconfigv1.RemediationActionStart, openstackconfigv1.RemediationActionStart},
+		{"MIGRATING", openstackconfigv1.RemediationActionStart, openstackconfigv1.RemediationActionNone},
+		{"SHUTOFF", "", openstackconfigv1.RemediationActionNone},
+	}
+
+	for _, tc := range testCases {
+		if got := remediationFor(tc.status, tc.action); got != tc.expected {
+			t.Errorf("remediationFor(%q, %q): expected %q, got %q", tc.status, tc.action, tc.expected, got)
+		}
+	}
+}
+
+func TestRemediationBackoff(t *testing.T) {
+	base := 10 * time.Second
+	expected := []time.Duration{0, 10 * time.Second, 20 * time.Second, 40 * time.Second}
+	for attempts, want := range expected {
+		if got := remediationBackoff(base, attempts); got != want {
+			t.Errorf("remediationBackoff after %d attempts: expected %v, got %v", attempts, want, got)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// IPPoolAddressesKey lists the CIDRs and ranges of an IP pool.
+	IPPoolAddressesKey = "addresses"
+
+	// IPPoolExcludeKey lists the CIDRs, ranges and addresses of an IP pool
+	// which are never allocated.
+	IPPoolExcludeKey = "exclude"
+
+	// IPPoolAllocationsKey maps the allocated addresses of an IP pool to
+	// their machine and network, see allocationOwner.
+	IPPoolAllocationsKey = "allocations"
+)
+
+// allocationOwner returns the owner of the address of a network of a machine,
+// so that networks using the same pool get different addresses.
+func allocationOwner(machineName string, networkIndex int) string {
+	return fmt.Sprintf("%s/%d", machineName, networkIndex)
+}
+
+// ipRange is an inclusive range of IP addresses of the same family.
+type ipRange struct {
+	first net.IP
+	last  net.IP
+}
+
+func (r ipRange) contains(ip net.IP) bool {
+	return len(ip) == len(r.first) && bytes.Compare(ip, r.first) >= 0 && bytes.Compare(ip, r.last) <= 0
+}
+
+// normalizeIP returns IPv4 addresses in their 4 bytes form, so that addresses
+// of the same family can be compared.
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// nextIP returns the address following ip.
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// parseIPRanges parses the CIDRs, ranges and single addresses listed in s,
+// separated by new lines or commas. The network and broadcast addresses of
+// IPv4 CIDRs are left out.
+func parseIPRanges(s string) ([]ipRange, error) {
+	var ranges []ipRange
+	for _, entry := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case strings.Contains(entry, "/"):
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, err
+			}
+			first := normalizeIP(ipNet.IP)
+			last := make(net.IP, len(first))
+			for i := range first {
+				last[i] = first[i] | ^ipNet.Mask[i]
+			}
+			if ones, bits := ipNet.Mask.Size(); bits == 32 && ones < 31 {
+				first = nextIP(first)
+				last[len(last)-1]--
+			}
+			ranges = append(ranges, ipRange{first: first, last: last})
+		case strings.Contains(entry, "-"):
+			bounds := strings.SplitN(entry, "-", 2)
+			first := net.ParseIP(strings.TrimSpace(bounds[0]))
+			last := net.ParseIP(strings.TrimSpace(bounds[1]))
+			if first == nil || last == nil {
+				return nil, fmt.Errorf("invalid IP range %q", entry)
+			}
+			first, last = normalizeIP(first), normalizeIP(last)
+			if len(first) != len(last) || bytes.Compare(first, last) > 0 {
+				return nil, fmt.Errorf("invalid IP range %q", entry)
+			}
+			ranges = append(ranges, ipRange{first: first, last: last})
+		default:
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			ip = normalizeIP(ip)
+			ranges = append(ranges, ipRange{first: ip, last: ip})
+		}
+	}
+	return ranges, nil
+}
+
+// poolAllocations returns the allocated addresses of the pool and their
+// owners.
+func poolAllocations(pool *corev1.ConfigMap) (map[string]string, error) {
+	allocations := map[string]string{}
+	if err := yaml.Unmarshal([]byte(pool.Data[IPPoolAllocationsKey]), &allocations); err != nil {
+		return nil, fmt.Errorf("invalid allocations in IP pool %s: %v", pool.Name, err)
+	}
+	return allocations, nil
+}
+
+func setPoolAllocations(pool *corev1.ConfigMap, allocations map[string]string) error {
+	data, err := yaml.Marshal(allocations)
+	if err != nil {
+		return err
+	}
+	if pool.Data == nil {
+		pool.Data = map[string]string{}
+	}
+	pool.Data[IPPoolAllocationsKey] = string(data)
+	return nil
+}
+
+// allocateAddress returns the address of the owner in the pool, allocating
+// the lowest free address of the pool unless the owner already has one. It
+// returns whether the pool was changed.
+func allocateAddress(pool *corev1.ConfigMap, owner string) (string, bool, error) {
+	allocations, err := poolAllocations(pool)
+	if err != nil {
+		return "", false, err
+	}
+	for address, allocationOwner := range allocations {
+		if allocationOwner == owner {
+			return address, false, nil
+		}
+	}
+
+	ranges, err := parseIPRanges(pool.Data[IPPoolAddressesKey])
+	if err != nil {
+		return "", false, fmt.Errorf("invalid addresses in IP pool %s: %v", pool.Name, err)
+	}
+	excluded, err := parseIPRanges(pool.Data[IPPoolExcludeKey])
+	if err != nil {
+		return "", false, fmt.Errorf("invalid excluded addresses in IP pool %s: %v", pool.Name, err)
+	}
+
+	// Excluded ranges are skipped at once, so that the scan is bounded by the
+	// number of allocations and excluded ranges rather than the pool size.
+	for _, r := range ranges {
+		for ip := r.first; r.contains(ip); {
+			if exclusion := excludedRange(ip, excluded); exclusion != nil {
+				ip = nextIP(exclusion.last)
+				continue
+			}
+			if _, allocated := allocations[ip.String()]; allocated {
+				ip = nextIP(ip)
+				continue
+			}
+			allocations[ip.String()] = owner
+			if err := setPoolAllocations(pool, allocations); err != nil {
+				return "", false, err
+			}
+			return ip.String(), true, nil
+		}
+	}
+	return "", false, fmt.Errorf("IP pool %s has no free address left", pool.Name)
+}
+
+// excludedRange returns the excluded range containing ip, or nil.
+func excludedRange(ip net.IP, excluded []ipRange) *ipRange {
+	for i := range excluded {
+		if excluded[i].contains(ip) {
+			return &excluded[i]
+		}
+	}
+	return nil
+}
+
+// releaseAddress frees the addresses of all the networks of the machine in
+// the pool, including the ones allocated to the machine name alone by
+// earlier versions. It returns whether the pool was changed.
+func releaseAddress(pool *corev1.ConfigMap, machineName string) (bool, error) {
+	allocations, err := poolAllocations(pool)
+	if err != nil {
+		return false, err
+	}
+	released := false
+	for address, owner := range allocations {
+		if owner == machineName || strings.HasPrefix(owner, machineName+"/") {
+			delete(allocations, address)
+			released = true
+		}
+	}
+	if !released {
+		return false, nil
+	}
+	return true, setPoolAllocations(pool, allocations)
+}
+
+// allocateIPs returns a copy of the provider spec with the fixed IP of the
+// networks using an IP pool set to the address allocated to the machine.
+func (oc *OpenstackClient) allocateIPs(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec) (*openstackconfigv1.OpenstackProviderSpec, error) {
+	spec := providerSpec.DeepCopy()
+	for i, network := range spec.Networks {
+		if network.IPPool == nil {
+			continue
+		}
+
+		var address string
+		var changed bool
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			pool, err := oc.params.KubeClient.CoreV1().ConfigMaps(machine.Namespace).Get(context.TODO(), network.IPPool.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			address, changed, err = allocateAddress(pool, allocationOwner(machine.Name, i))
+			if err != nil || !changed {
+				return err
+			}
+			_, err = oc.params.KubeClient.CoreV1().ConfigMaps(machine.Namespace).Update(context.TODO(), pool, metav1.UpdateOptions{})
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not allocate an address from IP pool %s: %v", network.IPPool.Name, err)
+		}
+
+		if changed {
+			klog.Infof("Allocated address %s from IP pool %s to machine %s", address, network.IPPool.Name, machine.Name)
+		}
+		spec.Networks[i].FixedIp = address
+	}
+	return spec, nil
+}
+
+// releaseIPs frees the addresses allocated to the machine in the IP pools of
+// its networks. Missing pools are ignored.
+func (oc *OpenstackClient) releaseIPs(machine *machinev1.Machine) error {
+	providerSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	for _, network := range providerSpec.Networks {
+		if network.IPPool == nil {
+			continue
+		}
+
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			pool, err := oc.params.KubeClient.CoreV1().ConfigMaps(machine.Namespace).Get(context.TODO(), network.IPPool.Name, metav1.GetOptions{})
+			if kerrors.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			changed, err := releaseAddress(pool, machine.Name)
+			if err != nil || !changed {
+				return err
+			}
+			_, err = oc.params.KubeClient.CoreV1().ConfigMaps(machine.Namespace).Update(context.TODO(), pool, metav1.UpdateOptions{})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("could not release the address of IP pool %s: %v", network.IPPool.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package machine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseIPRanges(t *testing.T) {
+	testCases := []struct {
+		name        string
+		ranges      string
+		expected    []string
+		expectedErr string
+	}{
+		{
+			name:     "IPv4 CIDR without network and broadcast addresses",
+			ranges:   "10.0.0.0/30",
+			expected: []string{"10.0.0.1-10.0.0.2"},
+		},
+		{
+			name:     "ranges and addresses",
+			ranges:   "10.0.0.10 - 10.0.0.20\n10.0.1.5, 192.168.0.0/32",
+			expected: []string{"10.0.0.10-10.0.0.20", "10.0.1.5-10.0.1.5", "192.168.0.0-192.168.0.0"},
+		},
+		{
+			name:     "IPv6 CIDR",
+			ranges:   "fd00::/126",
+			expected: []string{"fd00::-fd00::3"},
+		},
+		{
+			name:        "reversed range",
+			ranges:      "10.0.0.20-10.0.0.10",
+			expectedErr: "invalid IP range",
+		},
+		{
+			name:        "mixed families",
+			ranges:      "10.0.0.1-fd00::1",
+			expectedErr: "invalid IP range",
+		},
+		{
+			name:        "invalid address",
+			ranges:      "10.0.0",
+			expectedErr: "invalid IP address",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ranges, err := parseIPRanges(tc.ranges)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("Expected error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var got []string
+			for _, r := range ranges {
+				got = append(got, r.first.String()+"-"+r.last.String())
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestAllocateAddress(t *testing.T) {
+	pool := &corev1.ConfigMap{
+		Data: map[string]string{
+			IPPoolAddressesKey: "10.0.0.0/29",
+			IPPoolExcludeKey:   "10.0.0.1",
+		},
+	}
+	pool.Name = "workers"
+
+	for _, expected := range []struct{ machine, address string }{
+		{"worker-0", "10.0.0.2"},
+		{"worker-1", "10.0.0.3"},
+		{"worker-0", "10.0.0.2"},
+	} {
+		address, _, err := allocateAddress(pool, allocationOwner(expected.machine, 0))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if address != expected.address {
+			t.Errorf("Expected address %s for %s, got %s", expected.address, expected.machine, address)
+		}
+	}
+
+	changed, err := releaseAddress(pool, "worker-0")
+	if err != nil || !changed {
+		t.Fatalf("Expected the address of worker-0 to be released, got %v, %v", changed, err)
+	}
+	if changed, _ := releaseAddress(pool, "worker-0"); changed {
+		t.Errorf("Expected releasing twice not to change the pool")
+	}
+
+	for _, expected := range []struct{ machine, address string }{
+		{"worker-2", "10.0.0.2"},
+		{"worker-3", "10.0.0.4"},
+		{"worker-4", "10.0.0.5"},
+		{"worker-5", "10.0.0.6"},
+	} {
+		address, changed, err := allocateAddress(pool, allocationOwner(expected.machine, 0))
+		if err != nil || !changed {
+			t.Fatalf("Expected a new allocation, got %v, %v", changed, err)
+		}
+		if address != expected.address {
+			t.Errorf("Expected address %s for %s, got %s", expected.address, expected.machine, address)
+		}
+	}
+
+	if _, _, err := allocateAddress(pool, allocationOwner("worker-6", 0)); err == nil || !strings.Contains(err.Error(), "no free address") {
+		t.Errorf("Expected the pool to be exhausted, got %v", err)
+	}
+}
+
+func TestAllocateAddressNetworks(t *testing.T) {
+	pool := &corev1.ConfigMap{
+		Data: map[string]string{
+			IPPoolAddressesKey:   "10.0.0.0/29",
+			IPPoolAllocationsKey: "10.0.0.1: worker-0\n",
+		},
+	}
+	pool.Name = "workers"
+
+	first, _, err := allocateAddress(pool, allocationOwner("worker-1", 0))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	second, _, err := allocateAddress(pool, allocationOwner("worker-1", 1))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if first != "10.0.0.2" || second != "10.0.0.3" {
+		t.Errorf("Expected the networks of the machine to get different addresses, got %s and %s", first, second)
+	}
+
+	if changed, err := releaseAddress(pool, "worker-1"); err != nil || !changed {
+		t.Fatalf("Expected the addresses of worker-1 to be released, got %v, %v", changed, err)
+	}
+	if changed, err := releaseAddress(pool, "worker-0"); err != nil || !changed {
+		t.Fatalf("Expected the address allocated to the machine name to be released, got %v, %v", changed, err)
+	}
+	if allocations, _ := poolAllocations(pool); len(allocations) != 0 {
+		t.Errorf("Expected no allocations left, got %v", allocations)
+	}
+}
+
+func TestAllocateAddressLargeExclusion(t *testing.T) {
+	pool := &corev1.ConfigMap{
+		Data: map[string]string{
+			IPPoolAddressesKey: "fd00::/64",
+			IPPoolExcludeKey:   "fd00::-fd00::ffff:ffff:ffff",
+		},
+	}
+	pool.Name = "workers"
+
+	address, _, err := allocateAddress(pool, allocationOwner("worker-0", 0))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if address != "fd00::1:0:0:0" {
+		t.Errorf("Expected the first address after the exclusion, got %s", address)
+	}
+}
@@ -18,61 +18,259 @@ package machine
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"text/template"
 
+	configv1 "github.com/openshift/api/config/v1"
 	machinev1 "github.com/openshift/api/machine/v1beta1"
+	apierrors "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
 )
 
 type setupParams struct {
-	Token       string
 	Machine     *machinev1.Machine
 	MachineSpec *openstackconfigv1.OpenstackProviderSpec
 
-	PodCIDR           string
-	ServiceCIDR       string
+	AvailabilityZone string
+
+	// GetMasterEndpoint returns the internal API server URL of the cluster.
 	GetMasterEndpoint func() (string, error)
+
+	// The cluster and cloud context is only looked up when the template
+	// uses it.
+	getInfrastructure func() (*configv1.Infrastructure, error)
+	getNetwork        func() (*configv1.Network, error)
+	resolveNetworks   func() ([]string, []string, error)
+
+	// newToken creates the bootstrap token of a worker machine.
+	newToken func() (string, error)
+	token    string
 }
 
-func init() {
+// newSetupParams returns the template parameters using the given lookups.
+// Each lookup is done at most once.
+func newSetupParams(getInfrastructure func() (*configv1.Infrastructure, error), getNetwork func() (*configv1.Network, error), resolveNetworks func() ([]string, []string, error)) setupParams {
+	var (
+		clusterInfra    *configv1.Infrastructure
+		clusterInfraErr error
+		clusterNetwork  *configv1.Network
+		networkErr      error
+		resolved        bool
+		networkIDs      []string
+		subnetIDs       []string
+		resolveErr      error
+	)
+	params := setupParams{
+		getInfrastructure: func() (*configv1.Infrastructure, error) {
+			if clusterInfra == nil && clusterInfraErr == nil {
+				clusterInfra, clusterInfraErr = getInfrastructure()
+			}
+			return clusterInfra, clusterInfraErr
+		},
+		getNetwork: func() (*configv1.Network, error) {
+			if clusterNetwork == nil && networkErr == nil {
+				clusterNetwork, networkErr = getNetwork()
+			}
+			return clusterNetwork, networkErr
+		},
+		resolveNetworks: func() ([]string, []string, error) {
+			if !resolved {
+				networkIDs, subnetIDs, resolveErr = resolveNetworks()
+				resolved = true
+			}
+			return networkIDs, subnetIDs, resolveErr
+		},
+	}
+	params.GetMasterEndpoint = func() (string, error) {
+		clusterInfra, err := params.getInfrastructure()
+		if err != nil {
+			return "", err
+		}
+		if clusterInfra.Status.APIServerInternalURL == "" {
+			return "", fmt.Errorf("the internal API server URL of the cluster is not known")
+		}
+		return clusterInfra.Status.APIServerInternalURL, nil
+	}
+	return params
 }
 
-func masterStartupScript(machine *machinev1.Machine, script string) (string, error) {
-	machineSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+// Token returns the bootstrap token of a worker machine. It is only created
+// when the template uses it, and is empty for control plane machines.
+func (p *setupParams) Token() (string, error) {
+	if p.token == "" && p.newToken != nil {
+		token, err := p.newToken()
+		if err != nil {
+			return "", err
+		}
+		p.token = token
+	}
+	return p.token, nil
+}
+
+// ClusterInfraName returns the infrastructure name of the cluster.
+func (p *setupParams) ClusterInfraName() (string, error) {
+	if p.getInfrastructure == nil {
+		return "", nil
+	}
+	clusterInfra, err := p.getInfrastructure()
 	if err != nil {
 		return "", err
 	}
+	return clusterInfra.Status.InfrastructureName, nil
+}
 
-	params := setupParams{
-		Machine:     machine,
-		MachineSpec: machineSpec,
+// APIServerInternalIPs returns the API VIPs of the cluster.
+func (p *setupParams) APIServerInternalIPs() ([]string, error) {
+	platformStatus, err := p.openStackPlatformStatus()
+	if err != nil || platformStatus == nil || platformStatus.APIServerInternalIP == "" {
+		return nil, err
+	}
+	return []string{platformStatus.APIServerInternalIP}, nil
+}
+
+// IngressIPs returns the ingress VIPs of the cluster.
+func (p *setupParams) IngressIPs() ([]string, error) {
+	platformStatus, err := p.openStackPlatformStatus()
+	if err != nil || platformStatus == nil || platformStatus.IngressIP == "" {
+		return nil, err
 	}
+	return []string{platformStatus.IngressIP}, nil
+}
 
-	masterStartUpScript := template.Must(template.New("masterStartUp").Parse(script))
+func (p *setupParams) openStackPlatformStatus() (*configv1.OpenStackPlatformStatus, error) {
+	if p.getInfrastructure == nil {
+		return nil, nil
+	}
+	clusterInfra, err := p.getInfrastructure()
+	if err != nil || clusterInfra.Status.PlatformStatus == nil {
+		return nil, err
+	}
+	return clusterInfra.Status.PlatformStatus.OpenStack, nil
+}
 
-	var buf bytes.Buffer
-	if err := masterStartUpScript.Execute(&buf, params); err != nil {
+// PodCIDR returns the first cluster network of the cluster.
+func (p *setupParams) PodCIDR() (string, error) {
+	if p.getNetwork == nil {
+		return "", nil
+	}
+	clusterNetwork, err := p.getNetwork()
+	if err != nil || len(clusterNetwork.Status.ClusterNetwork) == 0 {
 		return "", err
 	}
-	return buf.String(), nil
+	return clusterNetwork.Status.ClusterNetwork[0].CIDR, nil
+}
+
+// ServiceCIDR returns the first service network of the cluster.
+func (p *setupParams) ServiceCIDR() (string, error) {
+	if p.getNetwork == nil {
+		return "", nil
+	}
+	clusterNetwork, err := p.getNetwork()
+	if err != nil || len(clusterNetwork.Status.ServiceNetwork) == 0 {
+		return "", err
+	}
+	return clusterNetwork.Status.ServiceNetwork[0], nil
 }
 
-func nodeStartupScript(machine *machinev1.Machine, token, script string) (string, error) {
+// NetworkIDs returns the IDs of the networks of the provider spec.
+func (p *setupParams) NetworkIDs() ([]string, error) {
+	if p.resolveNetworks == nil {
+		return nil, nil
+	}
+	networkIDs, _, err := p.resolveNetworks()
+	return networkIDs, err
+}
+
+// SubnetIDs returns the IDs of the subnets of the provider spec.
+func (p *setupParams) SubnetIDs() ([]string, error) {
+	if p.resolveNetworks == nil {
+		return nil, nil
+	}
+	_, subnetIDs, err := p.resolveNetworks()
+	return subnetIDs, err
+}
+
+// invalidTemplateError is returned for user data templates which cannot be
+// parsed.
+type invalidTemplateError struct {
+	err error
+}
+
+func (e *invalidTemplateError) Error() string {
+	return fmt.Sprintf("invalid user data template: %v", e.err)
+}
+
+// startupScriptError returns the machine error for a failure to render the
+// user data. Templates which cannot be parsed are invalid configurations.
+func startupScriptError(err error) *apierrors.MachineError {
+	if _, ok := err.(*invalidTemplateError); ok {
+		return apierrors.InvalidMachineConfiguration("%s", err.Error())
+	}
+	return apierrors.CreateMachine("error creating Openstack instance: %v", err)
+}
+
+// startupScriptParams returns the cluster and cloud context of the user data
+// templates of the machine.
+func (oc *OpenstackClient) startupScriptParams(providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService) setupParams {
+	params := newSetupParams(
+		func() (*configv1.Infrastructure, error) {
+			clusterInfra, err := oc.params.ConfigClient.Infrastructures().Get(context.TODO(), "cluster", metav1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("Failed to retrieve cluster Infrastructure object: %v", err)
+			}
+			return clusterInfra, nil
+		},
+		func() (*configv1.Network, error) {
+			clusterNetwork, err := oc.params.ConfigClient.Networks().Get(context.TODO(), "cluster", metav1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("Failed to retrieve cluster Network object: %v", err)
+			}
+			return clusterNetwork, nil
+		},
+		func() ([]string, []string, error) {
+			return machineService.ResolveNetworks(providerSpec.Networks)
+		},
+	)
+	params.AvailabilityZone = providerSpec.AvailabilityZone
+	return params
+}
+
+func masterStartupScript(machine *machinev1.Machine, params setupParams, script string) (string, error) {
 	machineSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
 	if err != nil {
 		return "", err
 	}
 
-	params := setupParams{
-		Token:       token,
-		Machine:     machine,
-		MachineSpec: machineSpec,
+	params.Machine = machine
+	params.MachineSpec = machineSpec
+
+	return renderStartupScript("masterStartUp", script, params)
+}
+
+func nodeStartupScript(machine *machinev1.Machine, params setupParams, newToken func() (string, error), script string) (string, error) {
+	machineSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return "", err
 	}
 
-	nodeStartUpScript := template.Must(template.New("nodeStartUp").Parse(script))
+	params.newToken = newToken
+	params.Machine = machine
+	params.MachineSpec = machineSpec
+
+	return renderStartupScript("nodeStartUp", script, params)
+}
+
+func renderStartupScript(name, script string, params setupParams) (string, error) {
+	startUpScript, err := template.New(name).Funcs(templateFuncs).Parse(script)
+	if err != nil {
+		return "", &invalidTemplateError{err: err}
+	}
 
 	var buf bytes.Buffer
-	if err := nodeStartUpScript.Execute(&buf, params); err != nil {
+	if err := startUpScript.Execute(&buf, &params); err != nil {
 		return "", err
 	}
 	return buf.String(), nil
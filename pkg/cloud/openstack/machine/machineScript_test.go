@@ -1,9 +1,13 @@
 package machine
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
+	configv1 "github.com/openshift/api/config/v1"
 	machinev1 "github.com/openshift/api/machine/v1beta1"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
 	"sigs.k8s.io/yaml"
 )
 
@@ -21,13 +25,13 @@ func TestNodeStartupScriptEmpty(t *testing.T) {
 		return
 	}
 
-	token := ""
+	newToken := func() (string, error) { return "", nil }
 	script_template := ""
 
 	// `machine` has no endpoint specified so having `call
 	// .GetMasterEndpoint` in the script template would fail. But we
 	// don't, so this should succeed.
-	script, err := nodeStartupScript(machine, token, script_template)
+	script, err := nodeStartupScript(machine, setupParams{}, newToken, script_template)
 	if err != nil {
 		t.Errorf("%v", err)
 		return
@@ -46,12 +50,189 @@ func TestNodeStartupScriptEndpointError(t *testing.T) {
 		return
 	}
 
-	token := ""
+	newToken := func() (string, error) { return "", nil }
 	script_template := "{{ call .GetMasterEndpoint }}"
 	// `machine` has no endpoint specified so having `call
 	// .GetMasterEndpoint` in the template should fail.
-	script, err := nodeStartupScript(machine, token, script_template)
+	script, err := nodeStartupScript(machine, setupParams{}, newToken, script_template)
 	if err == nil {
 		t.Errorf("Expected GetMasterEndpoint to fail, but it succeeded. Startup script %q", script)
 	}
 }
+
+func TestStartupScriptInvalidTemplate(t *testing.T) {
+	machine := &machinev1.Machine{}
+	err := yaml.Unmarshal([]byte(providerSpecYAML), &machine.Spec.ProviderSpec)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	_, err = masterStartupScript(machine, setupParams{}, "{{ .Machine.Name ")
+	if _, ok := err.(*invalidTemplateError); !ok {
+		t.Fatalf("Expected an invalid template error, got %v", err)
+	}
+	if reason := startupScriptError(err).Reason; reason != machinev1.InvalidConfigurationMachineError {
+		t.Errorf("Expected reason %s, got %s", machinev1.InvalidConfigurationMachineError, reason)
+	}
+}
+
+func TestStartupScriptContext(t *testing.T) {
+	machine := &machinev1.Machine{}
+	machine.Name = "worker-0"
+	err := yaml.Unmarshal([]byte(providerSpecYAML), &machine.Spec.ProviderSpec)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	params := newSetupParams(
+		func() (*configv1.Infrastructure, error) {
+			return &configv1.Infrastructure{
+				Status: configv1.InfrastructureStatus{
+					InfrastructureName:   "ostest-abcde",
+					APIServerInternalURL: "https://api-int.ostest.example.com:6443",
+					PlatformStatus: &configv1.PlatformStatus{
+						OpenStack: &configv1.OpenStackPlatformStatus{APIServerInternalIP: "10.0.0.5", IngressIP: "10.0.0.7"},
+					},
+				},
+			}, nil
+		},
+		func() (*configv1.Network, error) {
+			return &configv1.Network{
+				Status: configv1.NetworkStatus{
+					ClusterNetwork: []configv1.ClusterNetworkEntry{{CIDR: "10.128.0.0/14"}},
+				},
+			}, nil
+		},
+		func() ([]string, []string, error) {
+			return []string{"net-1", "net-2"}, nil, nil
+		},
+	)
+
+	script := `{{ .Machine.Name | upper }} {{ .ClusterInfraName }} {{ call .GetMasterEndpoint }} ` +
+		`{{ index .APIServerInternalIPs 0 }} {{ join "," .IngressIPs }} {{ .PodCIDR | quote }} ` +
+		`{{ join "," .NetworkIDs }} {{ .AvailabilityZone | default "nova" }}`
+	expected := `WORKER-0 ostest-abcde https://api-int.ostest.example.com:6443 10.0.0.5 10.0.0.7 "10.128.0.0/14" net-1,net-2 nova`
+
+	rendered, err := masterStartupScript(machine, params, script)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rendered != expected {
+		t.Errorf("Expected %q, got %q", expected, rendered)
+	}
+
+	_, err = masterStartupScript(machine, params, `{{ required "a service CIDR is needed" .ServiceCIDR }}`)
+	if err == nil || !strings.Contains(err.Error(), "a service CIDR is needed") {
+		t.Errorf("Expected the required function to fail, got %v", err)
+	}
+}
+
+func TestStartupScriptLazyLookups(t *testing.T) {
+	machine := &machinev1.Machine{}
+	err := yaml.Unmarshal([]byte(providerSpecYAML), &machine.Spec.ProviderSpec)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	lookups := 0
+	params := newSetupParams(
+		func() (*configv1.Infrastructure, error) {
+			lookups++
+			return &configv1.Infrastructure{Status: configv1.InfrastructureStatus{InfrastructureName: "ostest-abcde"}}, nil
+		},
+		func() (*configv1.Network, error) {
+			lookups++
+			return nil, fmt.Errorf("network lookup failed")
+		},
+		func() ([]string, []string, error) {
+			lookups++
+			return nil, nil, fmt.Errorf("could not find network")
+		},
+	)
+
+	if _, err := masterStartupScript(machine, params, "#!/bin/sh"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if lookups != 0 {
+		t.Errorf("Expected no lookups for a template not using them, got %d", lookups)
+	}
+
+	rendered, err := masterStartupScript(machine, params, "{{ .ClusterInfraName }} {{ .ClusterInfraName }}")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rendered != "ostest-abcde ostest-abcde" || lookups != 1 {
+		t.Errorf("Expected the Infrastructure to be looked up once, got %q after %d lookups", rendered, lookups)
+	}
+
+	if _, err := masterStartupScript(machine, params, "{{ .PodCIDR }}"); err == nil {
+		t.Errorf("Expected the Network lookup error to fail the template")
+	}
+	if _, err := masterStartupScript(machine, params, "{{ .SubnetIDs }}"); err == nil {
+		t.Errorf("Expected the network resolution error to fail the template")
+	}
+}
+
+func TestNodeStartupScriptToken(t *testing.T) {
+	machine := &machinev1.Machine{}
+	err := yaml.Unmarshal([]byte(providerSpecYAML), &machine.Spec.ProviderSpec)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	created := 0
+	newToken := func() (string, error) {
+		created++
+		return "abcdef.0123456789abcdef", nil
+	}
+
+	script, err := nodeStartupScript(machine, setupParams{}, newToken, "kubeadm join --token {{ .Token }} --discovery-token {{ .Token }}")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if script != "kubeadm join --token abcdef.0123456789abcdef --discovery-token abcdef.0123456789abcdef" {
+		t.Errorf("Unexpected script %q", script)
+	}
+	if created != 1 {
+		t.Errorf("Expected the token to be created once, it was created %d times", created)
+	}
+
+	created = 0
+	if _, err := nodeStartupScript(machine, setupParams{}, newToken, "#!/bin/sh"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if created != 0 {
+		t.Errorf("Expected no token to be created for a template not using it")
+	}
+}
+
+func TestIsControlPlane(t *testing.T) {
+	testCases := []struct {
+		name     string
+		label    string
+		role     string
+		expected bool
+	}{
+		{name: "master label", label: "master", expected: true},
+		{name: "worker label", label: "worker", expected: false},
+		{name: "infra label", label: "infra", expected: false},
+		{name: "no role", expected: false},
+		{name: "role overrides label", label: "master", role: "worker", expected: false},
+		{name: "control-plane role", label: "worker", role: "control-plane", expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machine := &machinev1.Machine{}
+			machine.Labels = map[string]string{MachineRoleLabel: tc.label}
+			providerSpec := &openstackconfigv1.OpenstackProviderSpec{Role: tc.role}
+			if got := isControlPlane(machine, providerSpec); got != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"fmt"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+// reconcilePorts applies the changes of the provider spec to the ports of the
+// existing server of the machine, and records the ports attached to the
+// server in the provider status. The cluster network and managed security
+// groups are used as in Create, the cluster tags are added to the ports, and
+// the fixed IPs of networks using an IP pool are the addresses allocated to
+// the machine.
+func (oc *OpenstackClient) reconcilePorts(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, instance *clients.Instance, machineService *clients.InstanceService, clusterInfraName string, clusterSpec *openstackconfigv1.OpenstackClusterProviderSpec, clusterStatus *openstackconfigv1.OpenstackClusterProviderStatus) error {
+	providerStatus, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return err
+	}
+
+	// Networks added to the provider spec get an address from their IP pool,
+	// the networks of the server keep the address allocated at creation.
+	providerSpec, err = oc.allocateIPs(machine, providerSpec)
+	if err != nil {
+		return err
+	}
+	useClusterNetwork(providerSpec, clusterStatus)
+	useManagedSecurityGroups(machine, providerSpec, clusterSpec, clusterStatus)
+
+	// Interfaces can only be attached to and detached from active servers
+	instanceID := instance.ID
+	if instance.Status != instanceStatusActive {
+		instanceID = ""
+	}
+
+	clusterName := fmt.Sprintf("%s-%s", machine.Namespace, machine.Labels["machine.openshift.io/cluster-api-cluster"])
+	attachedPorts, reconcileErr := machineService.ReconcilePorts(clusterName, clusterInfraName, machine.Name, instanceID, clusterSpec, providerSpec, oc.params.ConfigClient, providerStatus.AttachedPorts)
+
+	// Ports attached or detached before an error are recorded all the same
+	if !equality.Semantic.DeepEqual(attachedPorts, providerStatus.AttachedPorts) {
+		for _, port := range missingAttachedPorts(providerStatus.AttachedPorts, attachedPorts) {
+			oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, "AttachedPort", "Attached port %s to instance %s", port.Name, instance.ID)
+		}
+		for _, port := range missingAttachedPorts(attachedPorts, providerStatus.AttachedPorts) {
+			oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, "DetachedPort", "Detached port %s from instance %s", port.Name, instance.ID)
+		}
+		providerStatus.AttachedPorts = attachedPorts
+		if err := oc.patchProviderStatus(machine, providerStatus); err != nil {
+			return err
+		}
+	}
+	return reconcileErr
+}
+
+// missingAttachedPorts returns the ports of desired which are not in ports.
+func missingAttachedPorts(ports []openstackconfigv1.AttachedPort, desired []openstackconfigv1.AttachedPort) []openstackconfigv1.AttachedPort {
+	var missing []openstackconfigv1.AttachedPort
+	for _, port := range desired {
+		found := false
+		for _, p := range ports {
+			if p.ID == port.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, port)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"fmt"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	apierrors "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+// checkQuota refuses to create the machine when the project quotas do not
+// leave room for it. Quotas which cannot be read do not block the creation,
+// the quota API may be restricted by policy.
+func (oc *OpenstackClient) checkQuota(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService) error {
+	flavorID, err := machineService.GetFlavorID(providerSpec.Flavor)
+	if err != nil {
+		klog.V(2).Infof("Skipping quota check of machine %s: %v", machine.Name, err)
+		return nil
+	}
+	flavor, err := machineService.GetFlavorInfo(flavorID)
+	if err != nil {
+		klog.V(2).Infof("Skipping quota check of machine %s: %v", machine.Name, err)
+		return nil
+	}
+
+	shortages, err := machineService.CheckQuota(clients.NewQuotaRequest(providerSpec, flavor))
+	if err != nil {
+		klog.V(2).Infof("Skipping quota check of machine %s: %v", machine.Name, err)
+		return nil
+	}
+	if len(shortages) == 0 {
+		return nil
+	}
+
+	message := fmt.Sprintf("cannot create Openstack instance: %s", clients.QuotaShortagesMessage(shortages))
+	machineErr := &apierrors.MachineError{
+		Reason:  machinev1.InsufficientResourcesMachineError,
+		Message: message,
+	}
+
+	oc.eventRecorder.Eventf(machine, corev1.EventTypeWarning, FaultReasonQuotaExceeded, "%s", message)
+	base := machine.DeepCopy()
+	setCreateFailure(machine, FaultReasonQuotaExceeded, machineErr)
+	oc.patchStatus(machine, base)
+	return oc.handleMachineError(machine, machineErr, createEventAction)
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"context"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// getClusterConfig returns the provider spec and status of the cluster of the
+// machines of the namespace. The cluster ConfigMap is read from the API
+// server, like the Infrastructure object, as the cache of the manager would
+// hold every ConfigMap of the cluster. They are empty if the namespace has no
+// cluster ConfigMap.
+func (oc *OpenstackClient) getClusterConfig(namespace string) (*openstackconfigv1.OpenstackClusterProviderSpec, *openstackconfigv1.OpenstackClusterProviderStatus, error) {
+	if oc.params.APIReader == nil {
+		return clients.GetClusterConfig(oc.params.KubeClient, namespace)
+	}
+	configMap := &corev1.ConfigMap{}
+	err := oc.params.APIReader.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: clients.ClusterConfigMapName}, configMap)
+	if kerrors.IsNotFound(err) {
+		return &openstackconfigv1.OpenstackClusterProviderSpec{}, &openstackconfigv1.OpenstackClusterProviderStatus{}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return clients.ClusterConfigFromConfigMap(configMap)
+}
+
+// managedSecurityGroups returns the IDs of the security groups managed for
+// the cluster which the machine gets given its role: the global group for
+// every machine and the control plane group for control plane machines.
+func managedSecurityGroups(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, clusterSpec *openstackconfigv1.OpenstackClusterProviderSpec, clusterStatus *openstackconfigv1.OpenstackClusterProviderStatus) []string {
+	if !clusterSpec.ManagedSecurityGroups {
+		return nil
+	}
+	var ids []string
+	if clusterStatus.GlobalSecurityGroup != nil && clusterStatus.GlobalSecurityGroup.ID != "" {
+		ids = append(ids, clusterStatus.GlobalSecurityGroup.ID)
+	}
+	if isControlPlane(machine, providerSpec) && clusterStatus.ControlPlaneSecurityGroup != nil && clusterStatus.ControlPlaneSecurityGroup.ID != "" {
+		ids = append(ids, clusterStatus.ControlPlaneSecurityGroup.ID)
+	}
+	return ids
+}
+
+// useManagedSecurityGroups adds the managed security groups of the machine
+// missing from the security groups of the provider spec.
+func useManagedSecurityGroups(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, clusterSpec *openstackconfigv1.OpenstackClusterProviderSpec, clusterStatus *openstackconfigv1.OpenstackClusterProviderStatus) {
+	for _, id := range managedSecurityGroups(machine, providerSpec, clusterSpec, clusterStatus) {
+		found := false
+		for _, sg := range providerSpec.SecurityGroups {
+			if sg.UUID == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			providerSpec.SecurityGroups = append(providerSpec.SecurityGroups, openstackconfigv1.SecurityGroupParam{UUID: id})
+		}
+	}
+}
+
+// staleManagedSecurityGroups returns the IDs of the security groups managed
+// for the cluster which the machine must not have anymore: the control plane
+// group once the machine is not part of the control plane, and both groups
+// once the groups are not managed anymore. Groups which are in the security
+// groups of the provider spec are kept.
+func staleManagedSecurityGroups(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, clusterSpec *openstackconfigv1.OpenstackClusterProviderSpec, clusterStatus *openstackconfigv1.OpenstackClusterProviderStatus) []string {
+	managed := managedSecurityGroups(machine, providerSpec, clusterSpec, clusterStatus)
+	var stale []string
+	for _, group := range []*openstackconfigv1.SecurityGroup{clusterStatus.GlobalSecurityGroup, clusterStatus.ControlPlaneSecurityGroup} {
+		if group == nil || group.ID == "" {
+			continue
+		}
+		wanted := false
+		for _, id := range managed {
+			if id == group.ID {
+				wanted = true
+			}
+		}
+		for _, sg := range providerSpec.SecurityGroups {
+			if sg.UUID == group.ID || (sg.Name != "" && sg.Name == group.Name) {
+				wanted = true
+			}
+		}
+		if !wanted {
+			stale = append(stale, group.ID)
+		}
+	}
+	return stale
+}
+
+// reconcileManagedSecurityGroups adds the managed security groups of the
+// machine to the ports of its server, and removes the stale ones. With
+// UpdatePortSecurity, the security groups of the provider spec, which include
+// the managed ones, already replace those of the ports.
+func reconcileManagedSecurityGroups(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService, instancePorts []clients.InstancePort, clusterSpec *openstackconfigv1.OpenstackClusterProviderSpec, clusterStatus *openstackconfigv1.OpenstackClusterProviderStatus) error {
+	if providerSpec.UpdatePortSecurity {
+		return nil
+	}
+	add := managedSecurityGroups(machine, providerSpec, clusterSpec, clusterStatus)
+	remove := staleManagedSecurityGroups(machine, providerSpec, clusterSpec, clusterStatus)
+	if len(add) == 0 && len(remove) == 0 {
+		return nil
+	}
+	return machineService.UpdateSecurityGroups(instancePorts, add, remove)
+}
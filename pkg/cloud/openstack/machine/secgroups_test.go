@@ -0,0 +1,167 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"reflect"
+	"testing"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestUseManagedSecurityGroups(t *testing.T) {
+	clusterStatus := &openstackconfigv1.OpenstackClusterProviderStatus{
+		ControlPlaneSecurityGroup: &openstackconfigv1.SecurityGroup{ID: "controlplane"},
+		GlobalSecurityGroup:       &openstackconfigv1.SecurityGroup{ID: "global"},
+	}
+
+	testCases := []struct {
+		name           string
+		label          string
+		managed        bool
+		securityGroups []openstackconfigv1.SecurityGroupParam
+		expected       []openstackconfigv1.SecurityGroupParam
+	}{
+		{
+			name:           "unmanaged security groups",
+			label:          "master",
+			securityGroups: []openstackconfigv1.SecurityGroupParam{{Name: "default"}},
+			expected:       []openstackconfigv1.SecurityGroupParam{{Name: "default"}},
+		},
+		{
+			name:     "control plane machine",
+			label:    "master",
+			managed:  true,
+			expected: []openstackconfigv1.SecurityGroupParam{{UUID: "global"}, {UUID: "controlplane"}},
+		},
+		{
+			name:           "worker machine",
+			label:          "worker",
+			managed:        true,
+			securityGroups: []openstackconfigv1.SecurityGroupParam{{Name: "default"}},
+			expected:       []openstackconfigv1.SecurityGroupParam{{Name: "default"}, {UUID: "global"}},
+		},
+		{
+			name:           "group already set",
+			label:          "worker",
+			managed:        true,
+			securityGroups: []openstackconfigv1.SecurityGroupParam{{UUID: "global"}},
+			expected:       []openstackconfigv1.SecurityGroupParam{{UUID: "global"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machine := &machinev1.Machine{}
+			machine.Labels = map[string]string{MachineRoleLabel: tc.label}
+			providerSpec := &openstackconfigv1.OpenstackProviderSpec{SecurityGroups: tc.securityGroups}
+			clusterSpec := &openstackconfigv1.OpenstackClusterProviderSpec{ManagedSecurityGroups: tc.managed}
+			useManagedSecurityGroups(machine, providerSpec, clusterSpec, clusterStatus)
+			if !reflect.DeepEqual(providerSpec.SecurityGroups, tc.expected) {
+				t.Errorf("Expected %+v, got %+v", tc.expected, providerSpec.SecurityGroups)
+			}
+		})
+	}
+}
+
+func TestStaleManagedSecurityGroups(t *testing.T) {
+	clusterStatus := &openstackconfigv1.OpenstackClusterProviderStatus{
+		ControlPlaneSecurityGroup: &openstackconfigv1.SecurityGroup{ID: "controlplane", Name: "k8s-cluster-ocp-secgroup-controlplane"},
+		GlobalSecurityGroup:       &openstackconfigv1.SecurityGroup{ID: "global", Name: "k8s-cluster-ocp-secgroup-all"},
+	}
+
+	testCases := []struct {
+		name           string
+		label          string
+		managed        bool
+		securityGroups []openstackconfigv1.SecurityGroupParam
+		expected       []string
+	}{
+		{
+			name:    "control plane machine",
+			label:   "master",
+			managed: true,
+		},
+		{
+			name:     "worker machine",
+			label:    "worker",
+			managed:  true,
+			expected: []string{"controlplane"},
+		},
+		{
+			name:     "unmanaged security groups",
+			label:    "master",
+			expected: []string{"global", "controlplane"},
+		},
+		{
+			name:           "unmanaged security groups in the provider spec",
+			label:          "master",
+			securityGroups: []openstackconfigv1.SecurityGroupParam{{UUID: "global"}, {Name: "k8s-cluster-ocp-secgroup-controlplane"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machine := &machinev1.Machine{}
+			machine.Labels = map[string]string{MachineRoleLabel: tc.label}
+			providerSpec := &openstackconfigv1.OpenstackProviderSpec{SecurityGroups: tc.securityGroups}
+			clusterSpec := &openstackconfigv1.OpenstackClusterProviderSpec{ManagedSecurityGroups: tc.managed}
+			stale := staleManagedSecurityGroups(machine, providerSpec, clusterSpec, clusterStatus)
+			if !reflect.DeepEqual(stale, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, stale)
+			}
+		})
+	}
+}
+
+func TestGetClusterConfig(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: clients.ClusterConfigMapName, Namespace: "openshift-machine-api"},
+		Data: map[string]string{
+			clients.ClusterSpecKey:   "managedSecurityGroups: true\n",
+			clients.ClusterStatusKey: `{"globalSecurityGroup": {"id": "global"}}`,
+		},
+	}
+	oc := &OpenstackClient{params: openstack.ActuatorParams{APIReader: fake.NewClientBuilder().WithScheme(scheme).WithObjects(configMap).Build()}}
+
+	clusterSpec, clusterStatus, err := oc.getClusterConfig("openshift-machine-api")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !clusterSpec.ManagedSecurityGroups || clusterStatus.GlobalSecurityGroup == nil || clusterStatus.GlobalSecurityGroup.ID != "global" {
+		t.Errorf("Unexpected cluster config %+v %+v", clusterSpec, clusterStatus)
+	}
+
+	clusterSpec, clusterStatus, err = oc.getClusterConfig("other")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if clusterSpec.ManagedSecurityGroups || clusterStatus.GlobalSecurityGroup != nil {
+		t.Errorf("Expected an empty cluster config, got %+v %+v", clusterSpec, clusterStatus)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+const (
+	// SRIOVInterfacesAnnotationKey lists the SR-IOV ports of the server of a
+	// machine as JSON, on the machine and its node.
+	SRIOVInterfacesAnnotationKey = "machine.openshift.io/openstack-sriov-interfaces"
+
+	// SRIOVCapableLabel is set on the machines with SR-IOV ports, and synced
+	// to their nodes. It is the label Node Feature Discovery sets on SR-IOV
+	// capable nodes, which the SR-IOV network operator selects nodes with.
+	SRIOVCapableLabel = "feature.node.kubernetes.io/network-sriov.capable"
+)
+
+// sriovInterface is an SR-IOV port of a server, as listed in the
+// SRIOVInterfacesAnnotationKey annotation.
+type sriovInterface struct {
+	PortID          string `json:"portID"`
+	NetworkID       string `json:"networkID"`
+	MACAddress      string `json:"macAddress"`
+	VNICType        string `json:"vnicType"`
+	PCISlot         string `json:"pciSlot,omitempty"`
+	PhysicalNetwork string `json:"physicalNetwork,omitempty"`
+}
+
+// checkPortBindings returns an error if Neutron failed to bind any port of the
+// server.
+func checkPortBindings(machineService *clients.InstanceService, instanceID string) error {
+	instancePorts, err := machineService.GetInstancePorts(instanceID)
+	if err != nil {
+		return fmt.Errorf("could not check the bindings of the ports: %v", err)
+	}
+	var failed []string
+	for _, port := range instancePorts {
+		if port.VIFType == clients.VIFTypeBindingFailed {
+			failed = append(failed, fmt.Sprintf("%s (vnicType %s)", port.ID, port.VNICType))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("ports %s failed to bind", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// sriovInterfaces returns the SR-IOV ports of the server.
+func sriovInterfaces(instancePorts []clients.InstancePort) []sriovInterface {
+	var interfaces []sriovInterface
+	for _, port := range instancePorts {
+		if !port.IsSRIOV() {
+			continue
+		}
+		iface := sriovInterface{
+			PortID:     port.ID,
+			NetworkID:  port.NetworkID,
+			MACAddress: port.MACAddress,
+			VNICType:   port.VNICType,
+		}
+		if pciSlot, ok := port.Profile["pci_slot"].(string); ok {
+			iface.PCISlot = pciSlot
+		}
+		if physicalNetwork, ok := port.Profile["physical_network"].(string); ok {
+			iface.PhysicalNetwork = physicalNetwork
+		}
+		interfaces = append(interfaces, iface)
+	}
+	return interfaces
+}
+
+// reconcileSRIOVInterfaces sets the SR-IOV annotation and label of the machine
+// from the SR-IOV ports of its server, and the annotation of its node. Both
+// are removed from the machine and its node once the server has no SR-IOV
+// port left. The node is only patched when it differs.
+func (oc *OpenstackClient) reconcileSRIOVInterfaces(machine *machinev1.Machine, instance *clients.Instance, instancePorts []clients.InstancePort) error {
+	if instance == nil {
+		return nil
+	}
+
+	var annotation string
+	if interfaces := sriovInterfaces(instancePorts); len(interfaces) > 0 {
+		data, err := json.Marshal(interfaces)
+		if err != nil {
+			return err
+		}
+		annotation = string(data)
+	}
+	setSRIOVMachineMetadata(machine, annotation)
+
+	if machine.Status.NodeRef == nil {
+		return nil
+	}
+	node, err := oc.params.KubeClient.CoreV1().Nodes().Get(context.TODO(), machine.Status.NodeRef.Name, metav1.GetOptions{})
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	nodePatch := sriovNodePatch(node, annotation)
+	if nodePatch == nil {
+		return nil
+	}
+	patch, err := json.Marshal(nodePatch)
+	if err != nil {
+		return err
+	}
+	_, err = oc.params.KubeClient.CoreV1().Nodes().Patch(context.TODO(), node.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// setSRIOVMachineMetadata sets the SR-IOV annotation and label of the machine,
+// or removes them if the annotation is empty. The label is only removed along
+// with the annotation, as it may have been set by the user.
+func setSRIOVMachineMetadata(machine *machinev1.Machine, annotation string) {
+	if annotation == "" {
+		if _, ok := machine.Annotations[SRIOVInterfacesAnnotationKey]; ok {
+			delete(machine.Annotations, SRIOVInterfacesAnnotationKey)
+			delete(machine.Spec.Labels, SRIOVCapableLabel)
+		}
+		return
+	}
+	if machine.Annotations == nil {
+		machine.Annotations = make(map[string]string)
+	}
+	machine.Annotations[SRIOVInterfacesAnnotationKey] = annotation
+	if machine.Spec.Labels == nil {
+		machine.Spec.Labels = make(map[string]string)
+	}
+	machine.Spec.Labels[SRIOVCapableLabel] = "true"
+}
+
+// sriovNodePatch returns the merge patch setting the SR-IOV annotation of the
+// node, or removing it along with the SR-IOV label if the annotation is empty.
+// The label of SR-IOV nodes is synced from the machine. Node Feature Discovery
+// also sets the label, so it is only removed from nodes which have the
+// annotation. It returns nil if the node needs no change.
+func sriovNodePatch(node *corev1.Node, annotation string) map[string]interface{} {
+	metadata := map[string]interface{}{}
+	current, ok := node.Annotations[SRIOVInterfacesAnnotationKey]
+	switch {
+	case annotation != "" && current != annotation:
+		metadata["annotations"] = map[string]interface{}{SRIOVInterfacesAnnotationKey: annotation}
+	case annotation == "" && ok:
+		metadata["annotations"] = map[string]interface{}{SRIOVInterfacesAnnotationKey: nil}
+		if _, ok := node.Labels[SRIOVCapableLabel]; ok {
+			metadata["labels"] = map[string]interface{}{SRIOVCapableLabel: nil}
+		}
+	}
+	if len(metadata) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"metadata": metadata}
+}
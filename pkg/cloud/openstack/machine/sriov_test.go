@@ -0,0 +1,127 @@
+package machine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/portsbinding"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+func TestSRIOVInterfaces(t *testing.T) {
+	instancePorts := []clients.InstancePort{
+		{
+			Port:            ports.Port{ID: "normal", NetworkID: "net"},
+			PortsBindingExt: portsbinding.PortsBindingExt{VNICType: clients.VNICTypeNormal},
+		},
+		{
+			Port: ports.Port{ID: "vf", NetworkID: "sriov-net", MACAddress: "fa:16:3e:00:00:01"},
+			PortsBindingExt: portsbinding.PortsBindingExt{
+				VNICType: clients.VNICTypeDirect,
+				Profile:  map[string]interface{}{"pci_slot": "0000:3b:02.1", "physical_network": "physnet1"},
+			},
+		},
+		{
+			Port: ports.Port{ID: "offload", NetworkID: "net"},
+			PortsBindingExt: portsbinding.PortsBindingExt{
+				VNICType: clients.VNICTypeDirect,
+				Profile:  map[string]interface{}{"capabilities": []interface{}{"switchdev"}},
+			},
+		},
+		{
+			Port:            ports.Port{ID: "pf", NetworkID: "sriov-net", MACAddress: "fa:16:3e:00:00:02"},
+			PortsBindingExt: portsbinding.PortsBindingExt{VNICType: clients.VNICTypeDirectPhysical},
+		},
+	}
+
+	expected := []sriovInterface{
+		{PortID: "vf", NetworkID: "sriov-net", MACAddress: "fa:16:3e:00:00:01", VNICType: clients.VNICTypeDirect, PCISlot: "0000:3b:02.1", PhysicalNetwork: "physnet1"},
+		{PortID: "pf", NetworkID: "sriov-net", MACAddress: "fa:16:3e:00:00:02", VNICType: clients.VNICTypeDirectPhysical},
+	}
+
+	if interfaces := sriovInterfaces(instancePorts); !reflect.DeepEqual(interfaces, expected) {
+		t.Errorf("expected %v, got %v", expected, interfaces)
+	}
+}
+
+func TestSRIOVNodePatch(t *testing.T) {
+	const annotation = `[{"portID":"port-1"}]`
+
+	testCases := []struct {
+		name       string
+		node       corev1.Node
+		annotation string
+		expected   map[string]interface{}
+	}{
+		{
+			name:       "new SR-IOV node",
+			annotation: annotation,
+			expected: map[string]interface{}{"metadata": map[string]interface{}{
+				"annotations": map[string]interface{}{SRIOVInterfacesAnnotationKey: annotation},
+			}},
+		},
+		{
+			name: "up to date node",
+			node: corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{SRIOVInterfacesAnnotationKey: annotation},
+				Labels:      map[string]string{SRIOVCapableLabel: "true"},
+			}},
+			annotation: annotation,
+		},
+		{
+			name: "SR-IOV ports removed",
+			node: corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{SRIOVInterfacesAnnotationKey: annotation},
+				Labels:      map[string]string{SRIOVCapableLabel: "true"},
+			}},
+			expected: map[string]interface{}{"metadata": map[string]interface{}{
+				"annotations": map[string]interface{}{SRIOVInterfacesAnnotationKey: nil},
+				"labels":      map[string]interface{}{SRIOVCapableLabel: nil},
+			}},
+		},
+		{
+			name: "node without SR-IOV",
+		},
+		{
+			name: "NFD label, no annotation",
+			node: corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{SRIOVCapableLabel: "true"},
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patch := sriovNodePatch(&tc.node, tc.annotation)
+			if !reflect.DeepEqual(patch, tc.expected) {
+				t.Errorf("Expected patch %v, got %v", tc.expected, patch)
+			}
+		})
+	}
+}
+
+func TestSetSRIOVMachineMetadata(t *testing.T) {
+	machine := &machinev1.Machine{}
+	setSRIOVMachineMetadata(machine, `[{"portID":"port-1"}]`)
+	if machine.Annotations[SRIOVInterfacesAnnotationKey] == "" || machine.Spec.Labels[SRIOVCapableLabel] != "true" {
+		t.Fatalf("Expected the SR-IOV annotation and label to be set, got %v and %v", machine.Annotations, machine.Spec.Labels)
+	}
+
+	setSRIOVMachineMetadata(machine, "")
+	if _, ok := machine.Annotations[SRIOVInterfacesAnnotationKey]; ok {
+		t.Errorf("Expected the SR-IOV annotation to be removed")
+	}
+	if _, ok := machine.Spec.Labels[SRIOVCapableLabel]; ok {
+		t.Errorf("Expected the SR-IOV label to be removed")
+	}
+
+	machine.Spec.Labels = map[string]string{SRIOVCapableLabel: "true"}
+	setSRIOVMachineMetadata(machine, "")
+	if machine.Spec.Labels[SRIOVCapableLabel] != "true" {
+		t.Errorf("Expected the SR-IOV label set without the annotation to be kept")
+	}
+}
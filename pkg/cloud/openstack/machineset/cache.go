@@ -1,16 +1,30 @@
 package machineset
 
 import (
-	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"context"
 	"sync"
 	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"k8s.io/klog/v2"
 )
 
-const StaledTime time.Duration = 300 * time.Second
+// RefreshTime is how often the flavors in the cache are refreshed in the background.
+const RefreshTime time.Duration = 300 * time.Second
 const RefreshFailureTime time.Duration = 60 * time.Second // This controls how often we try to get a look at a failed flavor
+
+// cloudKey identifies the OpenStack cloud and region a MachineSet creates its machines in.
+type cloudKey struct {
+	secretNamespace string
+	secretName      string
+	cloudName       string
+	region          string
+}
+
 // machineFalvorKey is used to identify Machine flavor
 type machineFlavorKey struct {
-	name string
+	cloud cloudKey
+	name  string
 }
 
 type flavorCacheEntry struct {
@@ -22,62 +36,123 @@ type flavorCacheEntry struct {
 type machineFlavorsCache struct {
 	cacheMutex         sync.Mutex
 	cache              map[machineFlavorKey]flavorCacheEntry
-	staledTime         time.Duration
+	services           map[cloudKey]OpenStackFlavorService
+	refreshTime        time.Duration
 	refreshFailureTime time.Duration
 }
 
 func newMachineFlavorCache() *machineFlavorsCache {
 	return &machineFlavorsCache{
 		cache:              map[machineFlavorKey]flavorCacheEntry{},
-		staledTime:         StaledTime,
+		services:           map[cloudKey]OpenStackFlavorService{},
+		refreshTime:        RefreshTime,
 		refreshFailureTime: RefreshFailureTime,
 	}
 }
 
-func (mfc *machineFlavorsCache) getFlavorInfo(osService OpenStackInstanceService, flavorName string) *flavors.Flavor {
+// getFlavorInfo returns the flavor of the given cloud, looking it up if it is
+// not known yet. Known flavors are kept up to date by refresh.
+func (mfc *machineFlavorsCache) getFlavorInfo(osService OpenStackFlavorService, cloud cloudKey, flavorName string) *flavors.Flavor {
 	mfc.cacheMutex.Lock()
 	defer mfc.cacheMutex.Unlock()
-	if entry, ok := mfc.cache[machineFlavorKey{name: flavorName}]; ok {
-		// An entry in the cache has been found but we still need to figure out if it is valid
 
-		if entry.flavorInfoPtr != nil && time.Now().Sub(entry.updateTime) < mfc.staledTime {
-			// We have current valid entry
+	mfc.services[cloud] = osService
+
+	key := machineFlavorKey{cloud: cloud, name: flavorName}
+	if entry, ok := mfc.cache[key]; ok {
+		if entry.flavorInfoPtr != nil {
 			return entry.flavorInfoPtr
 		}
-		if entry.flavorInfoPtr == nil && time.Now().Sub(entry.updateTime) < mfc.refreshFailureTime {
+		if time.Now().Sub(entry.updateTime) < mfc.refreshFailureTime {
 			// We have an invalid entry but is too soon to try an refresh, so we return nil
 			return nil
 		}
 	}
 
-	flavorID, err := osService.GetFlavorID(flavorName)
-	if err != nil {
-		// We failed to find flavor. We populate the cache wit a nil variable and return its entry.
-		mfc.cache[machineFlavorKey{name: flavorName}] = flavorCacheEntry{
-			flavorInfoPtr: nil,
-			updateTime:    time.Now(),
-			flavorName:    flavorName,
+	mfc.cache[key] = lookupFlavor(osService, flavorName)
+	return mfc.cache[key].flavorInfoPtr
+}
+
+// evict removes the flavors and the service of a cloud which is no longer used.
+func (mfc *machineFlavorsCache) evict(cloud cloudKey) {
+	mfc.cacheMutex.Lock()
+	defer mfc.cacheMutex.Unlock()
+
+	delete(mfc.services, cloud)
+	for key := range mfc.cache {
+		if key.cloud == cloud {
+			delete(mfc.cache, key)
 		}
-		return nil
 	}
+}
 
-	flavorInfo, err := osService.GetFlavorInfo(flavorID)
-	if err != nil {
-		if err != nil {
-			// We failed to find flavor. We populate the cache wit a nil variable and return its entry.
-			mfc.cache[machineFlavorKey{name: flavorName}] = flavorCacheEntry{
-				flavorInfoPtr: nil,
-				updateTime:    time.Now(),
-				flavorName:    flavorName,
-			}
+// refresh looks up all the flavors in the cache again.
+func (mfc *machineFlavorsCache) refresh() {
+	// Take a snapshot so that the lookups are done without holding the lock.
+	mfc.cacheMutex.Lock()
+	keys := make([]machineFlavorKey, 0, len(mfc.cache))
+	services := make(map[cloudKey]OpenStackFlavorService, len(mfc.services))
+	for key := range mfc.cache {
+		keys = append(keys, key)
+	}
+	for cloud, osService := range mfc.services {
+		services[cloud] = osService
+	}
+	mfc.cacheMutex.Unlock()
+
+	for _, key := range keys {
+		osService, ok := services[key.cloud]
+		if !ok {
+			continue
+		}
+		refreshed := lookupFlavor(osService, key.name)
+
+		mfc.cacheMutex.Lock()
+		if previous, ok := mfc.cache[key]; ok && previous.flavorInfoPtr != nil && refreshed.flavorInfoPtr == nil {
+			// Keep the last known flavor, it is refreshed again on the next tick.
+			klog.Warningf("Failed to refresh flavor %q, keeping the last known value", key.name)
+			mfc.cacheMutex.Unlock()
+			continue
+		}
+		mfc.cache[key] = refreshed
+		mfc.cacheMutex.Unlock()
+	}
+}
+
+// Start refreshes the cache every refreshTime until the context is done. It
+// implements the controller-runtime Runnable interface.
+func (mfc *machineFlavorsCache) Start(ctx context.Context) error {
+	ticker := time.NewTicker(mfc.refreshTime)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
 			return nil
+		case <-ticker.C:
+			mfc.refresh()
 		}
 	}
+}
 
-	mfc.cache[machineFlavorKey{name: flavorName}] = flavorCacheEntry{
-		flavorInfoPtr: flavorInfo,
-		updateTime:    time.Now(),
-		flavorName:    flavorName,
+// lookupFlavor gets a flavor from OpenStack. A failed lookup returns an entry
+// without flavor.
+func lookupFlavor(osService OpenStackFlavorService, flavorName string) flavorCacheEntry {
+	entry := flavorCacheEntry{
+		updateTime: time.Now(),
+		flavorName: flavorName,
 	}
-	return flavorInfo
+
+	flavorID, err := osService.GetFlavorID(flavorName)
+	if err != nil {
+		return entry
+	}
+
+	flavorInfo, err := osService.GetFlavorInfo(flavorID)
+	if err != nil {
+		return entry
+	}
+
+	entry.flavorInfoPtr = flavorInfo
+	return entry
 }
@@ -73,7 +73,6 @@ func Test_machineFlavorsCache_getFlavorInfo(t *testing.T) {
 		flavorName                   string
 		flavorInfoExpected           *flavors.Flavor
 		prepCache                    []string
-		StaledTime                   time.Duration
 		RefreshFailureTime           time.Duration
 		SleepTime                    time.Duration
 		ExpectedCallsToGetFlavorInfo int
@@ -85,7 +84,6 @@ func Test_machineFlavorsCache_getFlavorInfo(t *testing.T) {
 			flavorInfoExpected:           knownNotInCacheFlavor,
 			ExpectedCallsToGetFlavorInfo: 1,
 			ExpectedCallsToGetFlavorID:   1,
-			StaledTime:                   300 * time.Second,
 			RefreshFailureTime:           60 * time.Second,
 			SleepTime:                    1 * time.Second,
 		},
@@ -96,7 +94,6 @@ func Test_machineFlavorsCache_getFlavorInfo(t *testing.T) {
 			prepCache:                    []string{knownInCacheFlavor.Name},
 			ExpectedCallsToGetFlavorInfo: 0,
 			ExpectedCallsToGetFlavorID:   0,
-			StaledTime:                   300 * time.Second,
 			RefreshFailureTime:           60 * time.Second,
 			SleepTime:                    1 * time.Second,
 		},
@@ -106,17 +103,16 @@ func Test_machineFlavorsCache_getFlavorInfo(t *testing.T) {
 			flavorInfoExpected:           nil,
 			ExpectedCallsToGetFlavorInfo: 0,
 			ExpectedCallsToGetFlavorID:   0,
-			StaledTime:                   300 * time.Second,
 			RefreshFailureTime:           60 * time.Second,
 			SleepTime:                    1 * time.Second,
 		},
 		{
-			name:                         "staled time is exceeded",
+			name:                         "valid flavor in cache is not looked up again",
 			flavorName:                   staledTimeExceededFlavor.Name,
 			flavorInfoExpected:           staledTimeExceededFlavor,
-			ExpectedCallsToGetFlavorInfo: 1,
-			ExpectedCallsToGetFlavorID:   1,
-			StaledTime:                   2 * time.Second,
+			prepCache:                    []string{staledTimeExceededFlavor.Name},
+			ExpectedCallsToGetFlavorInfo: 0,
+			ExpectedCallsToGetFlavorID:   0,
 			RefreshFailureTime:           1 * time.Second,
 			SleepTime:                    3 * time.Second,
 		},
@@ -127,7 +123,6 @@ func Test_machineFlavorsCache_getFlavorInfo(t *testing.T) {
 			prepCache:                    []string{"invalidFlavorName"},
 			ExpectedCallsToGetFlavorInfo: 0,
 			ExpectedCallsToGetFlavorID:   0,
-			StaledTime:                   300 * time.Second,
 			RefreshFailureTime:           60 * time.Second,
 			SleepTime:                    1 * time.Second,
 		},
@@ -138,7 +133,6 @@ func Test_machineFlavorsCache_getFlavorInfo(t *testing.T) {
 			prepCache:                    []string{"invalidFlavorName"},
 			ExpectedCallsToGetFlavorInfo: 0,
 			ExpectedCallsToGetFlavorID:   1,
-			StaledTime:                   300 * time.Second,
 			RefreshFailureTime:           1 * time.Second,
 			SleepTime:                    5 * time.Second,
 		},
@@ -149,15 +143,14 @@ func Test_machineFlavorsCache_getFlavorInfo(t *testing.T) {
 			mfc := newMachineFlavorCache()
 			g.Expect(mfc).ShouldNot(BeNil())
 			for _, prepFlavor := range tc.prepCache {
-				_ = mfc.getFlavorInfo(&mockOSInstance, prepFlavor)
+				_ = mfc.getFlavorInfo(&mockOSInstance, testCloud, prepFlavor)
 			}
 			g.Expect(mfc.cache).To(HaveLen(len(tc.prepCache)))
 
 			mockOSInstance.ResetCallCounts()
-			mfc.staledTime = tc.StaledTime
 			mfc.refreshFailureTime = tc.RefreshFailureTime
 			time.Sleep(tc.SleepTime)
-			flavorInfo := mfc.getFlavorInfo(&mockOSInstance, tc.flavorName)
+			flavorInfo := mfc.getFlavorInfo(&mockOSInstance, testCloud, tc.flavorName)
 			g.Expect(flavorInfo).To(Equal(tc.flavorInfoExpected))
 			g.Expect(mockOSInstance.GetFlavorInfoCalled).To(Equal(tc.ExpectedCallsToGetFlavorInfo))
 			//g.Expect(mockOSInstance.GetFlavorIDCalled != tc.ExpectedCallsToGetFlavorID)
@@ -165,3 +158,50 @@ func Test_machineFlavorsCache_getFlavorInfo(t *testing.T) {
 
 	}
 }
+
+func Test_machineFlavorsCache_refresh(t *testing.T) {
+	g := NewWithT(t)
+
+	updatedFlavor := &flavors.Flavor{ID: knownInCacheFlavor.ID, Name: knownInCacheFlavor.Name, VCPUs: 8}
+	osService := &MockCacheOpenStackInstanceService{flavors: []*flavors.Flavor{knownInCacheFlavor}}
+
+	mfc := newMachineFlavorCache()
+	g.Expect(mfc.getFlavorInfo(osService, testCloud, knownInCacheFlavor.Name)).To(Equal(knownInCacheFlavor))
+	g.Expect(mfc.getFlavorInfo(osService, testCloud, "invalidFlavorName")).To(BeNil())
+
+	osService.flavors = []*flavors.Flavor{updatedFlavor}
+	osService.ResetCallCounts()
+	mfc.refresh()
+
+	// Every known flavor is looked up again, including the failed one
+	g.Expect(osService.GetFlavorIDCalled).To(Equal(2))
+	g.Expect(mfc.getFlavorInfo(osService, testCloud, knownInCacheFlavor.Name)).To(Equal(updatedFlavor))
+}
+
+func Test_machineFlavorsCache_clouds(t *testing.T) {
+	g := NewWithT(t)
+
+	otherRegion := testCloud
+	otherRegion.region = "regionTwo"
+	otherFlavor := &flavors.Flavor{ID: "otherRegionFlavorId", Name: knownInCacheFlavor.Name, VCPUs: 16}
+	otherService := &MockCacheOpenStackInstanceService{flavors: []*flavors.Flavor{otherFlavor}}
+
+	mfc := newMachineFlavorCache()
+	g.Expect(mfc.getFlavorInfo(&mockOSInstance, testCloud, knownInCacheFlavor.Name)).To(Equal(knownInCacheFlavor))
+	g.Expect(mfc.getFlavorInfo(otherService, otherRegion, knownInCacheFlavor.Name)).To(Equal(otherFlavor))
+	g.Expect(mfc.cache).To(HaveLen(2))
+}
+
+func Test_machineFlavorsCache_refreshFailure(t *testing.T) {
+	g := NewWithT(t)
+
+	osService := &MockCacheOpenStackInstanceService{flavors: []*flavors.Flavor{knownInCacheFlavor}}
+
+	mfc := newMachineFlavorCache()
+	g.Expect(mfc.getFlavorInfo(osService, testCloud, knownInCacheFlavor.Name)).To(Equal(knownInCacheFlavor))
+
+	// A failed refresh keeps the last known flavor
+	osService.flavors = nil
+	mfc.refresh()
+	g.Expect(mfc.getFlavorInfo(osService, testCloud, knownInCacheFlavor.Name)).To(Equal(knownInCacheFlavor))
+}
@@ -0,0 +1,116 @@
+package machineset
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+const (
+	// Flavor extra specs describing the devices and architecture of a server
+	vgpuExtraSpec           = "resources:VGPU"
+	pciPassthroughExtraSpec = "pci_passthrough:alias"
+	cpuArchExtraSpec        = "hw:cpu_arch"
+
+	// Image property describing the architecture of the image
+	architectureImageProperty = "hw_architecture"
+
+	archLabel   = "kubernetes.io/arch"
+	defaultArch = "amd64"
+)
+
+// flavorGPUs returns the number of virtual GPUs and GPU PCI passthrough
+// devices requested by the flavor extra specs. Only the PCI passthrough
+// aliases listed in gpuAliases are GPUs, other devices such as NICs are not.
+func flavorGPUs(extraSpecs map[string]string, gpuAliases []string) (int, error) {
+	gpus := 0
+
+	if vgpus, ok := extraSpecs[vgpuExtraSpec]; ok {
+		count, err := strconv.Atoi(strings.TrimSpace(vgpus))
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s extra spec %q: %v", vgpuExtraSpec, vgpus, err)
+		}
+		gpus += count
+	}
+
+	// The alias extra spec is a comma separated list of alias:count, the
+	// count defaulting to 1.
+	if aliases, ok := extraSpecs[pciPassthroughExtraSpec]; ok {
+		for _, alias := range strings.Split(aliases, ",") {
+			alias = strings.TrimSpace(alias)
+			if alias == "" {
+				continue
+			}
+			name, count := alias, 1
+			if i := strings.LastIndex(alias, ":"); i != -1 {
+				var err error
+				name = strings.TrimSpace(alias[:i])
+				count, err = strconv.Atoi(alias[i+1:])
+				if err != nil {
+					return 0, fmt.Errorf("invalid %s extra spec %q: %v", pciPassthroughExtraSpec, aliases, err)
+				}
+			}
+			for _, gpuAlias := range gpuAliases {
+				if name == gpuAlias {
+					gpus += count
+					break
+				}
+			}
+		}
+	}
+
+	return gpus, nil
+}
+
+// architecture returns the Kubernetes architecture of the servers, taken from
+// the flavor extra specs or else from the image properties.
+func architecture(extraSpecs map[string]string, imageProperties map[string]interface{}) (string, error) {
+	arch, ok := extraSpecs[cpuArchExtraSpec]
+	if !ok {
+		if value, found := imageProperties[architectureImageProperty]; found {
+			arch, ok = value.(string)
+		}
+	}
+	if !ok || arch == "" {
+		return defaultArch, nil
+	}
+
+	kubeArch, known := clients.KubernetesArch(arch)
+	if !known {
+		return "", fmt.Errorf("unsupported architecture %q", arch)
+	}
+	return kubeArch, nil
+}
+
+// ephemeralDiskGB returns the size of the local storage of the servers: their
+// root disk and the ephemeral disk of the flavor.
+func ephemeralDiskGB(pSpec *openstackconfigv1.OpenstackProviderSpec, flavor *flavors.Flavor) int {
+	if pSpec.RootVolume != nil && pSpec.RootVolume.Size != 0 {
+		return pSpec.RootVolume.Size + flavor.Ephemeral
+	}
+	return flavor.Disk + flavor.Ephemeral
+}
+
+// setLabel sets a label in an annotation holding comma separated key=value
+// pairs, keeping the other labels.
+func setLabel(annotation, key, value string) string {
+	labels := map[string]string{}
+	for _, label := range strings.Split(annotation, ",") {
+		if kv := strings.SplitN(strings.TrimSpace(label), "=", 2); len(kv) == 2 {
+			labels[kv[0]] = kv[1]
+		}
+	}
+	labels[key] = value
+
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
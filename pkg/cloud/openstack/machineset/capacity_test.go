@@ -0,0 +1,100 @@
+package machineset
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestFlavorGPUs(t *testing.T) {
+	testCases := []struct {
+		name       string
+		extraSpecs map[string]string
+		expected   int
+		expectErr  bool
+	}{
+		{name: "no devices", extraSpecs: map[string]string{"hw:cpu_policy": "dedicated"}, expected: 0},
+		{name: "virtual GPUs", extraSpecs: map[string]string{vgpuExtraSpec: "2"}, expected: 2},
+		{name: "PCI passthrough aliases", extraSpecs: map[string]string{pciPassthroughExtraSpec: "a100:2, t4"}, expected: 3},
+		{name: "virtual GPUs and PCI passthrough", extraSpecs: map[string]string{vgpuExtraSpec: "1", pciPassthroughExtraSpec: "a100:4"}, expected: 5},
+		{name: "other PCI passthrough devices", extraSpecs: map[string]string{pciPassthroughExtraSpec: "a100:2, mlx5-nic:2"}, expected: 2},
+		{name: "no GPU alias", extraSpecs: map[string]string{pciPassthroughExtraSpec: "mlx5-nic"}, expected: 0},
+		{name: "invalid virtual GPU count", extraSpecs: map[string]string{vgpuExtraSpec: "two"}, expectErr: true},
+		{name: "invalid alias count", extraSpecs: map[string]string{pciPassthroughExtraSpec: "a100:x"}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := flavorGPUs(tc.extraSpecs, []string{"a100", "t4"})
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+			}
+			if got != tc.expected {
+				t.Errorf("expected %d GPUs, got %d", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestArchitecture(t *testing.T) {
+	testCases := []struct {
+		name            string
+		extraSpecs      map[string]string
+		imageProperties map[string]interface{}
+		expected        string
+		expectErr       bool
+	}{
+		{name: "default", expected: "amd64"},
+		{name: "from flavor", extraSpecs: map[string]string{cpuArchExtraSpec: "aarch64"}, expected: "arm64"},
+		{name: "from image", imageProperties: map[string]interface{}{architectureImageProperty: "ppc64le"}, expected: "ppc64le"},
+		{
+			name:            "flavor takes precedence",
+			extraSpecs:      map[string]string{cpuArchExtraSpec: "x86_64"},
+			imageProperties: map[string]interface{}{architectureImageProperty: "aarch64"},
+			expected:        "amd64",
+		},
+		{name: "unknown architecture", extraSpecs: map[string]string{cpuArchExtraSpec: "mips"}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := architecture(tc.extraSpecs, tc.imageProperties)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+			}
+			if got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestSetLabel(t *testing.T) {
+	testCases := []struct {
+		annotation string
+		expected   string
+	}{
+		{annotation: "", expected: "kubernetes.io/arch=arm64"},
+		{annotation: "kubernetes.io/arch=amd64", expected: "kubernetes.io/arch=arm64"},
+		{annotation: "zone=a, disk=ssd", expected: "disk=ssd,kubernetes.io/arch=arm64,zone=a"},
+	}
+
+	for _, tc := range testCases {
+		if got := setLabel(tc.annotation, archLabel, "arm64"); got != tc.expected {
+			t.Errorf("setLabel(%q): expected %q, got %q", tc.annotation, tc.expected, got)
+		}
+	}
+}
+
+func TestEphemeralDiskGB(t *testing.T) {
+	flavor := &flavors.Flavor{Disk: 40, Ephemeral: 20}
+
+	if got := ephemeralDiskGB(&openstackconfigv1.OpenstackProviderSpec{}, flavor); got != 60 {
+		t.Errorf("expected 60, got %d", got)
+	}
+	rootVolume := &openstackconfigv1.RootVolume{Size: 100}
+	if got := ephemeralDiskGB(&openstackconfigv1.OpenstackProviderSpec{RootVolume: rootVolume}, flavor); got != 120 {
+		t.Errorf("expected 120, got %d", got)
+	}
+}
@@ -1,17 +1,25 @@
 package machineset
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"reflect"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/servergroups"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/gophercloud/utils/openstack/clientconfig"
 	machinev1 "github.com/openshift/api/machine/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
 	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
@@ -27,21 +35,64 @@ const (
 	// https://github.com/openshift/enhancements/pull/186
 	cpuKey    = "machine.openshift.io/vCPU"
 	memoryKey = "machine.openshift.io/memoryMb"
+	gpuKey    = "machine.openshift.io/GPU"
+
+	// Scale from zero annotations read by the cluster autoscaler.
+	labelsKey        = "capacity.cluster-autoscaler.kubernetes.io/labels"
+	ephemeralDiskKey = "capacity.cluster-autoscaler.kubernetes.io/ephemeral-disk"
+
+	// quotaKey is set when the project quotas do not leave room for one more
+	// machine of the MachineSet, and holds the quotas which are exceeded.
+	quotaKey = "machine.openshift.io/openstack-quota-exceeded"
 )
 
-type OpenStackInstanceService interface {
+type OpenStackFlavorService interface {
 	GetFlavorID(flavorName string) (string, error)
 	GetFlavorInfo(flavorID string) (flavor *flavors.Flavor, err error)
 }
 
+type OpenStackInstanceService interface {
+	OpenStackFlavorService
+	GetFlavorExtraSpecs(flavorID string) (map[string]string, error)
+	FindImage(imageName string, filter *openstackconfigv1.ImageFilter) (*images.Image, error)
+	DoesAvailabilityZoneExist(azName string) error
+	DoNetworksExist(nets []openstackconfigv1.NetworkParam) error
+	DoSecurityGroupsExist(sgParams []openstackconfigv1.SecurityGroupParam) error
+	GetServerGroup(serverGroupID, serverGroupName string) (*servergroups.ServerGroup, error)
+	CheckQuota(request clients.QuotaRequest) ([]clients.QuotaShortage, error)
+}
+
 type Reconciler struct {
-	Client          client.Client
-	Log             logr.Logger
-	eventRecorder   record.EventRecorder
-	scheme          *runtime.Scheme
-	kubeClient      *kubernetes.Clientset
-	instanceService OpenStackInstanceService
-	flavorCache     *machineFlavorsCache
+	Client client.Client
+	Log    logr.Logger
+
+	// GPUPCIAliases are the PCI passthrough aliases of flavors whose devices
+	// are GPUs.
+	GPUPCIAliases []string
+
+	eventRecorder record.EventRecorder
+	scheme        *runtime.Scheme
+	kubeClient    *kubernetes.Clientset
+	flavorCache   *machineFlavorsCache
+
+	// getInstanceService returns the cloud of a MachineSet and the instance
+	// service to use with it. It defaults to instanceServiceFor.
+	getInstanceService func(machineSet *machinev1.MachineSet) (cloudKey, OpenStackInstanceService, error)
+
+	instanceServicesMutex sync.Mutex
+	instanceServices      map[cloudKey]cachedInstanceService
+	// machineSetClouds is the cloud each MachineSet was last reconciled
+	// with, so that the services of a cloud are evicted once no MachineSet
+	// uses it anymore.
+	machineSetClouds map[types.NamespacedName]cloudKey
+}
+
+// cachedInstanceService is an instance service together with the credentials
+// it was created with, so that it is replaced when they are rotated.
+type cachedInstanceService struct {
+	cloud   clientconfig.Cloud
+	caCert  []byte
+	service OpenStackInstanceService
 }
 
 // Reconcile implements controller runtime Reconciler interface.
@@ -53,6 +104,7 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrlRuntime.Request) (ct
 	machineSet := &machinev1.MachineSet{}
 	if err := r.Client.Get(ctx, req.NamespacedName, machineSet); err != nil {
 		if apierrors.IsNotFound(err) {
+			r.setMachineSetCloud(req.NamespacedName, nil)
 			return ctrlRuntime.Result{}, nil
 		}
 		return ctrlRuntime.Result{}, err
@@ -61,22 +113,20 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrlRuntime.Request) (ct
 	// Ignore deleted MachineSets, this can happen when foregroundDeletion
 	// is enabled
 	if !machineSet.DeletionTimestamp.IsZero() {
+		r.setMachineSetCloud(req.NamespacedName, nil)
 		return ctrlRuntime.Result{}, nil
 	}
 
 	originalMachineSetPatch := client.MergeFrom(machineSet.DeepCopy())
 
-	if r.instanceService == nil {
-		//Create the OpenStack InstanceService if we don't have one already.
-		m := &machinev1.Machine{Spec: machineSet.Spec.Template.Spec}
-		is, err := clients.NewInstanceServiceFromMachine(r.kubeClient, m)
-		if err != nil {
-			return ctrlRuntime.Result{}, fmt.Errorf("failed to get InstanceService: %v", err)
-		}
-		r.instanceService = is
+	cloud, instanceService, err := r.getInstanceService(machineSet)
+	if err != nil {
+		return ctrlRuntime.Result{}, fmt.Errorf("failed to get InstanceService: %v", err)
 	}
+	r.setMachineSetCloud(req.NamespacedName, &cloud)
+
 	//reconcile the machine set and patch  even if reconcile failed.
-	result, err := r.reconcile(machineSet)
+	result, err := r.reconcile(machineSet, cloud, instanceService)
 	if err != nil {
 		logger.Error(err, "Failed to reconcile MachineSet %q", machineSet.Name)
 		r.eventRecorder.Eventf(machineSet, corev1.EventTypeWarning, "ReconcileError", "%v", err)
@@ -93,7 +143,83 @@ func requeueTime() time.Duration {
 	// retrying to refresh the information of a failed look up.
 	return RefreshFailureTime / 2
 }
-func (r *Reconciler) reconcile(machineSet *machinev1.MachineSet) (ctrlRuntime.Result, error) {
+
+// instanceServiceFor returns the cloud of a MachineSet and an instance service
+// for it, reusing the instance service of MachineSets using the same cloud.
+func (r *Reconciler) instanceServiceFor(machineSet *machinev1.MachineSet) (cloudKey, OpenStackInstanceService, error) {
+	pSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machineSet.Spec.Template.Spec.ProviderSpec)
+	if err != nil {
+		return cloudKey{}, nil, fmt.Errorf("failed to get OpenStackProviderSpec from machineset: %v", err)
+	}
+	m := &machinev1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Namespace: machineSet.Namespace},
+		Spec:       machineSet.Spec.Template.Spec,
+	}
+	cloud, err := clients.GetCloud(r.kubeClient, m)
+	if err != nil {
+		return cloudKey{}, nil, err
+	}
+
+	key := cloudKey{
+		secretNamespace: pSpec.CloudsSecret.Namespace,
+		secretName:      pSpec.CloudsSecret.Name,
+		cloudName:       pSpec.CloudName,
+		region:          cloud.RegionName,
+	}
+	if key.secretNamespace == "" {
+		key.secretNamespace = machineSet.Namespace
+	}
+
+	r.instanceServicesMutex.Lock()
+	defer r.instanceServicesMutex.Unlock()
+
+	caCert := clients.GetCACertificate(r.kubeClient)
+	if cached, ok := r.instanceServices[key]; ok && reflect.DeepEqual(cached.cloud, cloud) && bytes.Equal(cached.caCert, caCert) {
+		return key, cached.service, nil
+	}
+	is, err := clients.NewInstanceServiceFromCloud(cloud, caCert)
+	if err != nil {
+		return cloudKey{}, nil, err
+	}
+	if r.instanceServices == nil {
+		r.instanceServices = make(map[cloudKey]cachedInstanceService)
+	}
+	r.instanceServices[key] = cachedInstanceService{cloud: cloud, caCert: caCert, service: is}
+	return key, is, nil
+}
+
+// setMachineSetCloud records the cloud a MachineSet uses, nil once it is
+// deleted. The instance service and flavors of the cloud it used before are
+// evicted when no other MachineSet uses that cloud.
+func (r *Reconciler) setMachineSetCloud(name types.NamespacedName, cloud *cloudKey) {
+	r.instanceServicesMutex.Lock()
+	defer r.instanceServicesMutex.Unlock()
+
+	previous, ok := r.machineSetClouds[name]
+	if cloud != nil {
+		if r.machineSetClouds == nil {
+			r.machineSetClouds = make(map[types.NamespacedName]cloudKey)
+		}
+		r.machineSetClouds[name] = *cloud
+	} else {
+		delete(r.machineSetClouds, name)
+	}
+	if !ok || (cloud != nil && previous == *cloud) {
+		return
+	}
+
+	for _, used := range r.machineSetClouds {
+		if used == previous {
+			return
+		}
+	}
+	delete(r.instanceServices, previous)
+	if r.flavorCache != nil {
+		r.flavorCache.evict(previous)
+	}
+}
+
+func (r *Reconciler) reconcile(machineSet *machinev1.MachineSet, cloud cloudKey, instanceService OpenStackInstanceService) (ctrlRuntime.Result, error) {
 	pSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machineSet.Spec.Template.Spec.ProviderSpec)
 	if err != nil {
 		return ctrlRuntime.Result{}, fmt.Errorf("failed to get OpenStackProviderSpec from machineset: %v", err)
@@ -106,7 +232,8 @@ func (r *Reconciler) reconcile(machineSet *machinev1.MachineSet) (ctrlRuntime.Re
 		machineSet.Annotations = make(map[string]string)
 	}
 
-	flavorInfo := r.flavorCache.getFlavorInfo(r.instanceService, pSpec.Flavor)
+	flavorInfo := r.flavorCache.getFlavorInfo(instanceService, cloud, pSpec.Flavor)
+	r.reconcileValidation(machineSet, instanceService, pSpec, flavorInfo)
 	if flavorInfo == nil {
 		// At this time we don't have enough information to set correct annotations
 		// so we inform the controller it needs to requeue the request.
@@ -119,9 +246,73 @@ func (r *Reconciler) reconcile(machineSet *machinev1.MachineSet) (ctrlRuntime.Re
 	machineSet.Annotations[cpuKey] = strconv.Itoa(flavorInfo.VCPUs)
 	machineSet.Annotations[memoryKey] = strconv.Itoa(flavorInfo.RAM)
 
+	if err := r.reconcileCapacity(machineSet, instanceService, pSpec, flavorInfo); err != nil {
+		return ctrlRuntime.Result{
+			Requeue:      true,
+			RequeueAfter: requeueTime(),
+		}, err
+	}
+
+	r.reconcileQuota(machineSet, instanceService, pSpec, flavorInfo)
+
 	return ctrlRuntime.Result{}, nil
 }
 
+// reconcileCapacity sets the GPU, architecture and disk annotations from the
+// flavor extra specs and the image properties.
+func (r *Reconciler) reconcileCapacity(machineSet *machinev1.MachineSet, instanceService OpenStackInstanceService, pSpec *openstackconfigv1.OpenstackProviderSpec, flavorInfo *flavors.Flavor) error {
+	extraSpecs, err := instanceService.GetFlavorExtraSpecs(flavorInfo.ID)
+	if err != nil {
+		return fmt.Errorf("could not get extra specs of flavor %q: %v", pSpec.Flavor, err)
+	}
+
+	gpus, err := flavorGPUs(extraSpecs, r.GPUPCIAliases)
+	if err != nil {
+		return fmt.Errorf("flavor %q: %v", pSpec.Flavor, err)
+	}
+	machineSet.Annotations[gpuKey] = strconv.Itoa(gpus)
+
+	var imageProperties map[string]interface{}
+	imageName, imageFilter := clients.ImageOf(pSpec)
+	if _, ok := extraSpecs[cpuArchExtraSpec]; !ok && (imageName != "" || imageFilter != nil) {
+		image, err := instanceService.FindImage(imageName, imageFilter)
+		if err != nil {
+			return fmt.Errorf("could not get image %q: %v", imageName, err)
+		}
+		imageProperties = image.Properties
+	}
+	arch, err := architecture(extraSpecs, imageProperties)
+	if err != nil {
+		return err
+	}
+	machineSet.Annotations[labelsKey] = setLabel(machineSet.Annotations[labelsKey], archLabel, arch)
+
+	if disk := ephemeralDiskGB(pSpec, flavorInfo); disk > 0 {
+		machineSet.Annotations[ephemeralDiskKey] = fmt.Sprintf("%dGi", disk)
+	}
+
+	return nil
+}
+
+// reconcileQuota sets the quota annotation when the project quotas do not
+// leave room for one more machine. The annotation is left untouched when the
+// quotas cannot be read.
+func (r *Reconciler) reconcileQuota(machineSet *machinev1.MachineSet, instanceService OpenStackInstanceService, pSpec *openstackconfigv1.OpenstackProviderSpec, flavorInfo *flavors.Flavor) {
+	shortages, err := instanceService.CheckQuota(clients.NewQuotaRequest(pSpec, flavorInfo))
+	if err != nil {
+		if r.Log.GetSink() != nil {
+			r.Log.V(3).Info("Could not check quota", "machineset", machineSet.Name, "error", err.Error())
+		}
+		return
+	}
+
+	if len(shortages) == 0 {
+		delete(machineSet.Annotations, quotaKey)
+		return
+	}
+	machineSet.Annotations[quotaKey] = clients.QuotaShortagesMessage(shortages)
+}
+
 // SetupWithManager creates a new controller for a manager.
 func (r *Reconciler) SetupWithManager(mgr ctrlRuntime.Manager, options controller.Options) error {
 	err := ctrlRuntime.NewControllerManagedBy(mgr).
@@ -145,7 +336,15 @@ func (r *Reconciler) SetupWithManager(mgr ctrlRuntime.Manager, options controlle
 	if err != nil {
 		return fmt.Errorf("could not create kubernetes client to talk to the API server: %w", err)
 	}
-	r.flavorCache = newMachineFlavorCache()
+	if r.getInstanceService == nil {
+		r.getInstanceService = r.instanceServiceFor
+	}
+	if r.flavorCache == nil {
+		r.flavorCache = newMachineFlavorCache()
+	}
+	if err := mgr.Add(r.flavorCache); err != nil {
+		return fmt.Errorf("could not add the flavor cache to the manager: %w", err)
+	}
 
 	return nil
 }
@@ -8,7 +8,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/servergroups"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
@@ -17,13 +19,22 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	machineproviderv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
+var testCloud = cloudKey{
+	secretNamespace: "openshift-machine-api",
+	secretName:      "openstack-cloud-credentials",
+	cloudName:       "openstack",
+	region:          "regionOne",
+}
+
 var emptyFlavorName = ""
 var validFlavorName = "mock.xlarge"
 var invalidFlavorName = "mock.invalid"
@@ -40,8 +51,21 @@ var mockFlavor = flavors.Flavor{
 	Ephemeral:  0,
 }
 
+var mockImage = images.Image{
+	ID:         "mock-image-id",
+	Name:       "mock-arm-image",
+	Status:     images.ImageStatusActive,
+	Properties: map[string]interface{}{"hw_architecture": "aarch64"},
+}
+
 type MockInstanceService struct {
-	flavor *flavors.Flavor
+	flavor      *flavors.Flavor
+	extraSpecs  map[string]string
+	image       *images.Image
+	networksErr error
+	serverGroup *servergroups.ServerGroup
+	shortages   []clients.QuotaShortage
+	quotaErr    error
 }
 
 func (mock *MockInstanceService) GetFlavorID(flavorName string) (string, error) {
@@ -58,6 +82,46 @@ func (mock *MockInstanceService) GetFlavorInfo(flavorID string) (flavor *flavors
 	return &flavors.Flavor{}, fmt.Errorf("flavor ID %q not found", flavorID)
 }
 
+func (mock *MockInstanceService) GetFlavorExtraSpecs(flavorID string) (map[string]string, error) {
+	if flavorID == mock.flavor.ID {
+		return mock.extraSpecs, nil
+	}
+	return nil, fmt.Errorf("flavor ID %q not found", flavorID)
+}
+
+func (mock *MockInstanceService) FindImage(imageName string, filter *machineproviderv1.ImageFilter) (*images.Image, error) {
+	if imageName == "" && filter != nil {
+		if mock.image != nil && filter.Name == mock.image.Name {
+			return mock.image, nil
+		}
+		return nil, fmt.Errorf("no active image matches the image filter")
+	}
+	if mock.image != nil && imageName == mock.image.Name {
+		return mock.image, nil
+	}
+	return nil, fmt.Errorf("image %q not found", imageName)
+}
+
+func (mock *MockInstanceService) DoesAvailabilityZoneExist(azName string) error {
+	return nil
+}
+
+func (mock *MockInstanceService) DoNetworksExist(nets []machineproviderv1.NetworkParam) error {
+	return mock.networksErr
+}
+
+func (mock *MockInstanceService) DoSecurityGroupsExist(sgParams []machineproviderv1.SecurityGroupParam) error {
+	return nil
+}
+
+func (mock *MockInstanceService) GetServerGroup(serverGroupID, serverGroupName string) (*servergroups.ServerGroup, error) {
+	return mock.serverGroup, nil
+}
+
+func (mock *MockInstanceService) CheckQuota(request clients.QuotaRequest) ([]clients.QuotaShortage, error) {
+	return mock.shortages, mock.quotaErr
+}
+
 func RandomString(prefix string, n int) string {
 	const alphanum = "0123456789abcdefghijklmnopqrstuvwxyz"
 	var bytes = make([]byte, n)
@@ -85,14 +149,16 @@ var _ = Describe("Reconciler", func() {
 		Expect(err).ToNot(HaveOccurred())
 
 		r := Reconciler{
-			instanceService: suiteInstanceService,
+			getInstanceService: func(*machinev1.MachineSet) (cloudKey, OpenStackInstanceService, error) {
+				return testCloud, suiteInstanceService, nil
+			},
+			flavorCache: suiteFlavorCache,
 		}
 
 		Expect(r.SetupWithManager(mgr, controller.Options{})).To(Succeed())
 
 		fakeRecorder = record.NewFakeRecorder(4)
 		r.eventRecorder = fakeRecorder
-		r.flavorCache = suiteFlavorCache
 		c = mgr.GetClient()
 		StartTestManager(mgr)
 
@@ -148,8 +214,12 @@ var _ = Describe("Reconciler", func() {
 			machineFlavor:       validFlavorName,
 			existingAnnotations: make(map[string]string),
 			expectedAnnotations: map[string]string{
-				cpuKey:    strconv.Itoa(mockFlavor.VCPUs),
-				memoryKey: strconv.Itoa(mockFlavor.RAM),
+				cpuKey:           strconv.Itoa(mockFlavor.VCPUs),
+				memoryKey:        strconv.Itoa(mockFlavor.RAM),
+				gpuKey:           "0",
+				labelsKey:        "kubernetes.io/arch=amd64",
+				ephemeralDiskKey: "200Gi",
+				templateValidKey: "True",
 			},
 			expectedEvents: []string{},
 		}),
@@ -160,10 +230,14 @@ var _ = Describe("Reconciler", func() {
 				"annother": "existingAnnotation",
 			},
 			expectedAnnotations: map[string]string{
-				"existing": "annotation",
-				"annother": "existingAnnotation",
-				cpuKey:     strconv.Itoa(mockFlavor.VCPUs),
-				memoryKey:  strconv.Itoa(mockFlavor.RAM),
+				"existing":       "annotation",
+				"annother":       "existingAnnotation",
+				cpuKey:           strconv.Itoa(mockFlavor.VCPUs),
+				memoryKey:        strconv.Itoa(mockFlavor.RAM),
+				gpuKey:           "0",
+				labelsKey:        "kubernetes.io/arch=amd64",
+				ephemeralDiskKey: "200Gi",
+				templateValidKey: "True",
 			},
 			expectedEvents: []string{},
 		}),
@@ -180,10 +254,12 @@ var _ = Describe("Reconciler", func() {
 				"annother": "existingAnnotation",
 			},
 			expectedAnnotations: map[string]string{
-				"existing": "annotation",
-				"annother": "existingAnnotation",
+				"existing":         "annotation",
+				"annother":         "existingAnnotation",
+				templateValidKey:   "False",
+				templateMessageKey: `could not find flavor "mock.invalid"`,
 			},
-			expectedEvents: []string{"ReconcileError"},
+			expectedEvents: []string{"ReconcileError", "InvalidTemplate"},
 		}),
 	)
 })
@@ -237,10 +313,14 @@ func TestReconcile(t *testing.T) {
 				"annother": "existingAnnotation",
 			},
 			expectedAnnotations: map[string]string{
-				"existing": "annotation",
-				"annother": "existingAnnotation",
-				cpuKey:     strconv.Itoa(mockFlavor.VCPUs),
-				memoryKey:  strconv.Itoa(mockFlavor.RAM),
+				"existing":       "annotation",
+				"annother":       "existingAnnotation",
+				cpuKey:           strconv.Itoa(mockFlavor.VCPUs),
+				memoryKey:        strconv.Itoa(mockFlavor.RAM),
+				gpuKey:           "0",
+				labelsKey:        "kubernetes.io/arch=amd64",
+				ephemeralDiskKey: "200Gi",
+				templateValidKey: "True",
 			},
 			expectErr: false,
 		},
@@ -252,8 +332,10 @@ func TestReconcile(t *testing.T) {
 				"annother": "existingAnnotation",
 			},
 			expectedAnnotations: map[string]string{
-				"existing": "annotation",
-				"annother": "existingAnnotation",
+				"existing":         "annotation",
+				"annother":         "existingAnnotation",
+				templateValidKey:   "False",
+				templateMessageKey: `could not find flavor "mock.invalid"`,
 			},
 			expectErr: true,
 		},
@@ -269,8 +351,12 @@ func TestReconcile(t *testing.T) {
 			flavor:              validFlavorName,
 			existingAnnotations: make(map[string]string),
 			expectedAnnotations: map[string]string{
-				cpuKey:    strconv.Itoa(mockFlavor.VCPUs),
-				memoryKey: strconv.Itoa(mockFlavor.RAM),
+				cpuKey:           strconv.Itoa(mockFlavor.VCPUs),
+				memoryKey:        strconv.Itoa(mockFlavor.RAM),
+				gpuKey:           "0",
+				labelsKey:        "kubernetes.io/arch=amd64",
+				ephemeralDiskKey: "200Gi",
+				templateValidKey: "True",
 			},
 			expectErr: false,
 		},
@@ -281,10 +367,10 @@ func TestReconcile(t *testing.T) {
 			g := NewWithT(tt)
 
 			//Create reconciler
+			instanceService := &MockInstanceService{
+				flavor: &mockFlavor,
+			}
 			r := Reconciler{
-				instanceService: &MockInstanceService{
-					flavor: &mockFlavor,
-				},
 				flavorCache: newMachineFlavorCache(),
 			}
 
@@ -293,13 +379,282 @@ func TestReconcile(t *testing.T) {
 			g.Expect(err).ToNot(HaveOccurred())
 
 			//Use the reconciler we create to reconcile the machineset
-			_, err = r.reconcile(machineSet)
+			_, err = r.reconcile(machineSet, testCloud, instanceService)
 			g.Expect(err != nil).To(Equal(tc.expectErr))
 			g.Expect(machineSet.Annotations).To(Equal(tc.expectedAnnotations))
 		})
 	}
 }
 
+func TestReconcileCapacity(t *testing.T) {
+	testCases := []struct {
+		name                string
+		extraSpecs          map[string]string
+		image               string
+		imageFilter         *machineproviderv1.ImageFilter
+		rootVolume          *machineproviderv1.RootVolume
+		expectedAnnotations map[string]string
+		expectErr           bool
+	}{
+		{
+			name:       "with GPU flavor",
+			extraSpecs: map[string]string{"resources:VGPU": "1", "pci_passthrough:alias": "a100:2"},
+			expectedAnnotations: map[string]string{
+				cpuKey:           strconv.Itoa(mockFlavor.VCPUs),
+				memoryKey:        strconv.Itoa(mockFlavor.RAM),
+				gpuKey:           "3",
+				labelsKey:        "kubernetes.io/arch=amd64",
+				ephemeralDiskKey: "200Gi",
+				templateValidKey: "True",
+			},
+		},
+		{
+			name:  "with image architecture",
+			image: mockImage.Name,
+			expectedAnnotations: map[string]string{
+				cpuKey:           strconv.Itoa(mockFlavor.VCPUs),
+				memoryKey:        strconv.Itoa(mockFlavor.RAM),
+				gpuKey:           "0",
+				labelsKey:        "kubernetes.io/arch=arm64",
+				ephemeralDiskKey: "200Gi",
+				templateValidKey: "True",
+			},
+		},
+		{
+			name:        "with image filter",
+			imageFilter: &machineproviderv1.ImageFilter{Name: mockImage.Name},
+			expectedAnnotations: map[string]string{
+				cpuKey:           strconv.Itoa(mockFlavor.VCPUs),
+				memoryKey:        strconv.Itoa(mockFlavor.RAM),
+				gpuKey:           "0",
+				labelsKey:        "kubernetes.io/arch=arm64",
+				ephemeralDiskKey: "200Gi",
+				templateValidKey: "True",
+			},
+		},
+		{
+			name:       "with flavor architecture and root volume",
+			extraSpecs: map[string]string{"hw:cpu_arch": "s390x"},
+			rootVolume: &machineproviderv1.RootVolume{SourceType: "image", SourceUUID: mockImage.Name, Size: 50},
+			expectedAnnotations: map[string]string{
+				cpuKey:           strconv.Itoa(mockFlavor.VCPUs),
+				memoryKey:        strconv.Itoa(mockFlavor.RAM),
+				gpuKey:           "0",
+				labelsKey:        "kubernetes.io/arch=s390x",
+				ephemeralDiskKey: "50Gi",
+				templateValidKey: "True",
+			},
+		},
+		{
+			name:  "with unknown image",
+			image: "unknown",
+			expectedAnnotations: map[string]string{
+				cpuKey:             strconv.Itoa(mockFlavor.VCPUs),
+				memoryKey:          strconv.Itoa(mockFlavor.RAM),
+				gpuKey:             "0",
+				templateValidKey:   "False",
+				templateMessageKey: `could not find image "unknown": image "unknown" not found`,
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			g := NewWithT(tt)
+
+			instanceService := &MockInstanceService{
+				flavor:     &mockFlavor,
+				extraSpecs: tc.extraSpecs,
+				image:      &mockImage,
+			}
+			r := Reconciler{
+				flavorCache:   newMachineFlavorCache(),
+				GPUPCIAliases: []string{"a100"},
+			}
+
+			machineSet, err := newTestMachineSet("default", validFlavorName, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			pSpec, err := machineproviderv1.MachineSpecFromProviderSpec(machineSet.Spec.Template.Spec.ProviderSpec)
+			g.Expect(err).ToNot(HaveOccurred())
+			pSpec.Image = tc.image
+			pSpec.ImageFilter = tc.imageFilter
+			pSpec.RootVolume = tc.rootVolume
+			machineSet.Spec.Template.Spec.ProviderSpec, err = providerSpecFromMachine(pSpec)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.reconcile(machineSet, testCloud, instanceService)
+			g.Expect(err != nil).To(Equal(tc.expectErr))
+			g.Expect(machineSet.Annotations).To(Equal(tc.expectedAnnotations))
+		})
+	}
+}
+
+func TestReconcileValidation(t *testing.T) {
+	softAffinity := "soft-affinity"
+
+	testCases := []struct {
+		name                string
+		image               string
+		networksErr         error
+		serverGroup         *servergroups.ServerGroup
+		existingAnnotations map[string]string
+		expectedValid       string
+		expectedMessage     string
+		expectedEvents      []string
+	}{
+		{
+			name:          "with valid template",
+			image:         mockImage.Name,
+			expectedValid: "True",
+		},
+		{
+			name:            "with missing network",
+			networksErr:     fmt.Errorf("could not find network \"private\""),
+			expectedValid:   "False",
+			expectedMessage: `could not find network "private"`,
+			expectedEvents:  []string{"Warning InvalidTemplate Machines cannot be created: could not find network \"private\""},
+		},
+		{
+			name:                "with unchanged invalid template",
+			networksErr:         fmt.Errorf("could not find network \"private\""),
+			existingAnnotations: map[string]string{templateValidKey: "False", templateMessageKey: `could not find network "private"`},
+			expectedValid:       "False",
+			expectedMessage:     `could not find network "private"`,
+		},
+		{
+			name:            "with server group of another policy",
+			serverGroup:     &servergroups.ServerGroup{Name: "workers", Policy: &softAffinity},
+			expectedValid:   "True",
+			expectedMessage: "server group \"workers\" has policy soft-affinity instead of soft-anti-affinity",
+			expectedEvents:  []string{"Warning TemplateWarning server group \"workers\" has policy soft-affinity instead of soft-anti-affinity"},
+		},
+		{
+			name:                "with fixed template",
+			existingAnnotations: map[string]string{templateValidKey: "False", templateMessageKey: `could not find network "private"`},
+			expectedValid:       "True",
+			expectedEvents:      []string{"Normal TemplateValid All the OpenStack resources of the template were found"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			g := NewWithT(tt)
+
+			instanceService := &MockInstanceService{
+				flavor:      &mockFlavor,
+				image:       &mockImage,
+				networksErr: tc.networksErr,
+				serverGroup: tc.serverGroup,
+			}
+			fakeRecorder := record.NewFakeRecorder(4)
+			r := Reconciler{
+				eventRecorder: fakeRecorder,
+				flavorCache:   newMachineFlavorCache(),
+			}
+
+			machineSet, err := newTestMachineSet("default", validFlavorName, tc.existingAnnotations)
+			g.Expect(err).ToNot(HaveOccurred())
+			pSpec, err := machineproviderv1.MachineSpecFromProviderSpec(machineSet.Spec.Template.Spec.ProviderSpec)
+			g.Expect(err).ToNot(HaveOccurred())
+			pSpec.Image = tc.image
+			machineSet.Spec.Template.Spec.ProviderSpec, err = providerSpecFromMachine(pSpec)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.reconcile(machineSet, testCloud, instanceService)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(machineSet.Annotations[templateValidKey]).To(Equal(tc.expectedValid))
+			g.Expect(machineSet.Annotations[templateMessageKey]).To(Equal(tc.expectedMessage))
+
+			close(fakeRecorder.Events)
+			var events []string
+			for event := range fakeRecorder.Events {
+				events = append(events, event)
+			}
+			g.Expect(events).To(Equal(tc.expectedEvents))
+		})
+	}
+}
+
+func TestReconcileQuota(t *testing.T) {
+	shortage := clients.QuotaShortage{Service: "compute", Resource: "cores", Requested: 4, InUse: 30, Limit: 32}
+
+	testCases := []struct {
+		name                string
+		shortages           []clients.QuotaShortage
+		quotaErr            error
+		existingAnnotations map[string]string
+		expectedAnnotations map[string]string
+	}{
+		{
+			name:                "with exceeded quota",
+			shortages:           []clients.QuotaShortage{shortage},
+			existingAnnotations: make(map[string]string),
+			expectedAnnotations: map[string]string{
+				cpuKey:           strconv.Itoa(mockFlavor.VCPUs),
+				memoryKey:        strconv.Itoa(mockFlavor.RAM),
+				gpuKey:           "0",
+				labelsKey:        "kubernetes.io/arch=amd64",
+				ephemeralDiskKey: "200Gi",
+				templateValidKey: "True",
+				quotaKey:         shortage.String(),
+			},
+		},
+		{
+			name: "with quota available again",
+			existingAnnotations: map[string]string{
+				quotaKey: shortage.String(),
+			},
+			expectedAnnotations: map[string]string{
+				cpuKey:           strconv.Itoa(mockFlavor.VCPUs),
+				memoryKey:        strconv.Itoa(mockFlavor.RAM),
+				gpuKey:           "0",
+				labelsKey:        "kubernetes.io/arch=amd64",
+				ephemeralDiskKey: "200Gi",
+				templateValidKey: "True",
+			},
+		},
+		{
+			name:     "with quota which cannot be read",
+			quotaErr: fmt.Errorf("forbidden"),
+			existingAnnotations: map[string]string{
+				quotaKey: shortage.String(),
+			},
+			expectedAnnotations: map[string]string{
+				cpuKey:           strconv.Itoa(mockFlavor.VCPUs),
+				memoryKey:        strconv.Itoa(mockFlavor.RAM),
+				gpuKey:           "0",
+				labelsKey:        "kubernetes.io/arch=amd64",
+				ephemeralDiskKey: "200Gi",
+				templateValidKey: "True",
+				quotaKey:         shortage.String(),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			g := NewWithT(tt)
+
+			instanceService := &MockInstanceService{
+				flavor:    &mockFlavor,
+				shortages: tc.shortages,
+				quotaErr:  tc.quotaErr,
+			}
+			r := Reconciler{
+				flavorCache: newMachineFlavorCache(),
+			}
+
+			machineSet, err := newTestMachineSet("default", validFlavorName, tc.existingAnnotations)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.reconcile(machineSet, testCloud, instanceService)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(machineSet.Annotations).To(Equal(tc.expectedAnnotations))
+		})
+	}
+}
+
 func newTestMachineSet(namespace string, flavor string, existingAnnotations map[string]string) (*machinev1.MachineSet, error) {
 	// Copy anntotations map so we don't modify the input
 	annotations := make(map[string]string)
@@ -346,3 +701,35 @@ func providerSpecFromMachine(in *machineproviderv1.OpenstackProviderSpec) (machi
 		Value: &runtime.RawExtension{Raw: bytes},
 	}, nil
 }
+
+func TestSetMachineSetCloud(t *testing.T) {
+	g := NewWithT(t)
+
+	otherRegion := testCloud
+	otherRegion.region = "regionTwo"
+	first := types.NamespacedName{Namespace: "openshift-machine-api", Name: "first"}
+	second := types.NamespacedName{Namespace: "openshift-machine-api", Name: "second"}
+
+	r := &Reconciler{
+		flavorCache: newMachineFlavorCache(),
+		instanceServices: map[cloudKey]cachedInstanceService{
+			testCloud:   {},
+			otherRegion: {},
+		},
+	}
+	r.flavorCache.getFlavorInfo(&mockOSInstance, testCloud, knownInCacheFlavor.Name)
+
+	r.setMachineSetCloud(first, &testCloud)
+	r.setMachineSetCloud(second, &testCloud)
+
+	// The cloud is still used by the second MachineSet
+	r.setMachineSetCloud(first, &otherRegion)
+	g.Expect(r.instanceServices).To(HaveKey(testCloud))
+	g.Expect(r.flavorCache.cache).To(HaveLen(1))
+
+	r.setMachineSetCloud(second, nil)
+	g.Expect(r.instanceServices).NotTo(HaveKey(testCloud))
+	g.Expect(r.instanceServices).To(HaveKey(otherRegion))
+	g.Expect(r.flavorCache.services).NotTo(HaveKey(testCloud))
+	g.Expect(r.flavorCache.cache).To(BeEmpty())
+}
@@ -0,0 +1,110 @@
+package machineset
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/servergroups"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	openstackconfigv1 "sigs.k8s.io/cluster-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+const (
+	// MachineSetStatus has no conditions, the result of the validation of the
+	// template against the cloud is kept in annotations instead.
+	templateValidKey   = "machine.openshift.io/openstack-template-valid"
+	templateMessageKey = "machine.openshift.io/openstack-template-message"
+
+	// serverGroupPolicy is the policy of the server groups created by the
+	// machine controller.
+	serverGroupPolicy = "soft-anti-affinity"
+)
+
+// validateTemplate checks that the resources referenced by the provider spec
+// of the MachineSet template exist in the cloud. It returns the problems which
+// prevent machines from being created, and the ones which do not.
+func validateTemplate(instanceService OpenStackInstanceService, pSpec *openstackconfigv1.OpenstackProviderSpec, flavorInfo *flavors.Flavor) (errs []string, warnings []string) {
+	if flavorInfo == nil {
+		errs = append(errs, fmt.Sprintf("could not find flavor %q", pSpec.Flavor))
+	}
+
+	imageName, imageFilter := clients.ImageOf(pSpec)
+	if imageName != "" || imageFilter != nil {
+		image, err := instanceService.FindImage(imageName, imageFilter)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("could not find image %q: %v", imageName, err))
+		} else if clients.ImageNotReady(image) {
+			warnings = append(warnings, fmt.Sprintf("image %q is in %s status", image.Name, image.Status))
+		} else if image.Status != images.ImageStatusActive {
+			errs = append(errs, fmt.Sprintf("image %q is in %s status", image.Name, image.Status))
+		}
+	}
+
+	for _, zone := range append([]string{pSpec.AvailabilityZone}, pSpec.FallbackAvailabilityZones...) {
+		if err := instanceService.DoesAvailabilityZoneExist(zone); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if err := instanceService.DoNetworksExist(pSpec.Networks); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := instanceService.DoSecurityGroupsExist(pSpec.SecurityGroups); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	serverGroup, err := instanceService.GetServerGroup(pSpec.ServerGroupID, pSpec.ServerGroupName)
+	if err != nil {
+		errs = append(errs, err.Error())
+	} else if serverGroup != nil && serverGroupPolicyOf(serverGroup) != serverGroupPolicy {
+		warnings = append(warnings, fmt.Sprintf("server group %q has policy %s instead of %s", serverGroup.Name, serverGroupPolicyOf(serverGroup), serverGroupPolicy))
+	}
+
+	return errs, warnings
+}
+
+// serverGroupPolicyOf returns the policy of a server group, which depending
+// on the compute microversion is in Policy or Policies.
+func serverGroupPolicyOf(serverGroup *servergroups.ServerGroup) string {
+	if serverGroup.Policy != nil {
+		return *serverGroup.Policy
+	}
+	return strings.Join(serverGroup.Policies, ",")
+}
+
+// reconcileValidation validates the template of the MachineSet and reports the
+// result in annotations, and in events when the result changes.
+func (r *Reconciler) reconcileValidation(machineSet *machinev1.MachineSet, instanceService OpenStackInstanceService, pSpec *openstackconfigv1.OpenstackProviderSpec, flavorInfo *flavors.Flavor) {
+	errs, warnings := validateTemplate(instanceService, pSpec, flavorInfo)
+
+	valid := "True"
+	message := strings.Join(append(errs, warnings...), "; ")
+	if len(errs) > 0 {
+		valid = "False"
+	}
+
+	previousValid, known := machineSet.Annotations[templateValidKey]
+	changed := previousValid != valid || machineSet.Annotations[templateMessageKey] != message
+
+	machineSet.Annotations[templateValidKey] = valid
+	if message == "" {
+		delete(machineSet.Annotations, templateMessageKey)
+	} else {
+		machineSet.Annotations[templateMessageKey] = message
+	}
+
+	if !changed || r.eventRecorder == nil {
+		return
+	}
+	switch {
+	case len(errs) > 0:
+		r.eventRecorder.Eventf(machineSet, corev1.EventTypeWarning, "InvalidTemplate", "Machines cannot be created: %s", message)
+	case len(warnings) > 0:
+		r.eventRecorder.Eventf(machineSet, corev1.EventTypeWarning, "TemplateWarning", "%s", message)
+	case known:
+		r.eventRecorder.Eventf(machineSet, corev1.EventTypeNormal, "TemplateValid", "All the OpenStack resources of the template were found")
+	}
+}
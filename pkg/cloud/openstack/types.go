@@ -28,6 +28,7 @@ import (
 type ActuatorParams struct {
 	KubeClient    kubernetes.Interface
 	Client        client.Client
+	APIReader     client.Reader
 	ConfigClient  configclient.ConfigV1Interface
 	EventRecorder record.EventRecorder
 	Scheme        *runtime.Scheme
@@ -53,6 +53,7 @@ func getActuatorParams(mgr manager.Manager) openstack.ActuatorParams {
 
 	return openstack.ActuatorParams{
 		Client:        mgr.GetClient(),
+		APIReader:     mgr.GetAPIReader(),
 		KubeClient:    kubeClient,
 		ConfigClient:  configClient,
 		Scheme:        mgr.GetScheme(),